@@ -3,29 +3,86 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	/*schedule timezones must resolve in the alpine image, which ships without tzdata*/
+	_ "time/tzdata"
+
 	"github.com/blueberry-adii/tickr/internal/api"
 	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/metrics"
 	"github.com/blueberry-adii/tickr/internal/scheduler"
+	"github.com/blueberry-adii/tickr/internal/tracing"
 	"github.com/blueberry-adii/tickr/internal/worker"
 )
 
+/*
+Logs the error which keeps the server from starting and exits
+*/
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+/*
+Queues served when QUEUES isn't set: the default queue with 5 workers
+*/
+const defaultQueues = "default:5"
+
+/*
+A named queue and the size of its worker pool
+*/
+type queueConfig struct {
+	name    string
+	workers int
+}
+
+/*
+Parses the QUEUES env var, a comma separated list of name:workers,
+e.g. "default:5,bulk:2,critical:3"
+*/
+func parseQueues(spec string) ([]queueConfig, error) {
+	var queues []queueConfig
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(spec, ",") {
+		name, count, ok := strings.Cut(strings.TrimSpace(part), ":")
+		workers, err := strconv.Atoi(count)
+		if !ok || name == "" || err != nil || workers <= 0 {
+			return nil, fmt.Errorf("invalid queue %q, expected name:workers", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("queue %q listed twice", name)
+		}
+		seen[name] = true
+		queues = append(queues, queueConfig{name, workers})
+	}
+
+	return queues, nil
+}
+
 /*
 The Main function creates a context, and cancels it when all scheduler is ready to
 shut down, causing a graceful shutdown of the app.
 Initializes DB, Redis, Scheduler and API Handler and Dependency Injections
-Spawns n Workers in n goroutines for concurrent background jobs
+Spawns a pool of Workers for every queue, each worker in its own goroutine
 */
 func main() {
 
+	if err := logging.Setup(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT")); err != nil {
+		fatal("invalid LOG_LEVEL or LOG_FORMAT env var", "error", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -37,7 +94,7 @@ func main() {
 
 	go func() {
 		<-ch
-		log.Println("shutdown signal recieved")
+		slog.Info("shutdown signal recieved")
 		cancel()
 	}()
 
@@ -48,12 +105,32 @@ func main() {
 	dbName := os.Getenv("DB_NAME")
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 	redisAddr := os.Getenv("REDIS_ADDR")
+	queuesSpec := os.Getenv("QUEUES")
+	idempotencyWindow := os.Getenv("IDEMPOTENCY_WINDOW")
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	tracesExporter := os.Getenv("OTEL_TRACES_EXPORTER")
 
 	if dbPort == 0 {
-		log.Fatal("DB_PORT env var is required")
+		fatal("DB_PORT env var is required")
 	}
 	if port == 0 {
-		log.Fatal("PORT env var is required")
+		fatal("PORT env var is required")
+	}
+	if queuesSpec == "" {
+		queuesSpec = defaultQueues
+	}
+	queues, err := parseQueues(queuesSpec)
+	if err != nil {
+		fatal("invalid QUEUES env var", "error", err)
+	}
+	queueNames := make([]string, len(queues))
+	for i, queue := range queues {
+		queueNames[i] = queue.name
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracesExporter)
+	if err != nil {
+		fatal("invalid OTEL_TRACES_EXPORTER env var", "error", err)
 	}
 
 	cfg := database.Config{
@@ -65,7 +142,7 @@ func main() {
 	}
 	db, err := database.ConnectDB(cfg)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		fatal("failed to connect to database", "error", err)
 	}
 	defer db.Close()
 
@@ -73,8 +150,22 @@ func main() {
 	mux := http.NewServeMux()
 
 	redis := scheduler.NewRedis(redisAddr)
-	scheduler := scheduler.NewScheduler(redis, repository)
-	handler := api.NewHandler(scheduler)
+	scheduler := scheduler.NewScheduler(redis, repository, queueNames...)
+	/*custom job types are added with registry.Register before the workers start*/
+	registry := worker.DefaultRegistry()
+	handler := api.NewHandler(scheduler, registry)
+	metrics.Registry.MustRegister(scheduler.DepthCollector())
+	if idempotencyWindow != "" {
+		window, err := time.ParseDuration(idempotencyWindow)
+		if err != nil || window <= 0 {
+			fatal("invalid IDEMPOTENCY_WINDOW env var, expected a duration like 24h", "value", idempotencyWindow)
+		}
+		handler.IdempotencyWindow = window
+	}
+	handler.AdminKey = adminKey
+	if adminKey == "" {
+		slog.Warn("ADMIN_API_KEY is not set, only API keys stored in MySQL are accepted")
+	}
 
 	wg.Add(1)
 	go func() {
@@ -82,41 +173,75 @@ func main() {
 		scheduler.Run(ctx)
 	}()
 
-	for i := 0; i < 5; i++ {
-		worker := worker.NewWorker(i+1, scheduler)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			worker.Run(ctx)
-		}()
+	/*worker IDs are unique across all pools*/
+	workerID := 0
+	for _, queue := range queues {
+		for range queue.workers {
+			workerID++
+			worker := worker.NewWorker(workerID, queue.name, scheduler, registry)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				worker.Run(ctx)
+			}()
+		}
 	}
 
+	/*scraped every few seconds, so left out of the request log*/
+	mux.Handle("GET /metrics", handler.Auth(enums.AdminScope, handler.Metrics))
 	mux.Handle("GET /api/v2/health", api.Logging(handler.Health))
-	mux.Handle("POST /api/v2/jobs", api.Logging(handler.SubmitJob))
+	mux.Handle("POST /api/v2/jobs", api.Logging(handler.Auth(enums.SubmitScope, handler.SubmitJob)))
+	mux.Handle("POST /api/v2/jobs/batch", api.Logging(handler.Auth(enums.SubmitScope, handler.SubmitBatch)))
+	mux.Handle("GET /api/v2/jobs", api.Logging(handler.Auth(enums.ReadScope, handler.ListJobs)))
+	mux.Handle("GET /api/v2/jobs/{id}", api.Logging(handler.Auth(enums.ReadScope, handler.GetJob)))
+	mux.Handle("DELETE /api/v2/jobs/{id}", api.Logging(handler.Auth(enums.SubmitScope, handler.CancelJob)))
+	mux.Handle("GET /api/v2/jobs/{id}/attempts", api.Logging(handler.Auth(enums.ReadScope, handler.GetJobAttempts)))
+	mux.Handle("GET /api/v2/jobs/{id}/events", api.Logging(handler.Auth(enums.ReadScope, handler.JobEvents)))
+	mux.Handle("GET /api/v2/events", api.Logging(handler.Auth(enums.ReadScope, handler.Events)))
+	mux.Handle("POST /api/v2/jobs/{id}/replay", api.Logging(handler.Auth(enums.SubmitScope, handler.ReplayJob)))
+	mux.Handle("GET /api/v2/dead-letter", api.Logging(handler.Auth(enums.ReadScope, handler.ListDeadLetter)))
+	mux.Handle("POST /api/v2/dead-letter/replay", api.Logging(handler.Auth(enums.AdminScope, handler.ReplayDeadLetter)))
+	mux.Handle("GET /api/v2/batches/{id}", api.Logging(handler.Auth(enums.ReadScope, handler.GetBatch)))
+	mux.Handle("POST /api/v2/schedules", api.Logging(handler.Auth(enums.SubmitScope, handler.CreateSchedule)))
+	mux.Handle("GET /api/v2/schedules", api.Logging(handler.Auth(enums.ReadScope, handler.ListSchedules)))
+	mux.Handle("GET /api/v2/schedules/{id}", api.Logging(handler.Auth(enums.ReadScope, handler.GetSchedule)))
+	mux.Handle("DELETE /api/v2/schedules/{id}", api.Logging(handler.Auth(enums.SubmitScope, handler.DeleteSchedule)))
+	mux.Handle("POST /api/v2/api-keys", api.Logging(handler.Auth(enums.AdminScope, handler.CreateAPIKey)))
+	mux.Handle("GET /api/v2/api-keys", api.Logging(handler.Auth(enums.AdminScope, handler.ListAPIKeys)))
+	mux.Handle("DELETE /api/v2/api-keys/{id}", api.Logging(handler.Auth(enums.AdminScope, handler.RevokeAPIKey)))
+	mux.Handle("GET /api/v2/tenants", api.Logging(handler.Auth(enums.AdminScope, handler.ListTenants)))
+	mux.Handle("PUT /api/v2/tenants/{name}", api.Logging(handler.Auth(enums.AdminScope, handler.SaveTenant)))
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Handler: api.Tracing(mux),
 	}
 
 	go func() {
-		log.Printf("listening on Port %d", port)
+		slog.Info("listening", "port", port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
+			fatal("server error", "error", err)
 		}
 	}()
 
 	<-ctx.Done()
 
-	log.Println("shutting down http server")
+	slog.Info("shutting down http server")
 
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("http shutdown error: %v", err)
+		slog.Error("http shutdown error", "error", err)
 	}
 
 	wg.Wait()
-	log.Println("graceful shutdown complete")
+
+	/*flushes the spans of the jobs persisted during shutdown*/
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTracing()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("tracing shutdown error", "error", err)
+	}
+	slog.Info("graceful shutdown complete")
 }
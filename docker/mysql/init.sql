@@ -1,21 +1,130 @@
 CREATE DATABASE IF NOT EXISTS tickr;
 USE tickr;
 
+-- only runs on an empty volume, columns and indexes added to existing tables go in upgrade.sql as well
+
 CREATE TABLE IF NOT EXISTS jobs (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
     job_type VARCHAR(100) NOT NULL,
     payload JSON NOT NULL,
     result JSON NULL,
     status VARCHAR(20) NOT NULL,
+    queue VARCHAR(64) NOT NULL DEFAULT 'default',
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
     attempt INT NOT NULL DEFAULT 0,
     max_attempts INT NOT NULL DEFAULT 3,
+    timeout_seconds INT NOT NULL DEFAULT 0,
+    retry_policy JSON NULL,
     scheduled_at DATETIME NOT NULL,
     created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     started_at DATETIME NULL,
     finished_at DATETIME NULL,
     last_error TEXT NULL,
     worker_id INT NULL,
+    leased_until DATETIME NULL,
+    -- random per claim, worker IDs restart at 1 on every instance so they can't tell claims apart
+    lease_token CHAR(32) NULL,
+    schedule_id BIGINT NULL,
+    idempotency_key VARCHAR(255) NULL,
+    unique_key VARCHAR(255) NULL,
+    batch_id BIGINT NULL,
+    callback_url VARCHAR(2048) NULL,
+    callback_secret VARCHAR(255) NULL,
+    trace_parent VARCHAR(55) NULL,
+    request_id VARCHAR(64) NULL,
+    api_key_id BIGINT NULL,
+    -- unique_key while the job is active or blocked on its dependencies, released as soon as it completes, fails or is cancelled
+    active_unique_key VARCHAR(255) AS (IF(status IN ('pending', 'retrying', 'executing', 'blocked'), unique_key, NULL)) STORED,
     INDEX idx_status (status),
     INDEX idx_scheduled_at (scheduled_at),
-    INDEX idx_worker_id (worker_id)
-);
\ No newline at end of file
+    INDEX idx_worker_id (worker_id),
+    INDEX idx_queue_status (queue, status),
+    INDEX idx_tenant_status (tenant, status),
+    INDEX idx_batch_id (batch_id),
+    INDEX idx_request_id (request_id),
+    INDEX idx_api_key_id (api_key_id),
+    UNIQUE KEY uq_schedule_tick (schedule_id, scheduled_at),
+    -- idempotency and unique keys only clash with the keys of the same tenant
+    UNIQUE KEY uq_idempotency_key (tenant, idempotency_key),
+    UNIQUE KEY uq_active_unique_key (tenant, active_unique_key)
+);
+
+CREATE TABLE IF NOT EXISTS batches (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    total INT NOT NULL,
+    on_complete JSON NULL,
+    on_complete_job_id BIGINT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at DATETIME NULL
+);
+
+-- a blocked job waits for every job it depends on to complete
+CREATE TABLE IF NOT EXISTS job_dependencies (
+    job_id BIGINT NOT NULL,
+    depends_on BIGINT NOT NULL,
+    PRIMARY KEY (job_id, depends_on),
+    INDEX idx_depends_on (depends_on)
+);
+
+CREATE TABLE IF NOT EXISTS job_errors (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    job_id BIGINT NOT NULL,
+    attempt INT NOT NULL,
+    error TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_job_id (job_id)
+);
+
+CREATE TABLE IF NOT EXISTS job_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    job_id BIGINT NOT NULL,
+    attempt INT NOT NULL,
+    worker_id INT NULL,
+    status VARCHAR(20) NOT NULL,
+    started_at DATETIME(3) NULL,
+    finished_at DATETIME(3) NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    error TEXT NULL,
+    result_snippet VARCHAR(512) NULL,
+    next_retry_at DATETIME NULL,
+    INDEX idx_job_id (job_id)
+);
+
+CREATE TABLE IF NOT EXISTS schedules (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    cron_expr VARCHAR(100) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    job_type VARCHAR(100) NOT NULL,
+    payload JSON NOT NULL,
+    queue VARCHAR(64) NOT NULL DEFAULT 'default',
+    next_run_at DATETIME NOT NULL,
+    last_run_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_next_run_at (next_run_at),
+    INDEX idx_tenant (tenant)
+);
+
+-- only the SHA-256 hash of a key is stored, the key itself is shown once when it is created
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes JSON NOT NULL,
+    job_types JSON NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY uq_key_hash (key_hash)
+);
+
+-- quotas of a tenant, 0 means unlimited. Tenants without a row have no quotas
+CREATE TABLE IF NOT EXISTS tenants (
+    name VARCHAR(64) PRIMARY KEY,
+    max_running INT NOT NULL DEFAULT 0,
+    rate_limit INT NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,108 @@
+-- Brings a database created by an older init.sql up to date, safe to run any number of times.
+-- init.sql only runs on an empty volume, so run both on existing databases after every upgrade:
+--   cat docker/mysql/init.sql docker/mysql/upgrade.sql | docker compose exec -T mysql mysql -uroot -ppass
+-- init.sql creates the tables which are missing, this adds the columns and indexes missing from the others.
+USE tickr;
+
+DELIMITER //
+
+DROP PROCEDURE IF EXISTS tickr_add_column //
+CREATE PROCEDURE tickr_add_column(tbl VARCHAR(64), col VARCHAR(64), definition TEXT)
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = DATABASE() AND table_name = tbl AND column_name = col
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' ADD COLUMN ', col, ' ', definition);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+-- redefines a generated column whose expression doesn't mention expected yet
+DROP PROCEDURE IF EXISTS tickr_regenerate_column //
+CREATE PROCEDURE tickr_regenerate_column(tbl VARCHAR(64), col VARCHAR(64), expected VARCHAR(64), definition TEXT)
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = DATABASE() AND table_name = tbl AND column_name = col
+            AND generation_expression NOT LIKE CONCAT('%', expected, '%')
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' MODIFY COLUMN ', col, ' ', definition);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+-- adds the index, or recreates it when it exists over other columns than cols
+DROP PROCEDURE IF EXISTS tickr_add_index //
+CREATE PROCEDURE tickr_add_index(tbl VARCHAR(64), idx VARCHAR(64), cols VARCHAR(255), definition TEXT)
+BEGIN
+    DECLARE current_cols VARCHAR(255);
+
+    SELECT GROUP_CONCAT(column_name ORDER BY seq_in_index) INTO current_cols
+    FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = tbl AND index_name = idx;
+
+    SET @ddl = NULL;
+    IF current_cols IS NULL THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' ADD ', definition);
+    ELSEIF current_cols <> cols THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' DROP INDEX ', idx, ', ADD ', definition);
+    END IF;
+
+    IF @ddl IS NOT NULL THEN
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+DELIMITER ;
+
+CALL tickr_add_column('jobs', 'tenant', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id");
+CALL tickr_add_column('jobs', 'queue', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER status");
+CALL tickr_add_column('jobs', 'priority', "VARCHAR(10) NOT NULL DEFAULT 'normal' AFTER queue");
+CALL tickr_add_column('jobs', 'timeout_seconds', 'INT NOT NULL DEFAULT 0 AFTER max_attempts');
+CALL tickr_add_column('jobs', 'retry_policy', 'JSON NULL AFTER timeout_seconds');
+CALL tickr_add_column('jobs', 'leased_until', 'DATETIME NULL AFTER worker_id');
+CALL tickr_add_column('jobs', 'lease_token', 'CHAR(32) NULL AFTER leased_until');
+CALL tickr_add_column('jobs', 'schedule_id', 'BIGINT NULL AFTER lease_token');
+CALL tickr_add_column('jobs', 'idempotency_key', 'VARCHAR(255) NULL AFTER schedule_id');
+CALL tickr_add_column('jobs', 'unique_key', 'VARCHAR(255) NULL AFTER idempotency_key');
+CALL tickr_add_column('jobs', 'batch_id', 'BIGINT NULL AFTER unique_key');
+CALL tickr_add_column('jobs', 'callback_url', 'VARCHAR(2048) NULL AFTER batch_id');
+CALL tickr_add_column('jobs', 'callback_secret', 'VARCHAR(255) NULL AFTER callback_url');
+CALL tickr_add_column('jobs', 'trace_parent', 'VARCHAR(55) NULL AFTER callback_secret');
+CALL tickr_add_column('jobs', 'request_id', 'VARCHAR(64) NULL AFTER trace_parent');
+CALL tickr_add_column('jobs', 'api_key_id', 'BIGINT NULL AFTER request_id');
+CALL tickr_add_column('jobs', 'active_unique_key',
+    "VARCHAR(255) AS (IF(status IN ('pending', 'retrying', 'executing', 'blocked'), unique_key, NULL)) STORED AFTER api_key_id");
+CALL tickr_regenerate_column('jobs', 'active_unique_key', 'blocked',
+    "VARCHAR(255) AS (IF(status IN ('pending', 'retrying', 'executing', 'blocked'), unique_key, NULL)) STORED");
+
+CALL tickr_add_index('jobs', 'idx_queue_status', 'queue,status', 'INDEX idx_queue_status (queue, status)');
+CALL tickr_add_index('jobs', 'idx_tenant_status', 'tenant,status', 'INDEX idx_tenant_status (tenant, status)');
+CALL tickr_add_index('jobs', 'idx_batch_id', 'batch_id', 'INDEX idx_batch_id (batch_id)');
+CALL tickr_add_index('jobs', 'idx_request_id', 'request_id', 'INDEX idx_request_id (request_id)');
+CALL tickr_add_index('jobs', 'idx_api_key_id', 'api_key_id', 'INDEX idx_api_key_id (api_key_id)');
+CALL tickr_add_index('jobs', 'uq_schedule_tick', 'schedule_id,scheduled_at', 'UNIQUE KEY uq_schedule_tick (schedule_id, scheduled_at)');
+CALL tickr_add_index('jobs', 'uq_idempotency_key', 'tenant,idempotency_key', 'UNIQUE KEY uq_idempotency_key (tenant, idempotency_key)');
+CALL tickr_add_index('jobs', 'uq_active_unique_key', 'tenant,active_unique_key', 'UNIQUE KEY uq_active_unique_key (tenant, active_unique_key)');
+
+CALL tickr_add_column('batches', 'tenant', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id");
+CALL tickr_add_column('batches', 'on_complete', 'JSON NULL AFTER total');
+CALL tickr_add_column('batches', 'on_complete_job_id', 'BIGINT NULL AFTER on_complete');
+CALL tickr_add_column('batches', 'completed_at', 'DATETIME NULL AFTER created_at');
+
+CALL tickr_add_column('schedules', 'tenant', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id");
+CALL tickr_add_column('schedules', 'queue', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER payload");
+CALL tickr_add_index('schedules', 'idx_tenant', 'tenant', 'INDEX idx_tenant (tenant)');
+
+CALL tickr_add_column('api_keys', 'tenant', "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id");
+
+DROP PROCEDURE tickr_add_column;
+DROP PROCEDURE tickr_regenerate_column;
+DROP PROCEDURE tickr_add_index;
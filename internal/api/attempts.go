@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+)
+
+/*
+Returns every recorded attempt of the job with the ID given in the URL path, oldest first
+*/
+func (h *Handler) GetJobAttempts(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.tenantJob(r, jobID); errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := h.scheduler.GetJobAttempts(r.Context(), jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Job Attempts Found",
+		Data: map[string]any{
+			"jobID":    jobID,
+			"attempts": attempts,
+		},
+		Success: true,
+	})
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Every generated API key starts with this, so leaked keys are easy to spot
+*/
+const apiKeyPrefix = "tickr_"
+
+/*
+Length of the start of a key kept in clear to tell keys apart
+*/
+const apiKeyPrefixLen = len(apiKeyPrefix) + 8
+
+/*
+Returned by newJob when the API key of the request may not submit the job's type,
+the client gets a 403
+*/
+var errJobTypeForbidden = errors.New("API key can't submit jobtype")
+
+type apiKeyCtx struct{}
+
+/*
+This is responsible for authenticating the requests with an API key, sent as
+`Authorization: Bearer <key>` or `X-API-Key: <key>`, and checking the key was given scope.
+The key is passed on in the request context, so handlers can check which job types it may submit
+and only show the jobs of its tenant
+*/
+func (h *Handler) Auth(scope enums.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw := requestAPIKey(r)
+		if raw == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tickr"`)
+			http.Error(w, "Missing API Key", http.StatusUnauthorized)
+			return
+		}
+
+		key, err := h.authenticate(r.Context(), raw)
+		if errors.Is(err, database.ErrAPIKeyNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tickr"`)
+			http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !key.HasScope(scope) {
+			http.Error(w, "API key lacks the "+string(scope)+" scope", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, key)))
+	}
+}
+
+/*
+Returns the API key sent with the request, "" if there is none
+*/
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, key, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+		return ""
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+/*
+Returns the active key matching raw: the admin key the server was started with,
+which belongs to the default tenant, or a key stored in MySQL which hasn't been revoked
+*/
+func (h *Handler) authenticate(ctx context.Context, raw string) (*jobs.APIKey, error) {
+	hash := hashAPIKey(raw)
+	if h.AdminKey != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKey(h.AdminKey))) == 1 {
+		return &jobs.APIKey{Tenant: jobs.DefaultTenant, Name: "admin", Scopes: []enums.Scope{enums.AdminScope}}, nil
+	}
+
+	key, err := h.scheduler.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, database.ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+/*
+Returns the API key the request was authenticated with, nil if the route isn't authenticated
+*/
+func requestKey(r *http.Request) *jobs.APIKey {
+	key, _ := r.Context().Value(apiKeyCtx{}).(*jobs.APIKey)
+	return key
+}
+
+/*
+Reports whether the key may submit jobs of jobType, any job type is allowed without a key
+*/
+func allowsJobType(key *jobs.APIKey, jobType string) bool {
+	return key == nil || key.AllowsJobType(jobType)
+}
+
+/*
+Reports whether the key may create a key with the scopes and job types, it only hands out what it holds itself:
+a key limited to some job types can't create one for others or for every job type.
+Anything may be granted without a key
+*/
+func mayGrant(key *jobs.APIKey, scopes []enums.Scope, jobTypes []string) bool {
+	if key == nil {
+		return true
+	}
+	for _, scope := range scopes {
+		if !key.HasScope(scope) {
+			return false
+		}
+	}
+	if len(key.JobTypes) > 0 && len(jobTypes) == 0 {
+		return false
+	}
+	for _, jobType := range jobTypes {
+		if !key.AllowsJobType(jobType) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Returns the tenant whose jobs the key sees, the default tenant without a key
+*/
+func keyTenant(key *jobs.APIKey) string {
+	if key == nil {
+		return jobs.DefaultTenant
+	}
+	return jobs.TenantName(key.Tenant)
+}
+
+/*
+Returns the tenant of the API key the request was authenticated with
+*/
+func requestTenant(r *http.Request) string {
+	return keyTenant(requestKey(r))
+}
+
+/*
+Reports whether the key may manage every tenant, as can requests to routes which aren't authenticated
+*/
+func isOperator(key *jobs.APIKey) bool {
+	return key == nil || key.IsOperator()
+}
+
+/*
+Returns the hex encoded SHA-256 hash of an API key, the only form it is stored in.
+Keys are random, so a fast hash is enough to keep them from being read back
+*/
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Returns a new random API key
+*/
+func generateAPIKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return apiKeyPrefix + hex.EncodeToString(b)
+}
+
+/*
+Creates an API key of a tenant with the scopes and job types from the http request,
+the tenant of the key creating it when none is given. Only admin keys of the default tenant
+may create keys of other tenants, and no key may grant scopes or job types it doesn't hold. The key is only returned in this response, it is stored hashed
+*/
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Tenant   string        `json:"tenant"`
+		Name     string        `json:"name"`
+		Scopes   []enums.Scope `json:"scopes"`
+		JobTypes []string      `json:"jobTypes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid Body Format", http.StatusBadRequest)
+		return
+	}
+
+	if body.Tenant == "" {
+		body.Tenant = requestTenant(r)
+	}
+	if err := jobs.ValidateTenant(body.Tenant); err != nil {
+		http.Error(w, "Invalid tenant: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isOperator(requestKey(r)) && body.Tenant != requestTenant(r) {
+		http.Error(w, "API key can't manage tenant "+body.Tenant, http.StatusForbidden)
+		return
+	}
+
+	if body.Name == "" || len(body.Name) > maxKeyLen {
+		http.Error(w, "name is required and can't be longer than 255 characters", http.StatusBadRequest)
+		return
+	}
+	if len(body.Scopes) == 0 {
+		http.Error(w, "scopes can't be empty", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !scope.IsValid() {
+			http.Error(w, "Invalid scope: "+string(scope), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, jobType := range body.JobTypes {
+		if !h.jobTypes.Has(jobType) {
+			http.Error(w, "Unknown jobtype: "+jobType, http.StatusBadRequest)
+			return
+		}
+	}
+	if !mayGrant(requestKey(r), body.Scopes, body.JobTypes) {
+		http.Error(w, "API key can't grant scopes or job types it doesn't hold", http.StatusForbidden)
+		return
+	}
+
+	raw := generateAPIKey()
+	key := jobs.APIKey{
+		Tenant:    body.Tenant,
+		Name:      body.Name,
+		Prefix:    raw[:apiKeyPrefixLen],
+		KeyHash:   hashAPIKey(raw),
+		Scopes:    body.Scopes,
+		JobTypes:  body.JobTypes,
+		CreatedAt: time.Now(),
+	}
+
+	var err error
+	key.ID, err = h.scheduler.SaveAPIKey(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "API Key Created!!!",
+		Data: map[string]any{
+			"key":    raw,
+			"apiKey": key,
+		},
+		Success: true,
+	})
+}
+
+/*
+Lists the API keys of the tenant, revoked ones included. Keys are only shown by their prefix.
+Admin keys of the default tenant list the keys of every tenant, or of the tenant query parameter
+*/
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	if isOperator(requestKey(r)) {
+		tenant = r.URL.Query().Get("tenant")
+	}
+
+	keys, err := h.scheduler.ListAPIKeys(r.Context(), tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []jobs.APIKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "API Keys Found",
+		Data:    keys,
+		Success: true,
+	})
+}
+
+/*
+Revokes the API key with the ID given in the URL path,
+requests made with it are rejected from now on.
+Keys of other tenants are not found, unless the request is made with an admin key of the default tenant
+*/
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API Key ID", http.StatusBadRequest)
+		return
+	}
+
+	tenant := requestTenant(r)
+	if isOperator(requestKey(r)) {
+		tenant = ""
+	}
+
+	err = h.scheduler.RevokeAPIKey(r.Context(), keyID, tenant)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		http.Error(w, "API Key Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "API Key Revoked",
+		Data: map[string]any{
+			"apiKeyID": keyID,
+		},
+		Success: true,
+	})
+}
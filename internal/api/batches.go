@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Most jobs accepted by a single batch submission
+*/
+const maxBatchSize = 10000
+
+/*
+Outcome of a single job of a batch submission,
+either the created job or the reason it was rejected
+*/
+type batchResult struct {
+	Index  int          `json:"index"`
+	JobID  int64        `json:"jobID,omitempty"`
+	Status enums.Status `json:"status,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+/*
+Takes an array of jobs from http request and validates every job on its own.
+The valid jobs are saved as one batch in a single transaction and pushed onto
+their queues in a single round trip, invalid jobs are reported by their index.
+The returned batch ID gives the progress of the whole batch.
+The optional onComplete job or webhook runs once every job of the batch reached a final status
+*/
+func (h *Handler) SubmitBatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Jobs       []jobRequest     `json:"jobs"`
+		OnComplete *jobs.OnComplete `json:"onComplete"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid Body Format", http.StatusBadRequest)
+		return
+	}
+	if len(body.Jobs) == 0 {
+		http.Error(w, "jobs can't be empty", http.StatusBadRequest)
+		return
+	}
+	if len(body.Jobs) > maxBatchSize {
+		http.Error(w, "A batch can't have more than "+strconv.Itoa(maxBatchSize)+" jobs", http.StatusBadRequest)
+		return
+	}
+	if body.OnComplete != nil {
+		if err := h.validateOnComplete(body.OnComplete, requestKey(r)); errors.Is(err, errJobTypeForbidden) {
+			http.Error(w, "Invalid onComplete: "+err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, "Invalid onComplete: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	results := make([]batchResult, len(body.Jobs))
+	var batch []jobs.Job
+	var indexes []int
+
+	for i, req := range body.Jobs {
+		results[i].Index = i
+		job, err := h.newJob(req, requestKey(r), now)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		batch = append(batch, job)
+		indexes = append(indexes, i)
+	}
+
+	var batchID *int64
+	if len(batch) > 0 {
+		batchInfo := jobs.Batch{Tenant: requestTenant(r), OnComplete: body.OnComplete, CreatedAt: now}
+		id, jobIDs, err := h.scheduler.SaveBatch(r.Context(), batchInfo, batch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		batchID = &id
+
+		redisJobs := make([]*jobs.RedisJob, len(batch))
+		for i := range batch {
+			batch[i].ID = jobIDs[i]
+			redisJobs[i] = batch[i].RedisJob(batch[i].ScheduledAt)
+
+			results[indexes[i]].JobID = batch[i].ID
+			results[indexes[i]].Status = batch[i].Status
+		}
+		h.scheduler.PushBatch(r.Context(), redisJobs, now)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Batch Submitted!!!",
+		Data: map[string]any{
+			"batchID":   batchID,
+			"submitted": len(batch),
+			"rejected":  len(body.Jobs) - len(batch),
+			"results":   results,
+		},
+		Success: true,
+	})
+}
+
+/*
+Checks that onComplete names either a known job type or an http(s) webhook URL,
+which the API key of the request may submit
+*/
+func (h *Handler) validateOnComplete(o *jobs.OnComplete, key *jobs.APIKey) error {
+	if (o.JobType == "") == (o.URL == "") {
+		return errors.New("expected either jobtype or url")
+	}
+	if o.JobType != "" && !h.jobTypes.Has(o.JobType) {
+		return errors.New("Unknown jobtype: " + o.JobType)
+	}
+	if o.URL != "" && !h.jobTypes.Has("http") {
+		return errors.New("webhooks need the http job type")
+	}
+	if o.URL != "" && !isWebhookURL(o.URL) {
+		return errors.New("Invalid url: " + o.URL)
+	}
+	/*a webhook is delivered by an http job*/
+	jobType := o.JobType
+	if o.URL != "" {
+		jobType = "http"
+	}
+	if !allowsJobType(key, jobType) {
+		return fmt.Errorf("%w: %s", errJobTypeForbidden, jobType)
+	}
+	if o.Queue != "" && !h.scheduler.HasQueue(o.Queue) {
+		return errors.New("Unknown queue: " + o.Queue)
+	}
+	return nil
+}
+
+/*
+Returns the batch with the ID given in the URL path
+and the number of its jobs in each status
+*/
+func (h *Handler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Batch ID", http.StatusBadRequest)
+		return
+	}
+
+	batch, err := h.scheduler.GetBatch(r.Context(), batchID)
+	/*batches of other tenants can't be told apart from missing ones*/
+	if err == nil && jobs.TenantName(batch.Tenant) != requestTenant(r) {
+		err = database.ErrBatchNotFound
+	}
+	if errors.Is(err, database.ErrBatchNotFound) {
+		http.Error(w, "Batch Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Batch Found",
+		Data:    batch,
+		Success: true,
+	})
+}
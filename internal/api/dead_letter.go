@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+A permanently failed job along with the errors of all its attempts
+*/
+type deadLetterJob struct {
+	jobs.Job
+	Errors []jobs.JobError `json:"errors"`
+}
+
+/*
+Lists permanently failed jobs with their error history,
+accepts the same query parameters and pagination as ListJobs except status
+*/
+func (h *Handler) ListDeadLetter(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Statuses = []enums.Status{enums.Failed}
+
+	list, nextCursor, err := h.listPage(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jobIDs := make([]int64, len(list))
+	for i, job := range list {
+		jobIDs[i] = job.ID
+	}
+
+	history, err := h.scheduler.GetJobErrors(r.Context(), jobIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deadLetter := make([]deadLetterJob, len(list))
+	for i, job := range list {
+		deadLetter[i] = deadLetterJob{Job: job, Errors: history[job.ID]}
+		if deadLetter[i].Errors == nil {
+			deadLetter[i].Errors = []jobs.JobError{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Dead Letter Jobs Found",
+		Data: map[string]any{
+			"jobs":       deadLetter,
+			"nextCursor": nextCursor,
+		},
+		Success: true,
+	})
+}
+
+/*
+Replays the permanently failed job with the ID given in the URL path:
+its attempts are reset and it is pushed back onto the ready queue.
+Responds with 409 if the job hasn't failed and 403 if the API key may not submit its job type
+*/
+func (h *Handler) ReplayJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.tenantJob(r, jobID)
+	if errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowsJobType(requestKey(r), job.JobType) {
+		http.Error(w, errJobTypeForbidden.Error()+": "+job.JobType, http.StatusForbidden)
+		return
+	}
+
+	err = h.scheduler.ReplayJob(r.Context(), jobID)
+	if errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrJobNotReplayable) {
+		http.Error(w, "Job has not failed", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrUniqueKeyActive) {
+		http.Error(w, "Another job with the same uniqueKey is active", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Job Replayed",
+		Data: map[string]any{
+			"jobID":  jobID,
+			"status": enums.Pending,
+		},
+		Success: true,
+	})
+}
+
+/*
+Replays every permanently failed job matching the query parameters,
+accepts the same filters as ListDeadLetter and replays at most limit jobs.
+Jobs which can't be replayed, including those of a job type the API key may not submit,
+are reported along with the reason
+*/
+func (h *Handler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Statuses = []enums.Status{enums.Failed}
+
+	list, err := h.scheduler.ListJobs(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	replayed := []int64{}
+	failed := map[int64]string{}
+
+	key := requestKey(r)
+	for _, job := range list {
+		if !allowsJobType(key, job.JobType) {
+			failed[job.ID] = errJobTypeForbidden.Error() + ": " + job.JobType
+			continue
+		}
+		if err := h.scheduler.ReplayJob(r.Context(), job.ID); err != nil {
+			failed[job.ID] = err.Error()
+			continue
+		}
+		replayed = append(replayed, job.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Dead Letter Jobs Replayed",
+		Data: map[string]any{
+			"replayed": replayed,
+			"failed":   failed,
+		},
+		Success: true,
+	})
+}
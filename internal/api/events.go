@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Interval of the comments sent on idle streams,
+which keep proxies from closing them and notice clients that went away
+*/
+const heartbeatInterval = 15 * time.Second
+
+/*
+Streams the status transitions of the job with the ID given in the URL path as Server-Sent Events.
+The first event is the job's current status, the stream ends once the job completed, failed or was cancelled
+*/
+func (h *Handler) JobEvents(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	/*subscribe before reading the job, so no transition in between is missed*/
+	events := h.scheduler.Events(r.Context())
+
+	job, err := h.tenantJob(r, jobID)
+	if errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stream, ok := newEventStream(w)
+	if !ok {
+		http.Error(w, "Streaming Unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	if err := stream.send(job.Event(time.Now())); err != nil || job.Status.IsFinal() {
+		return
+	}
+	stream.run(r, events, func(event jobs.JobEvent) (bool, bool) {
+		return event.JobID == jobID, event.Status.IsFinal()
+	})
+}
+
+/*
+Streams the status transitions of all jobs of the request's tenant as Server-Sent Events,
+optionally filtered by status, jobtype and queue like ListJobs
+*/
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	statuses := make(map[enums.Status]bool)
+	if v := q.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			status := enums.Status(strings.TrimSpace(status))
+			if !status.IsValid() {
+				http.Error(w, "Invalid status: "+string(status), http.StatusBadRequest)
+				return
+			}
+			statuses[status] = true
+		}
+	}
+	jobType := q.Get("jobtype")
+	queue := q.Get("queue")
+	tenant := requestTenant(r)
+
+	stream, ok := newEventStream(w)
+	if !ok {
+		http.Error(w, "Streaming Unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := h.scheduler.Events(r.Context())
+	stream.run(r, events, func(event jobs.JobEvent) (bool, bool) {
+		match := jobs.TenantName(event.Tenant) == tenant &&
+			(len(statuses) == 0 || statuses[event.Status]) &&
+			(jobType == "" || event.JobType == jobType) &&
+			(queue == "" || event.Queue == queue)
+		return match, false
+	})
+}
+
+/*
+A Server-Sent Events response
+*/
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+/*
+Starts the event stream response, reports false if the connection can't stream
+*/
+func newEventStream(w http.ResponseWriter) (*eventStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	/*keeps nginx from buffering the stream*/
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{w: w, flusher: flusher}, true
+}
+
+func (s *eventStream) send(event jobs.JobEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+/*
+Sends the events accepted by filter till the client disconnects, filter reports the last event,
+or events is closed because the client fell behind or the server shuts down
+*/
+func (s *eventStream) run(r *http.Request, events <-chan jobs.JobEvent, filter func(jobs.JobEvent) (send bool, last bool)) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			s.flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			send, last := filter(event)
+			if !send {
+				continue
+			}
+			if err := s.send(event); err != nil || last {
+				return
+			}
+		}
+	}
+}
@@ -2,9 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/blueberry-adii/tickr/internal/database"
 	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 	"github.com/blueberry-adii/tickr/internal/scheduler"
@@ -21,18 +27,53 @@ type response struct {
 }
 
 /*
-Handler Struct responsible for handling API Requests
+JobTypes reports which job types the workers have a handler for and clients may submit,
+it is satisfied by worker.Registry
+*/
+type JobTypes interface {
+	Has(jobType string) bool
+}
+
+/*
+How long a repeated submission with the same idempotency key
+returns the original job, unless the Handler is configured otherwise
+*/
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+/*
+Longest idempotency or unique key accepted, the size of the MySQL columns
+*/
+const maxKeyLen = 255
+
+/*
+What happens to a submission whose uniqueKey is held by an active job:
+it is rejected with 409, or merged into the active job, which is returned instead
+*/
+const (
+	conflictReject = "reject"
+	conflictMerge  = "merge"
+)
+
+/*
+Handler Struct responsible for handling API Requests.
+IdempotencyWindow is how long an idempotency key maps to the job it created,
+AdminKey is an API key with the admin scope which isn't stored, to create the first keys with
 */
 type Handler struct {
-	scheduler scheduler.Queue
+	scheduler         scheduler.Queue
+	jobTypes          JobTypes
+	IdempotencyWindow time.Duration
+	AdminKey          string
 }
 
 /*
 Returns a new instance of Handler
 */
-func NewHandler(s scheduler.Queue) *Handler {
+func NewHandler(s scheduler.Queue, jobTypes JobTypes) *Handler {
 	return &Handler{
-		scheduler: s,
+		scheduler:         s,
+		jobTypes:          jobTypes,
+		IdempotencyWindow: DefaultIdempotencyWindow,
 	}
 }
 
@@ -55,13 +96,21 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 Takes job from http request,
 calculates time when job needs to be moved from waiting queue to ready queue,
 creates a New Job with the given data from request body, saves job into Database
-pushes the job onto waiting queue if delayed otherwise ready queue
+pushes the job onto waiting queue if delayed otherwise ready queue.
+
+With an Idempotency-Key header (or idempotencyKey field), a repeated submission
+within the idempotency window returns the original job instead of creating a new one.
+With a uniqueKey, only one job per key may be active: a submission while another job
+with the key is pending, retrying or executing is rejected or merged, as onConflict says.
+A job with dependsOn stays blocked till every job it depends on completed
 */
 func (h *Handler) SubmitJob(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		JobType string          `json:"jobtype"`
-		Payload json.RawMessage `json:"payload"`
-		Delay   int             `json:"delay"`
+		jobRequest
+		IdempotencyKey string  `json:"idempotencyKey"`
+		UniqueKey      string  `json:"uniqueKey"`
+		OnConflict     string  `json:"onConflict"`
+		DependsOn      []int64 `json:"dependsOn"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -69,27 +118,125 @@ func (h *Handler) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = body.IdempotencyKey
+	}
+	if len(idempotencyKey) > maxKeyLen {
+		http.Error(w, "Idempotency-Key can't be longer than 255 characters", http.StatusBadRequest)
+		return
+	}
+	/*a client taking the key of a callback would make tickr think the callback is already queued*/
+	if strings.HasPrefix(idempotencyKey, jobs.ReservedKeyPrefix) {
+		http.Error(w, "Idempotency-Key can't start with "+jobs.ReservedKeyPrefix, http.StatusBadRequest)
+		return
+	}
+	if len(body.UniqueKey) > maxKeyLen {
+		http.Error(w, "uniqueKey can't be longer than 255 characters", http.StatusBadRequest)
+		return
+	}
+	if body.OnConflict == "" {
+		body.OnConflict = conflictReject
+	}
+	if body.OnConflict != conflictReject && body.OnConflict != conflictMerge {
+		http.Error(w, "Invalid onConflict: "+body.OnConflict, http.StatusBadRequest)
+		return
+	}
+
 	now := time.Now()
-	scheduledAt := now.Add(time.Duration(body.Delay) * time.Second)
+	job, err := h.newJob(body.jobRequest, requestKey(r), now)
+	if errors.Is(err, errJobTypeForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job.DependsOn, err = parseDependsOn(body.DependsOn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	job := jobs.Job{
-		JobType:     body.JobType,
-		Payload:     body.Payload,
-		Status:      enums.Pending,
-		Attempt:     0,
-		MaxAttempts: 3,
-		CreatedAt:   now,
-		ScheduledAt: scheduledAt,
+	if idempotencyKey != "" {
+		existing, err := h.idempotentJob(r, job.Tenant, idempotencyKey, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeSubmitted(w, existing, "Job Already Submitted")
+			return
+		}
+	}
+
+	if body.UniqueKey != "" {
+		active, err := h.scheduler.GetActiveJobByUniqueKey(r.Context(), job.Tenant, body.UniqueKey)
+		if err != nil && !errors.Is(err, database.ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if active != nil {
+			writeUniqueKeyConflict(w, body.UniqueKey, body.OnConflict, active)
+			return
+		}
+	}
+
+	if idempotencyKey != "" {
+		job.IdempotencyKey = &idempotencyKey
+	}
+	if body.UniqueKey != "" {
+		job.UniqueKey = &body.UniqueKey
 	}
 
-	var err error
 	job.ID, err = h.scheduler.SaveJob(r.Context(), job)
+	/*a concurrent request with the same key saved its job first*/
+	if errors.Is(err, database.ErrIdempotencyKeyUsed) {
+		existing, err := h.scheduler.GetJobByIdempotencyKey(r.Context(), job.Tenant, idempotencyKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeSubmitted(w, existing, "Job Already Submitted")
+		return
+	}
+	/*a job with the same unique key became active since the lookup*/
+	if errors.Is(err, database.ErrUniqueKeyActive) {
+		active, _ := h.scheduler.GetActiveJobByUniqueKey(r.Context(), job.Tenant, body.UniqueKey)
+		writeUniqueKeyConflict(w, body.UniqueKey, body.OnConflict, active)
+		return
+	}
+	if errors.Is(err, database.ErrDependencyNotFound) {
+		http.Error(w, "Unknown job in dependsOn", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrDependencyFailed) {
+		http.Error(w, "A job in dependsOn failed or was cancelled", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	redisJob := &jobs.RedisJob{JobID: job.ID, ScheduledAt: scheduledAt}
+	/*the job is saved blocked unless its dependencies already completed, it is queued once they do*/
+	if len(job.DependsOn) > 0 {
+		saved, err := h.scheduler.GetJob(r.Context(), job.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		job.Status = saved.Status
+		if job.Status == enums.Blocked {
+			writeSubmitted(w, &job, "Job Submitted!!!")
+			return
+		}
+	}
+
+	redisJob := job.RedisJob(job.ScheduledAt)
 
 	if body.Delay > 0 {
 		h.scheduler.PushWaitingQueue(r.Context(), redisJob)
@@ -97,11 +244,175 @@ func (h *Handler) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		h.scheduler.PushReadyQueue(r.Context(), redisJob)
 	}
 
+	writeSubmitted(w, &job, "Job Submitted!!!")
+}
+
+/*
+Fields of a submitted job shared by single and batch submissions
+*/
+type jobRequest struct {
+	JobType        string            `json:"jobtype"`
+	Payload        json.RawMessage   `json:"payload"`
+	Delay          int               `json:"delay"`
+	Timeout        int               `json:"timeout"`
+	MaxAttempts    int               `json:"maxAttempts"`
+	RetryPolicy    *jobs.RetryPolicy `json:"retryPolicy"`
+	Queue          string            `json:"queue"`
+	Priority       enums.Priority    `json:"priority"`
+	CallbackURL    *string           `json:"callbackUrl"`
+	CallbackSecret *string           `json:"callbackSecret"`
+}
+
+/*
+Validates a submitted job and returns it as a pending job of the key's tenant created at now,
+filling in the defaults and recording the API key submitting it. The error is meant for the client
+*/
+func (h *Handler) newJob(req jobRequest, key *jobs.APIKey, now time.Time) (jobs.Job, error) {
+	if !h.jobTypes.Has(req.JobType) {
+		return jobs.Job{}, errors.New("Unknown jobtype: " + req.JobType)
+	}
+	if !allowsJobType(key, req.JobType) {
+		return jobs.Job{}, fmt.Errorf("%w: %s", errJobTypeForbidden, req.JobType)
+	}
+	if req.Timeout < 0 {
+		return jobs.Job{}, errors.New("timeout can't be negative")
+	}
+	if req.MaxAttempts < 0 {
+		return jobs.Job{}, errors.New("maxAttempts can't be negative")
+	}
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = jobs.DefaultMaxAttempts
+	}
+	if req.RetryPolicy != nil {
+		if err := req.RetryPolicy.Validate(); err != nil {
+			return jobs.Job{}, errors.New("Invalid retryPolicy: " + err.Error())
+		}
+	}
+	if req.Queue == "" {
+		req.Queue = jobs.DefaultQueue
+	}
+	if !h.scheduler.HasQueue(req.Queue) {
+		return jobs.Job{}, errors.New("Unknown queue: " + req.Queue)
+	}
+	if req.Priority == "" {
+		req.Priority = enums.Normal
+	}
+	if !req.Priority.IsValid() {
+		return jobs.Job{}, errors.New("Invalid priority: " + string(req.Priority))
+	}
+	if req.CallbackURL != nil {
+		if !h.jobTypes.Has("http") {
+			return jobs.Job{}, errors.New("callbacks need the http job type")
+		}
+		if !isWebhookURL(*req.CallbackURL) {
+			return jobs.Job{}, errors.New("Invalid callbackUrl: " + *req.CallbackURL)
+		}
+		/*the callback is a POST to a URL of the client's choosing, just like an http job*/
+		if !allowsJobType(key, "http") {
+			return jobs.Job{}, fmt.Errorf("%w: %s", errJobTypeForbidden, "http")
+		}
+	}
+	if req.CallbackSecret != nil {
+		if req.CallbackURL == nil {
+			return jobs.Job{}, errors.New("callbackSecret needs a callbackUrl")
+		}
+		if len(*req.CallbackSecret) > maxKeyLen {
+			return jobs.Job{}, errors.New("callbackSecret is too long")
+		}
+	}
+
+	var apiKeyID *int64
+	if key != nil && key.ID != 0 {
+		apiKeyID = &key.ID
+	}
+
+	return jobs.Job{
+		Tenant:         keyTenant(key),
+		JobType:        req.JobType,
+		Payload:        req.Payload,
+		Status:         enums.Pending,
+		Queue:          req.Queue,
+		Priority:       req.Priority,
+		Attempt:        0,
+		MaxAttempts:    req.MaxAttempts,
+		Timeout:        req.Timeout,
+		RetryPolicy:    req.RetryPolicy,
+		CreatedAt:      now,
+		ScheduledAt:    now.Add(time.Duration(req.Delay) * time.Second),
+		CallbackURL:    req.CallbackURL,
+		CallbackSecret: req.CallbackSecret,
+		APIKeyID:       apiKeyID,
+	}, nil
+}
+
+/*
+Reports whether raw is an absolute http(s) URL a webhook can be POSTed to
+*/
+func isWebhookURL(raw string) bool {
+	u, err := url.ParseRequestURI(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+/*
+Validates the IDs of the jobs a new job depends on, dropping duplicates
+*/
+func parseDependsOn(ids []int64) ([]int64, error) {
+	seen := make(map[int64]bool, len(ids))
+	var dependsOn []int64
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, errors.New("Invalid job ID in dependsOn: " + strconv.FormatInt(id, 10))
+		}
+		if !seen[id] {
+			seen[id] = true
+			dependsOn = append(dependsOn, id)
+		}
+	}
+	return dependsOn, nil
+}
+
+/*
+Returns the job the tenant created earlier with the idempotency key,
+nil if there is none or it was created before the idempotency window.
+A key whose window is over is released so the new job can take it
+*/
+func (h *Handler) idempotentJob(r *http.Request, tenant string, key string, now time.Time) (*jobs.Job, error) {
+	job, err := h.scheduler.GetJobByIdempotencyKey(r.Context(), tenant, key)
+	if errors.Is(err, database.ErrJobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now.Sub(job.CreatedAt) < h.IdempotencyWindow {
+		return job, nil
+	}
+	return nil, h.scheduler.ReleaseIdempotencyKey(r.Context(), job.ID)
+}
+
+/*
+Responds to a submission whose unique key is held by the active job,
+returning the active job when merging, otherwise rejecting with 409.
+active is nil if the job finished in the meantime, the submission is rejected then
+*/
+func writeUniqueKeyConflict(w http.ResponseWriter, key string, onConflict string, active *jobs.Job) {
+	if onConflict == conflictMerge && active != nil {
+		writeSubmitted(w, active, "Job Merged Into Active Job")
+		return
+	}
+	http.Error(w, "Job with uniqueKey "+key+" is already active", http.StatusConflict)
+}
+
+/*
+Responds with the submitted job's ID, status and scheduled time
+*/
+func writeSubmitted(w http.ResponseWriter, job *jobs.Job, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response{
 		Status:  http.StatusOK,
-		Message: "Job Submitted!!!",
+		Message: message,
 		Data: map[string]any{
 			"jobID":       job.ID,
 			"status":      job.Status,
@@ -110,3 +421,246 @@ func (h *Handler) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		Success: true,
 	})
 }
+
+/*
+Returns the job with the ID if it belongs to the tenant of the request.
+Jobs of other tenants are reported as database.ErrJobNotFound, so they can't be told apart from missing ones
+*/
+func (h *Handler) tenantJob(r *http.Request, jobID int64) (*jobs.Job, error) {
+	job, err := h.scheduler.GetJob(r.Context(), jobID)
+	if err != nil {
+		return nil, err
+	}
+	if jobs.TenantName(job.Tenant) != requestTenant(r) {
+		return nil, database.ErrJobNotFound
+	}
+	return job, nil
+}
+
+/*
+Returns the job with the ID given in the URL path,
+responds with 404 if no such job exists
+*/
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.tenantJob(r, jobID)
+	if errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Job Found",
+		Data:    job,
+		Success: true,
+	})
+}
+
+/*
+Cancels the job with the ID given in the URL path,
+an executing job has its running handler stopped.
+Responds with 409 if the job already finished
+*/
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.tenantJob(r, jobID)
+	if err == nil {
+		err = h.scheduler.CancelJob(r.Context(), jobID)
+	}
+	if errors.Is(err, database.ErrJobNotFound) {
+		http.Error(w, "Job Not Found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrJobNotCancellable) {
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Job Cancelled",
+		Data: map[string]any{
+			"jobID":  jobID,
+			"status": enums.Cancelled,
+		},
+		Success: true,
+	})
+}
+
+/*
+Default and maximum number of jobs returned by a single ListJobs page
+*/
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+/*
+Lists jobs filtered by the query parameters
+status, jobtype, queue, worker_id, batch_id, created_after, created_before,
+scheduled_after, scheduled_before, finished_after and finished_before, ordered by ID.
+Pagination is cursor based: pass the returned nextCursor as cursor to fetch the next page
+*/
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, nextCursor, err := h.listPage(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Jobs Found",
+		Data: map[string]any{
+			"jobs":       list,
+			"nextCursor": nextCursor,
+		},
+		Success: true,
+	})
+}
+
+/*
+Fetches one page of jobs matching the filter,
+along with the cursor of the next page, nil on the last page
+*/
+func (h *Handler) listPage(r *http.Request, filter database.JobFilter) ([]jobs.Job, *int64, error) {
+	limit := filter.Limit
+	/*fetch one extra row to find out whether another page exists*/
+	filter.Limit++
+
+	list, err := h.scheduler.ListJobs(r.Context(), filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nextCursor *int64
+	if len(list) > limit {
+		list = list[:limit]
+		nextCursor = &list[limit-1].ID
+	}
+	if list == nil {
+		list = []jobs.Job{}
+	}
+
+	return list, nextCursor, nil
+}
+
+/*
+Builds a JobFilter from the query parameters of the request, limited to the request's tenant
+*/
+func parseJobFilter(r *http.Request) (database.JobFilter, error) {
+	q := r.URL.Query()
+	filter := database.JobFilter{
+		Tenant:    requestTenant(r),
+		JobType:   q.Get("jobtype"),
+		Queue:     q.Get("queue"),
+		RequestID: q.Get("request_id"),
+		Limit:     defaultPageSize,
+	}
+
+	if v := q.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			status := enums.Status(strings.TrimSpace(status))
+			if !status.IsValid() {
+				return filter, errors.New("Invalid status: " + string(status))
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	if v := q.Get("worker_id"); v != "" {
+		workerID, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.New("Invalid worker_id")
+		}
+		filter.WorkerID = &workerID
+	}
+
+	if v := q.Get("batch_id"); v != "" {
+		batchID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, errors.New("Invalid batch_id")
+		}
+		filter.BatchID = &batchID
+	}
+
+	if v := q.Get("api_key_id"); v != "" {
+		apiKeyID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, errors.New("Invalid api_key_id")
+		}
+		filter.APIKeyID = &apiKeyID
+	}
+
+	times := []struct {
+		param string
+		dest  **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"scheduled_after", &filter.ScheduledAfter},
+		{"scheduled_before", &filter.ScheduledBefore},
+		{"finished_after", &filter.FinishedAfter},
+		{"finished_before", &filter.FinishedBefore},
+	}
+	for _, t := range times {
+		v := q.Get(t.param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errors.New("Invalid " + t.param + ", expected RFC3339 time")
+		}
+		*t.dest = &parsed
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor < 0 {
+			return filter, errors.New("Invalid cursor")
+		}
+		filter.AfterID = cursor
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = min(limit, maxPageSize)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/blueberry-adii/tickr/internal/metrics"
+)
+
+/*
+Serves the instance's metrics in the Prometheus text format.
+Queue depths and quotas are labelled with every tenant,
+so only admin keys of the default tenant may scrape them
+*/
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(requestKey(r)) {
+		http.Error(w, "API key can't read metrics", http.StatusForbidden)
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
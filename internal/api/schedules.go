@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/scheduler"
+)
+
+/*
+Takes a recurring schedule from http request,
+validates the cron expression and timezone, calculates the first occurrence
+and saves the schedule. Every occurrence creates a new job
+*/
+func (h *Handler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Cron     string          `json:"cron"`
+		Timezone string          `json:"timezone"`
+		JobType  string          `json:"jobtype"`
+		Payload  json.RawMessage `json:"payload"`
+		Queue    string          `json:"queue"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid Body Format", http.StatusBadRequest)
+		return
+	}
+
+	if !h.jobTypes.Has(body.JobType) {
+		http.Error(w, "Unknown jobtype: "+body.JobType, http.StatusBadRequest)
+		return
+	}
+	if !allowsJobType(requestKey(r), body.JobType) {
+		http.Error(w, errJobTypeForbidden.Error()+": "+body.JobType, http.StatusForbidden)
+		return
+	}
+	if body.Queue == "" {
+		body.Queue = jobs.DefaultQueue
+	}
+	if !h.scheduler.HasQueue(body.Queue) {
+		http.Error(w, "Unknown queue: "+body.Queue, http.StatusBadRequest)
+		return
+	}
+	if body.Timezone == "" {
+		body.Timezone = "UTC"
+	}
+
+	now := time.Now()
+	nextRunAt, err := scheduler.NextRun(body.Cron, body.Timezone, now)
+	if err != nil {
+		http.Error(w, "Invalid Schedule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule := jobs.Schedule{
+		Tenant:    requestTenant(r),
+		CronExpr:  body.Cron,
+		Timezone:  body.Timezone,
+		JobType:   body.JobType,
+		Payload:   body.Payload,
+		Queue:     body.Queue,
+		NextRunAt: nextRunAt,
+		CreatedAt: now,
+	}
+
+	schedule.ID, err = h.scheduler.SaveSchedule(r.Context(), schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Schedule Created!!!",
+		Data:    schedule,
+		Success: true,
+	})
+}
+
+/*
+Lists all recurring schedules of the request's tenant
+*/
+func (h *Handler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.scheduler.ListSchedules(r.Context(), requestTenant(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if schedules == nil {
+		schedules = []jobs.Schedule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Schedules Found",
+		Data:    schedules,
+		Success: true,
+	})
+}
+
+/*
+Returns the schedule with the ID given in the URL path
+*/
+func (h *Handler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.tenantSchedule(r, scheduleID)
+	if errors.Is(err, database.ErrScheduleNotFound) {
+		http.Error(w, "Schedule Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Schedule Found",
+		Data:    schedule,
+		Success: true,
+	})
+}
+
+/*
+Deletes the schedule with the ID given in the URL path,
+jobs it already created are not affected
+*/
+func (h *Handler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.tenantSchedule(r, scheduleID)
+	if err == nil {
+		err = h.scheduler.DeleteSchedule(r.Context(), scheduleID)
+	}
+	if errors.Is(err, database.ErrScheduleNotFound) {
+		http.Error(w, "Schedule Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Schedule Deleted",
+		Data: map[string]any{
+			"scheduleID": scheduleID,
+		},
+		Success: true,
+	})
+}
+
+/*
+Returns the schedule with the ID if it belongs to the tenant of the request,
+database.ErrScheduleNotFound otherwise
+*/
+func (h *Handler) tenantSchedule(r *http.Request, scheduleID int64) (*jobs.Schedule, error) {
+	schedule, err := h.scheduler.GetSchedule(r.Context(), scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if jobs.TenantName(schedule.Tenant) != requestTenant(r) {
+		return nil, database.ErrScheduleNotFound
+	}
+	return schedule, nil
+}
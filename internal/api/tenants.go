@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Lists the quotas of every tenant which was given some.
+Only admin keys of the default tenant may see them
+*/
+func (h *Handler) ListTenants(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(requestKey(r)) {
+		http.Error(w, "API key can't manage tenants", http.StatusForbidden)
+		return
+	}
+
+	tenants, err := h.scheduler.ListTenants(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tenants == nil {
+		tenants = []jobs.Tenant{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Tenants Found",
+		Data:    tenants,
+		Success: true,
+	})
+}
+
+/*
+Sets the quotas of the tenant given in the URL path: the most jobs it may run at once
+and the most jobs per second its workers may start, 0 meaning unlimited.
+Only admin keys of the default tenant may set them
+*/
+func (h *Handler) SaveTenant(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(requestKey(r)) {
+		http.Error(w, "API key can't manage tenants", http.StatusForbidden)
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := jobs.ValidateTenant(name); err != nil {
+		http.Error(w, "Invalid tenant: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		MaxRunning int `json:"maxRunning"`
+		RateLimit  int `json:"rateLimit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid Body Format", http.StatusBadRequest)
+		return
+	}
+	if body.MaxRunning < 0 || body.RateLimit < 0 {
+		http.Error(w, "maxRunning and rateLimit can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	tenant := jobs.Tenant{
+		Name:       name,
+		MaxRunning: body.MaxRunning,
+		RateLimit:  body.RateLimit,
+		UpdatedAt:  time.Now(),
+	}
+	if err := h.scheduler.SaveTenant(r.Context(), tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		Status:  http.StatusOK,
+		Message: "Tenant Saved!!!",
+		Data:    tenant,
+		Success: true,
+	})
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned by the repository when no API key matches, or the key to revoke is already revoked
+or belongs to another tenant
+*/
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+const apiKeyColumns = "id, tenant, name, prefix, key_hash, scopes, job_types, created_at, revoked_at"
+
+func scanAPIKey(row rowScanner) (*jobs.APIKey, error) {
+	var key jobs.APIKey
+	var scopes, jobTypes []byte
+	err := row.Scan(
+		&key.ID,
+		&key.Tenant,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&jobTypes,
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return nil, err
+	}
+	if jobTypes != nil {
+		if err := json.Unmarshal(jobTypes, &key.JobTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+/*
+Saves the API key in database and
+returns its ID
+*/
+func (r MySQLRepository) SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error) {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return 0, err
+	}
+	var jobTypes []byte
+	if len(key.JobTypes) > 0 {
+		if jobTypes, err = json.Marshal(key.JobTypes); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO api_keys (tenant, name, prefix, key_hash, scopes, job_types, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		jobs.TenantName(key.Tenant),
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		scopes,
+		jobTypes,
+		key.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+/*
+Gets the API key with the SHA-256 hash, revoked keys included
+*/
+func (r MySQLRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?",
+		hash,
+	)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+/*
+Lists the API keys of the tenant ordered by ID, revoked keys included.
+Keys of every tenant are listed if tenant is empty
+*/
+func (r MySQLRepository) ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys"
+	var args []any
+	if tenant != "" {
+		query += " WHERE tenant = ?"
+		args = append(args, tenant)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []jobs.APIKey
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *key)
+	}
+
+	return res, rows.Err()
+}
+
+/*
+Revokes the API key of the tenant, or of any tenant if tenant is empty.
+The key is kept so the jobs it submitted still point to it
+*/
+func (r MySQLRepository) RevokeAPIKey(ctx context.Context, keyID int64, tenant string, now time.Time) error {
+	query := "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	args := []any{now, keyID}
+	if tenant != "" {
+		query += " AND tenant = ?"
+		args = append(args, tenant)
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Records an attempt in the job's attempt history,
+the history is kept across replays
+*/
+func (r MySQLRepository) SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO job_attempts
+			(job_id, attempt, worker_id, status, started_at, finished_at, duration_ms, error, result_snippet, next_retry_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		attempt.JobID,
+		attempt.Attempt,
+		attempt.WorkerID,
+		attempt.Status,
+		attempt.StartedAt,
+		attempt.FinishedAt,
+		attempt.DurationMs,
+		attempt.Error,
+		attempt.ResultSnippet,
+		attempt.NextRetryAt,
+	)
+
+	return err
+}
+
+/*
+Gets every recorded attempt of the job, oldest first
+*/
+func (r MySQLRepository) GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT job_id, attempt, worker_id, status, started_at, finished_at, duration_ms, error, result_snippet, next_retry_at
+		FROM job_attempts WHERE job_id = ? ORDER BY id`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []jobs.JobAttempt{}
+	for rows.Next() {
+		var attempt jobs.JobAttempt
+		if err := rows.Scan(
+			&attempt.JobID,
+			&attempt.Attempt,
+			&attempt.WorkerID,
+			&attempt.Status,
+			&attempt.StartedAt,
+			&attempt.FinishedAt,
+			&attempt.DurationMs,
+			&attempt.Error,
+			&attempt.ResultSnippet,
+			&attempt.NextRetryAt,
+		); err != nil {
+			return nil, err
+		}
+		res = append(res, attempt)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned when no batch exists with the given ID
+*/
+var ErrBatchNotFound = errors.New("batch not found")
+
+/*
+Returned when completing a batch which still has active jobs or was completed already
+*/
+var ErrBatchNotComplete = errors.New("batch not complete")
+
+/*
+Most rows inserted by a single statement, keeps the placeholders
+well below MySQL's limit of 65535 per statement
+*/
+const insertChunk = 1000
+
+/*
+queryer is satisfied by both *sql.DB and *sql.Tx
+*/
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+/*
+Saves the jobs as a new batch in a single transaction, using multi-row inserts.
+Returns the batch ID and the job IDs, in the order of the given jobs
+*/
+func (r MySQLRepository) SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error) {
+	var onComplete []byte
+	if batch.OnComplete != nil {
+		var err error
+		if onComplete, err = json.Marshal(batch.OnComplete); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO batches (tenant, total, on_complete, created_at) VALUES (?, ?, ?, ?)",
+		jobs.TenantName(batch.Tenant),
+		len(batchJobs),
+		onComplete,
+		batch.CreatedAt,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	batchID, err := res.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for start := 0; start < len(batchJobs); start += insertChunk {
+		chunk := batchJobs[start:min(start+insertChunk, len(batchJobs))]
+
+		rows := make([]string, len(chunk))
+		var args []any
+		for i, job := range chunk {
+			job.BatchID = &batchID
+			jobArgs, err := insertArgs(job)
+			if err != nil {
+				return 0, nil, err
+			}
+			rows[i] = insertRow
+			args = append(args, jobArgs...)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO jobs ("+insertColumns+") VALUES "+strings.Join(rows, ", "), args...); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	/*rows are inserted in order, so their IDs increase in the order of the jobs*/
+	idRows, err := tx.QueryContext(ctx, "SELECT id FROM jobs WHERE batch_id = ? ORDER BY id", batchID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer idRows.Close()
+
+	ids := make([]int64, 0, len(batchJobs))
+	for idRows.Next() {
+		var id int64
+		if err := idRows.Scan(&id); err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := idRows.Err(); err != nil {
+		return 0, nil, err
+	}
+	idRows.Close()
+
+	return batchID, ids, tx.Commit()
+}
+
+/*
+Gets the batch with the number of its jobs in each status
+*/
+func (r MySQLRepository) GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error) {
+	return getBatch(ctx, r.db, batchID)
+}
+
+func getBatch(ctx context.Context, db queryer, batchID int64) (*jobs.Batch, error) {
+	batch := jobs.Batch{ID: batchID, Counts: make(map[enums.Status]int)}
+	var onComplete []byte
+
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT tenant, total, on_complete, on_complete_job_id, created_at, completed_at FROM batches WHERE id = ?",
+		batchID,
+	).Scan(&batch.Tenant, &batch.Total, &onComplete, &batch.OnCompleteJobID, &batch.CreatedAt, &batch.CompletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(onComplete) > 0 {
+		batch.OnComplete = new(jobs.OnComplete)
+		if err := json.Unmarshal(onComplete, batch.OnComplete); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT status, COUNT(*) FROM jobs WHERE batch_id = ? GROUP BY status",
+		batchID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status enums.Status
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		batch.Counts[status] = count
+	}
+
+	return &batch, rows.Err()
+}
+
+/*
+Condition matching batches which still have a job that isn't completed, failed or cancelled
+*/
+const activeBatchJobs = "SELECT 1 FROM jobs WHERE jobs.batch_id = batches.id AND jobs.status NOT IN (?, ?, ?)"
+
+/*
+Marks the batch completed if every one of its jobs reached a final status,
+and saves the job created by its OnComplete, in a single transaction.
+The check is part of the UPDATE so when the last jobs finish at the same time,
+only one caller completes the batch. Returns the created job, nil if the batch has no OnComplete
+and ErrBatchNotComplete if the batch isn't complete or was completed already
+*/
+func (r MySQLRepository) CompleteBatch(ctx context.Context, batchID int64, now time.Time) (*jobs.Job, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE batches SET completed_at = ? WHERE id = ? AND completed_at IS NULL AND NOT EXISTS ("+activeBatchJobs+")",
+		now,
+		batchID,
+		enums.Completed,
+		enums.Failed,
+		enums.Cancelled,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrBatchNotComplete
+	}
+
+	batch, err := getBatch(ctx, tx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if batch.OnComplete == nil {
+		return nil, tx.Commit()
+	}
+
+	job, err := batch.OnComplete.Job(*batch, now)
+	if err != nil {
+		return nil, err
+	}
+	job.ID, err = insertJob(ctx, tx, job)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE batches SET on_complete_job_id = ? WHERE id = ?", job.ID, batchID); err != nil {
+		return nil, err
+	}
+
+	return &job, tx.Commit()
+}
+
+/*
+Gets the IDs of the batches whose jobs all reached a final status but which weren't
+marked completed, left behind when the process stopped before completing them
+*/
+func (r MySQLRepository) GetFinishedBatches(ctx context.Context) ([]int64, error) {
+	return r.queryIDs(
+		ctx,
+		"SELECT id FROM batches WHERE completed_at IS NULL AND NOT EXISTS ("+activeBatchJobs+")",
+		enums.Completed,
+		enums.Failed,
+		enums.Cancelled,
+	)
+}
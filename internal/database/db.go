@@ -3,7 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -41,6 +41,6 @@ func ConnectDB(cfg Config) (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Printf("connected to MySQL database successfully")
+	slog.Info("connected to MySQL database successfully")
 	return db, nil
 }
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Records the error a failed attempt ended with,
+the history is kept across replays
+*/
+func (r MySQLRepository) SaveJobError(ctx context.Context, jobError jobs.JobError) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO job_errors (job_id, attempt, error, created_at) VALUES (?, ?, ?, ?)",
+		jobError.JobID,
+		jobError.Attempt,
+		jobError.Error,
+		jobError.CreatedAt,
+	)
+
+	return err
+}
+
+/*
+Gets the error history of the given jobs,
+keyed by job ID and ordered from oldest to newest
+*/
+func (r MySQLRepository) GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error) {
+	res := make(map[int64][]jobs.JobError)
+	if len(jobIDs) == 0 {
+		return res, nil
+	}
+
+	placeholders := make([]string, len(jobIDs))
+	args := make([]any, len(jobIDs))
+	for i, id := range jobIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT job_id, attempt, error, created_at FROM job_errors WHERE job_id IN ("+strings.Join(placeholders, ", ")+") ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobError jobs.JobError
+		if err := rows.Scan(&jobError.JobID, &jobError.Attempt, &jobError.Error, &jobError.CreatedAt); err != nil {
+			return nil, err
+		}
+		res[jobError.JobID] = append(res[jobError.JobID], jobError)
+	}
+
+	return res, rows.Err()
+}
+
+/*
+Resets a permanently failed job to pending with no attempts made,
+scheduled to run right away. The status check is part of the UPDATE
+so a job can't be replayed twice at the same time.
+Returns ErrUniqueKeyActive if another job holding its unique key is active
+*/
+func (r MySQLRepository) ReplayJob(ctx context.Context, jobID int64, now time.Time) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE jobs SET
+			status = ?,
+			attempt = 0,
+			scheduled_at = ?,
+			started_at = NULL,
+			finished_at = NULL,
+			last_error = NULL,
+			result = NULL,
+			worker_id = NULL
+		WHERE id = ? AND status = ?`,
+		enums.Pending,
+		now,
+		jobID,
+		enums.Failed,
+	)
+	/*another job with the same unique key became active since the job failed*/
+	if isDuplicateKeyOn(err, "uq_active_unique_key") {
+		return ErrUniqueKeyActive
+	}
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	if _, err := r.GetJob(ctx, jobID); err != nil {
+		return err
+	}
+	return ErrJobNotReplayable
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned when saving a job which depends on a job that doesn't exist
+*/
+var ErrDependencyNotFound = errors.New("job in dependsOn not found")
+
+/*
+Returned when saving a job which depends on a job that already failed or was cancelled
+*/
+var ErrDependencyFailed = errors.New("job in dependsOn failed or was cancelled")
+
+/*
+Saves a job along with the jobs it depends on, in a single transaction.
+The parents are locked while the job is saved, so a parent finishing at the same time
+either sees the new job when it releases its dependents, or the job sees the parent completed.
+Jobs of another tenant count as not found
+*/
+func (r MySQLRepository) saveDependentJob(ctx context.Context, job jobs.Job) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	placeholders := make([]string, len(job.DependsOn))
+	args := []any{jobs.TenantName(job.Tenant)}
+	for i, id := range job.DependsOn {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, status FROM jobs WHERE tenant = ? AND id IN ("+strings.Join(placeholders, ", ")+") FOR UPDATE",
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	completed := true
+	for rows.Next() {
+		var id int64
+		var status enums.Status
+		if err := rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		found++
+
+		switch status {
+		case enums.Failed, enums.Cancelled:
+			rows.Close()
+			return 0, ErrDependencyFailed
+		case enums.Completed:
+		default:
+			completed = false
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if found != len(job.DependsOn) {
+		return 0, ErrDependencyNotFound
+	}
+
+	if !completed {
+		job.Status = enums.Blocked
+	}
+	jobID, err := insertJob(ctx, tx, job)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, parentID := range job.DependsOn {
+		if _, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO job_dependencies (job_id, depends_on) VALUES (?, ?)",
+			jobID,
+			parentID,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return jobID, tx.Commit()
+}
+
+/*
+Moves the blocked dependents of a completed job, whose other parents completed as well, to pending
+and returns them. The status check is part of the UPDATE so parents completing
+at the same time release a dependent only once
+*/
+func (r MySQLRepository) ReleaseDependents(ctx context.Context, jobID int64) ([]jobs.Job, error) {
+	ids, err := r.queryIDs(
+		ctx,
+		`SELECT d.job_id FROM job_dependencies d
+		JOIN jobs j ON j.id = d.job_id
+		WHERE d.depends_on = ? AND j.status = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM job_dependencies o
+			JOIN jobs p ON p.id = o.depends_on
+			WHERE o.job_id = d.job_id AND p.status <> ?
+		)`,
+		jobID,
+		enums.Blocked,
+		enums.Completed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var released []jobs.Job
+	for _, id := range ids {
+		res, err := r.db.ExecContext(
+			ctx,
+			"UPDATE jobs SET status = ? WHERE id = ? AND status = ?",
+			enums.Pending,
+			id,
+			enums.Blocked,
+		)
+		if err != nil {
+			return released, err
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+
+		job, err := r.GetJob(ctx, id)
+		if err != nil {
+			return released, err
+		}
+		released = append(released, *job)
+	}
+
+	return released, nil
+}
+
+/*
+Cancels the blocked dependents of a job which failed or was cancelled,
+with reason as their last error, and returns their IDs
+*/
+func (r MySQLRepository) CancelDependents(ctx context.Context, jobID int64, reason string, now time.Time) ([]int64, error) {
+	ids, err := r.queryIDs(
+		ctx,
+		`SELECT d.job_id FROM job_dependencies d
+		JOIN jobs j ON j.id = d.job_id
+		WHERE d.depends_on = ? AND j.status = ?`,
+		jobID,
+		enums.Blocked,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var cancelled []int64
+	for _, id := range ids {
+		res, err := r.db.ExecContext(
+			ctx,
+			"UPDATE jobs SET status = ?, last_error = ?, finished_at = ? WHERE id = ? AND status = ?",
+			enums.Cancelled,
+			reason,
+			now,
+			id,
+			enums.Blocked,
+		)
+		if err != nil {
+			return cancelled, err
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+		cancelled = append(cancelled, id)
+	}
+
+	return cancelled, nil
+}
+
+/*
+Gets the completed, failed or cancelled jobs which still have blocked dependents,
+left behind when the process stopped before it resolved the dependents
+*/
+func (r MySQLRepository) GetFinishedParents(ctx context.Context) ([]jobs.Job, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT `+jobColumns+` FROM jobs
+		WHERE status IN (?, ?, ?) AND id IN (
+			SELECT d.depends_on FROM job_dependencies d
+			JOIN jobs j ON j.id = d.job_id
+			WHERE j.status = ?
+		)`,
+		enums.Completed,
+		enums.Failed,
+		enums.Cancelled,
+		enums.Blocked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parents []jobs.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, *job)
+	}
+
+	return parents, rows.Err()
+}
+
+func (r MySQLRepository) queryIDs(ctx context.Context, query string, args ...any) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
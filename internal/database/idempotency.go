@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Gets the job the tenant submitted with the idempotency key
+*/
+func (r MySQLRepository) GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE tenant = ? AND idempotency_key = ?",
+		jobs.TenantName(tenant),
+		key,
+	)
+
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+
+	return job, err
+}
+
+/*
+Clears the idempotency key of a job whose window is over,
+so the key can be used for a new job
+*/
+func (r MySQLRepository) ReleaseIdempotencyKey(ctx context.Context, jobID int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE jobs SET idempotency_key = NULL WHERE id = ?", jobID)
+	return err
+}
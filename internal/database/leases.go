@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned when claiming a job which is no longer pending or retrying,
+because it was cancelled, is already being executed or has finished
+*/
+var ErrJobNotClaimable = errors.New("job is not pending or retrying")
+
+/*
+Returned when renewing the lease of a job the worker no longer holds, or saving the outcome of its execution
+*/
+var ErrLeaseLost = errors.New("job lease lost")
+
+/*
+Moves a pending or retrying job to executing, held by the worker under leaseToken until leasedUntil,
+and returns the claimed job. The status check is part of the UPDATE so only one
+worker can claim a job, even when it was delivered more than once
+*/
+func (r MySQLRepository) ClaimJob(ctx context.Context, jobID int64, workerID int, leaseToken string, now time.Time, leasedUntil time.Time) (*jobs.Job, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE jobs SET
+			status = ?,
+			worker_id = ?,
+			leased_until = ?,
+			lease_token = ?,
+			started_at = ?,
+			finished_at = NULL
+		WHERE id = ? AND status IN (?, ?)`,
+		enums.Executing,
+		workerID,
+		leasedUntil,
+		leaseToken,
+		now,
+		jobID,
+		enums.Pending,
+		enums.Retrying,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrJobNotClaimable
+	}
+
+	job, err := r.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	job.LeaseToken = leaseToken
+	return job, nil
+}
+
+/*
+Renews the lease of an executing job still held under the claim's leaseToken
+*/
+func (r MySQLRepository) ExtendLease(ctx context.Context, jobID int64, leaseToken string, leasedUntil time.Time) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE jobs SET leased_until = ? WHERE id = ? AND status = ? AND lease_token = ?",
+		leasedUntil,
+		jobID,
+		enums.Executing,
+		leaseToken,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	/*same value written twice within a second reports no affected rows, so check the job*/
+	if affected == 0 {
+		var held bool
+		err := r.db.QueryRowContext(
+			ctx,
+			"SELECT EXISTS(SELECT 1 FROM jobs WHERE id = ? AND status = ? AND lease_token = ?)",
+			jobID,
+			enums.Executing,
+			leaseToken,
+		).Scan(&held)
+		if err != nil {
+			return err
+		}
+		if !held {
+			return ErrLeaseLost
+		}
+	}
+
+	return nil
+}
+
+/*
+Gets the executing jobs whose lease expired before now
+*/
+func (r MySQLRepository) GetExpiredLeases(ctx context.Context, now time.Time) ([]jobs.Job, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE status = ? AND leased_until < ? ORDER BY id",
+		enums.Executing,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []jobs.Job
+
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *job)
+	}
+
+	return res, rows.Err()
+}
+
+/*
+Saves the new state of a job taken back from a crashed worker.
+Only applies while the job still holds the expired lease it was read with,
+so concurrent reapers or a late heartbeat can't both win.
+Reports whether the job was released
+*/
+func (r MySQLRepository) ReleaseExpiredLease(ctx context.Context, job *jobs.Job, leasedUntil time.Time) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE jobs SET
+			status = ?,
+			attempt = ?,
+			worker_id = NULL,
+			leased_until = NULL,
+			lease_token = NULL,
+			finished_at = ?,
+			last_error = ?
+		WHERE id = ? AND status = ? AND leased_until = ?`,
+		job.Status,
+		job.Attempt,
+		job.FinishedAt,
+		job.LastError,
+		job.ID,
+		enums.Executing,
+		leasedUntil,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
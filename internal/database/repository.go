@@ -3,19 +3,115 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/go-sql-driver/mysql"
 )
 
+/*
+Returned by the repository when the requested job does not exist,
+so callers can tell a missing job apart from a database failure
+*/
+var ErrJobNotFound = errors.New("job not found")
+
+/*
+Returned when cancelling a job which already finished
+*/
+var ErrJobNotCancellable = errors.New("job already finished")
+
+/*
+Returned when replaying a job which hasn't permanently failed
+*/
+var ErrJobNotReplayable = errors.New("job has not failed")
+
+/*
+Returned when saving a job whose idempotency key is held by another job
+*/
+var ErrIdempotencyKeyUsed = errors.New("idempotency key already used")
+
+/*
+Returned when saving or replaying a job whose unique key is held by another active job
+*/
+var ErrUniqueKeyActive = errors.New("unique key held by an active job")
+
+/*
+Filters applied when listing jobs, zero values are ignored.
+AfterID is the pagination cursor: only jobs with a greater ID are returned
+*/
+type JobFilter struct {
+	Tenant          string
+	Statuses        []enums.Status
+	JobType         string
+	Queue           string
+	WorkerID        *int
+	BatchID         *int64
+	RequestID       string
+	APIKeyID        *int64
+	CreatedAfter    *time.Time
+	CreatedBefore   *time.Time
+	ScheduledAfter  *time.Time
+	ScheduledBefore *time.Time
+	FinishedAfter   *time.Time
+	FinishedBefore  *time.Time
+	AfterID         int64
+	Limit           int
+}
+
 type Repository interface {
 	SaveJob(ctx context.Context, job jobs.Job) (int64, error)
 	GetJob(ctx context.Context, jobID int64) (*jobs.Job, error)
+	ListJobs(ctx context.Context, filter JobFilter) ([]jobs.Job, error)
 	UpdateJob(ctx context.Context, job *jobs.Job) error
-	GetPendingJobs(ctx context.Context) ([]jobs.RedisJob, error)
+	CancelJob(ctx context.Context, jobID int64) error
+	GetPendingJobs(ctx context.Context, queue string) ([]jobs.RedisJob, error)
+
+	GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error)
+	ReleaseIdempotencyKey(ctx context.Context, jobID int64) error
+	GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error)
+
+	SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error)
+	GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error)
+	CompleteBatch(ctx context.Context, batchID int64, now time.Time) (*jobs.Job, error)
+	GetFinishedBatches(ctx context.Context) ([]int64, error)
+
+	ReleaseDependents(ctx context.Context, jobID int64) ([]jobs.Job, error)
+	CancelDependents(ctx context.Context, jobID int64, reason string, now time.Time) ([]int64, error)
+	GetFinishedParents(ctx context.Context) ([]jobs.Job, error)
+
+	SaveJobError(ctx context.Context, jobError jobs.JobError) error
+	GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error)
+	ReplayJob(ctx context.Context, jobID int64, now time.Time) error
+
+	SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error
+	GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error)
+
+	ClaimJob(ctx context.Context, jobID int64, workerID int, leaseToken string, now time.Time, leasedUntil time.Time) (*jobs.Job, error)
+	ExtendLease(ctx context.Context, jobID int64, leaseToken string, leasedUntil time.Time) error
+	GetExpiredLeases(ctx context.Context, now time.Time) ([]jobs.Job, error)
+	ReleaseExpiredLease(ctx context.Context, job *jobs.Job, leasedUntil time.Time) (bool, error)
+
+	SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error)
+	GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error)
+	ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error)
+	DeleteSchedule(ctx context.Context, scheduleID int64) error
+	GetDueSchedules(ctx context.Context, now time.Time) ([]jobs.Schedule, error)
+	NextScheduleTime(ctx context.Context) (*time.Time, error)
+	FireSchedule(ctx context.Context, schedule jobs.Schedule, job jobs.Job, next time.Time) (int64, error)
+	DeferSchedule(ctx context.Context, schedule jobs.Schedule, retryAt time.Time) error
+
+	SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error)
+	ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID int64, tenant string, now time.Time) error
+
+	SaveTenant(ctx context.Context, tenant jobs.Tenant) error
+	ListTenants(ctx context.Context) ([]jobs.Tenant, error)
 }
 
 type MySQLRepository struct {
@@ -30,21 +126,94 @@ func NewMySQLRepository(db *sql.DB) *MySQLRepository {
 
 /*
 Saves the job in database and
-returns job ID
+returns job ID.
+Returns ErrIdempotencyKeyUsed if another job holds the job's idempotency key
+and ErrUniqueKeyActive if an active job holds its unique key.
+A job with dependencies is saved blocked, unless every job it depends on already completed
 */
 func (r MySQLRepository) SaveJob(ctx context.Context, job jobs.Job) (int64, error) {
-	res, err := r.db.ExecContext(
-		ctx,
-		"INSERT INTO jobs (job_type, payload, status, attempt, max_attempts, created_at, scheduled_at) VALUES (?, ?, ?, ?, ?, ?, ?);",
+	var id int64
+	var err error
+	if len(job.DependsOn) > 0 {
+		id, err = r.saveDependentJob(ctx, job)
+	} else {
+		id, err = insertJob(ctx, r.db, job)
+	}
+
+	switch {
+	case isDuplicateKeyOn(err, "uq_idempotency_key"):
+		return 0, ErrIdempotencyKeyUsed
+	case isDuplicateKeyOn(err, "uq_active_unique_key"):
+		return 0, ErrUniqueKeyActive
+	}
+	return id, err
+}
+
+/*
+execer is satisfied by both *sql.DB and *sql.Tx
+*/
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+/*
+Columns written when a job is inserted, in the order of insertArgs
+*/
+const insertColumns = "tenant, job_type, payload, status, queue, priority, attempt, max_attempts, timeout_seconds, retry_policy, created_at, scheduled_at, schedule_id, idempotency_key, unique_key, batch_id, callback_url, callback_secret, trace_parent, request_id, api_key_id"
+
+/*
+Placeholders of a single inserted row
+*/
+const insertRow = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+/*
+Values of the job's insertColumns
+*/
+func insertArgs(job jobs.Job) ([]any, error) {
+	var retryPolicy []byte
+	if job.RetryPolicy != nil {
+		var err error
+		if retryPolicy, err = json.Marshal(job.RetryPolicy); err != nil {
+			return nil, err
+		}
+	}
+
+	return []any{
+		jobs.TenantName(job.Tenant),
 		job.JobType,
 		job.Payload,
 		job.Status,
+		job.Queue,
+		job.Priority,
 		job.Attempt,
 		job.MaxAttempts,
+		job.Timeout,
+		retryPolicy,
 		job.CreatedAt,
 		job.ScheduledAt,
-	)
+		job.ScheduleID,
+		job.IdempotencyKey,
+		job.UniqueKey,
+		job.BatchID,
+		job.CallbackURL,
+		job.CallbackSecret,
+		job.TraceParent,
+		job.RequestID,
+		job.APIKeyID,
+	}, nil
+}
+
+/*
+Inserts a job row using db or an ongoing transaction and
+returns job ID
+*/
+func insertJob(ctx context.Context, db execer, job jobs.Job) (int64, error) {
+	args, err := insertArgs(job)
+	if err != nil {
+		return 0, err
+	}
 
+	res, err := db.ExecContext(ctx, "INSERT INTO jobs ("+insertColumns+") VALUES "+insertRow+";", args...)
 	if err != nil {
 		return 0, err
 	}
@@ -53,37 +222,86 @@ func (r MySQLRepository) SaveJob(ctx context.Context, job jobs.Job) (int64, erro
 }
 
 /*
-Gets job by job ID from database
+Reports whether err is a MySQL duplicate key error
 */
-func (r MySQLRepository) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
-	row := r.db.QueryRowContext(
-		ctx,
-		`SELECT 
-			id,
-			job_type,
-			payload,
-			status,
-			attempt,
-			max_attempts,
-			scheduled_at,
-			created_at,
-			started_at,
-			finished_at,
-			last_error,
-			worker_id 
-		FROM jobs WHERE id = ?`,
-		jobID,
-	)
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+/*
+Reports whether err is a duplicate key error on the named unique index,
+the message ends with "for key 'index'" or "for key 'table.index'" depending on the MySQL version
+*/
+func isDuplicateKeyOn(err error, index string) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		return false
+	}
+	return strings.HasSuffix(mysqlErr.Message, "'"+index+"'") || strings.HasSuffix(mysqlErr.Message, "."+index+"'")
+}
+
+/*
+Columns selected whenever a full job row is read,
+in the order expected by scanJob
+*/
+const jobColumns = `
+	id,
+	tenant,
+	job_type,
+	payload,
+	result,
+	status,
+	queue,
+	priority,
+	attempt,
+	max_attempts,
+	timeout_seconds,
+	retry_policy,
+	scheduled_at,
+	created_at,
+	started_at,
+	finished_at,
+	last_error,
+	worker_id,
+	leased_until,
+	schedule_id,
+	idempotency_key,
+	unique_key,
+	batch_id,
+	callback_url,
+	callback_secret,
+	trace_parent,
+	request_id,
+	api_key_id`
+
+/*
+rowScanner is satisfied by both *sql.Row and *sql.Rows
+*/
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+/*
+Scans a single row selected with jobColumns into a job
+*/
+func scanJob(row rowScanner) (*jobs.Job, error) {
 	var job jobs.Job
+	var result, retryPolicy []byte
 	err := row.Scan(
 		&job.ID,
+		&job.Tenant,
 		&job.JobType,
 		&job.Payload,
+		&result,
 
 		&job.Status,
+		&job.Queue,
+		&job.Priority,
 		&job.Attempt,
 		&job.MaxAttempts,
+		&job.Timeout,
+		&retryPolicy,
 
 		&job.ScheduledAt,
 		&job.CreatedAt,
@@ -92,54 +310,246 @@ func (r MySQLRepository) GetJob(ctx context.Context, jobID int64) (*jobs.Job, er
 
 		&job.LastError,
 		&job.WorkerID,
+		&job.LeasedUntil,
+		&job.ScheduleID,
+		&job.IdempotencyKey,
+		&job.UniqueKey,
+		&job.BatchID,
+		&job.CallbackURL,
+		&job.CallbackSecret,
+		&job.TraceParent,
+		&job.RequestID,
+		&job.APIKeyID,
 	)
+	if err != nil {
+		return nil, err
+	}
+	job.Result = result
 
+	if retryPolicy != nil {
+		job.RetryPolicy = new(jobs.RetryPolicy)
+		if err := json.Unmarshal(retryPolicy, job.RetryPolicy); err != nil {
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+/*
+Gets job by job ID from database
+*/
+func (r MySQLRepository) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE id = ?",
+		jobID,
+	)
+
+	job, err := scanJob(row)
 	if err != nil {
-		log.Printf("%v", err)
-		if err == sql.ErrNoRows {
-			return nil, errors.New(fmt.Sprintf("job with id %v not found", jobID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrJobNotFound
 		}
+		slog.ErrorContext(ctx, "failed to fetch job", "job_id", jobID, "error", err)
 		return nil, err
 	}
 
-	return &job, nil
+	return job, nil
 }
 
 /*
-Updates job by ID in the database
+Lists jobs matching the filter ordered by ID,
+starting after the filter's cursor and returning at most filter.Limit jobs
+*/
+func (r MySQLRepository) ListJobs(ctx context.Context, filter JobFilter) ([]jobs.Job, error) {
+	var conds []string
+	var args []any
+
+	if filter.Tenant != "" {
+		conds = append(conds, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		conds = append(conds, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.JobType != "" {
+		conds = append(conds, "job_type = ?")
+		args = append(args, filter.JobType)
+	}
+	if filter.Queue != "" {
+		conds = append(conds, "queue = ?")
+		args = append(args, filter.Queue)
+	}
+	if filter.WorkerID != nil {
+		conds = append(conds, "worker_id = ?")
+		args = append(args, *filter.WorkerID)
+	}
+	if filter.BatchID != nil {
+		conds = append(conds, "batch_id = ?")
+		args = append(args, *filter.BatchID)
+	}
+	if filter.APIKeyID != nil {
+		conds = append(conds, "api_key_id = ?")
+		args = append(args, *filter.APIKeyID)
+	}
+	if filter.RequestID != "" {
+		conds = append(conds, "request_id = ?")
+		args = append(args, filter.RequestID)
+	}
+	if filter.CreatedAfter != nil {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, *filter.CreatedBefore)
+	}
+	if filter.ScheduledAfter != nil {
+		conds = append(conds, "scheduled_at >= ?")
+		args = append(args, *filter.ScheduledAfter)
+	}
+	if filter.ScheduledBefore != nil {
+		conds = append(conds, "scheduled_at < ?")
+		args = append(args, *filter.ScheduledBefore)
+	}
+	if filter.FinishedAfter != nil {
+		conds = append(conds, "finished_at >= ?")
+		args = append(args, *filter.FinishedAfter)
+	}
+	if filter.FinishedBefore != nil {
+		conds = append(conds, "finished_at < ?")
+		args = append(args, *filter.FinishedBefore)
+	}
+	if filter.AfterID > 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, filter.AfterID)
+	}
+
+	query := "SELECT " + jobColumns + " FROM jobs"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []jobs.Job
+
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *job)
+	}
+
+	return res, rows.Err()
+}
+
+/*
+Saves the outcome of an execution of the job by the worker holding it.
+Only applies while the job is still executing under the claim's lease token, so a job which was cancelled
+or reaped and handed to another worker meanwhile isn't overwritten, ErrLeaseLost is returned instead
 */
 func (r MySQLRepository) UpdateJob(ctx context.Context, job *jobs.Job) error {
-	/*if job isnt in executing status, clear worker and set it to nil in job instance*/
+	leaseToken := job.LeaseToken
+
+	/*if job isnt in executing status, clear worker and lease and set them to nil in job instance*/
 	if job.Status != enums.Executing {
 		job.WorkerID = nil
+		job.LeasedUntil = nil
+		job.LeaseToken = ""
 	}
 
-	_, err := r.db.ExecContext(
+	res, err := r.db.ExecContext(
 		ctx,
-		"UPDATE jobs SET status = ?, worker_id = ?, attempt = ?, started_at = ?, finished_at = ?, last_error = ?, result = ? WHERE id = ?",
+		`UPDATE jobs SET status = ?, worker_id = ?, leased_until = ?, lease_token = NULLIF(?, ''), attempt = ?, started_at = ?, finished_at = ?, last_error = ?, result = ?
+		WHERE id = ? AND status = ? AND lease_token = ?`,
 		job.Status,
 		job.WorkerID,
+		job.LeasedUntil,
+		job.LeaseToken,
 		job.Attempt,
 		job.StartedAt,
 		job.FinishedAt,
 		job.LastError,
 		job.Result,
 		job.ID,
+		enums.Executing,
+		leaseToken,
 	)
 	if err != nil {
 		return err
 	}
 
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrLeaseLost
+	}
+
 	return nil
 }
 
 /*
-Gets the list of pending jobs including jobs which are in retrying state
+Moves a pending, retrying, executing or blocked job to cancelled status
+and drops its lease, the worker executing it records the cancelled attempt.
+The status check is part of the UPDATE so a job which finished
+at the same time can't be overwritten
+*/
+func (r MySQLRepository) CancelJob(ctx context.Context, jobID int64) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE jobs SET status = ?, finished_at = ?, worker_id = NULL, leased_until = NULL, lease_token = NULL WHERE id = ? AND status IN (?, ?, ?, ?)",
+		enums.Cancelled,
+		time.Now(),
+		jobID,
+		enums.Pending,
+		enums.Retrying,
+		enums.Executing,
+		enums.Blocked,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	if _, err := r.GetJob(ctx, jobID); err != nil {
+		return err
+	}
+	return ErrJobNotCancellable
+}
+
+/*
+Gets the list of pending jobs of a queue including jobs which are in retrying state,
+with their tenant and priority so recovery puts them back on the right ready queue.
+Cancelled jobs are left out so recovery doesn't bring them back
 */
-func (r MySQLRepository) GetPendingJobs(ctx context.Context) ([]jobs.RedisJob, error) {
+func (r MySQLRepository) GetPendingJobs(ctx context.Context, queue string) ([]jobs.RedisJob, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
-		"SELECT id, scheduled_at FROM jobs WHERE status IN ('pending', 'retrying')",
+		"SELECT id, scheduled_at, queue, priority, COALESCE(trace_parent, ''), tenant FROM jobs WHERE queue = ? AND status IN ('pending', 'retrying')",
+		queue,
 	)
 	if err != nil {
 		return nil, err
@@ -150,7 +560,7 @@ func (r MySQLRepository) GetPendingJobs(ctx context.Context) ([]jobs.RedisJob, e
 
 	for rows.Next() {
 		var job jobs.RedisJob
-		if err := rows.Scan(&job.JobID, &job.ScheduledAt); err != nil {
+		if err := rows.Scan(&job.JobID, &job.ScheduledAt, &job.Queue, &job.Priority, &job.TraceParent, &job.Tenant); err != nil {
 			return nil, err
 		}
 		res = append(res, job)
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned by the repository when the requested schedule does not exist
+*/
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+/*
+Returned by FireSchedule when the occurrence was already turned into a job,
+either by another scheduler instance or before a restart
+*/
+var ErrScheduleAlreadyFired = errors.New("schedule occurrence already fired")
+
+const scheduleColumns = `
+	id,
+	tenant,
+	cron_expr,
+	timezone,
+	job_type,
+	payload,
+	queue,
+	next_run_at,
+	last_run_at,
+	created_at`
+
+func scanSchedule(row rowScanner) (*jobs.Schedule, error) {
+	var schedule jobs.Schedule
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.Tenant,
+		&schedule.CronExpr,
+		&schedule.Timezone,
+		&schedule.JobType,
+		&schedule.Payload,
+		&schedule.Queue,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+/*
+Saves the schedule in database and
+returns schedule ID
+*/
+func (r MySQLRepository) SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO schedules (tenant, cron_expr, timezone, job_type, payload, queue, next_run_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		jobs.TenantName(schedule.Tenant),
+		schedule.CronExpr,
+		schedule.Timezone,
+		schedule.JobType,
+		schedule.Payload,
+		schedule.Queue,
+		schedule.NextRunAt,
+		schedule.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+/*
+Gets schedule by schedule ID from database
+*/
+func (r MySQLRepository) GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT "+scheduleColumns+" FROM schedules WHERE id = ?",
+		scheduleID,
+	)
+
+	schedule, err := scanSchedule(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduleNotFound
+	}
+
+	return schedule, err
+}
+
+/*
+Lists all schedules of the tenant ordered by ID
+*/
+func (r MySQLRepository) ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error) {
+	return r.querySchedules(ctx, "SELECT "+scheduleColumns+" FROM schedules WHERE tenant = ? ORDER BY id", jobs.TenantName(tenant))
+}
+
+/*
+Deletes schedule by ID, jobs it already created are kept
+*/
+func (r MySQLRepository) DeleteSchedule(ctx context.Context, scheduleID int64) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM schedules WHERE id = ?", scheduleID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrScheduleNotFound
+	}
+
+	return nil
+}
+
+/*
+Gets the schedules whose next occurrence is at or before now
+*/
+func (r MySQLRepository) GetDueSchedules(ctx context.Context, now time.Time) ([]jobs.Schedule, error) {
+	return r.querySchedules(
+		ctx,
+		"SELECT "+scheduleColumns+" FROM schedules WHERE next_run_at <= ? ORDER BY next_run_at",
+		now,
+	)
+}
+
+/*
+Gets the earliest upcoming occurrence across all schedules,
+nil if there are no schedules
+*/
+func (r MySQLRepository) NextScheduleTime(ctx context.Context) (*time.Time, error) {
+	var next sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT MIN(next_run_at) FROM schedules").Scan(&next)
+	if err != nil || !next.Valid {
+		return nil, err
+	}
+
+	return &next.Time, nil
+}
+
+/*
+Turns the schedule's current occurrence into a job and advances
+the schedule to next, in a single transaction.
+
+The UPDATE only matches while next_run_at still equals the occurrence being fired,
+and jobs has a unique key on (schedule_id, scheduled_at),
+so an occurrence is never fired twice across restarts or scheduler instances
+*/
+func (r MySQLRepository) FireSchedule(ctx context.Context, schedule jobs.Schedule, job jobs.Job, next time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE schedules SET next_run_at = ?, last_run_at = ? WHERE id = ? AND next_run_at = ?",
+		next,
+		schedule.NextRunAt,
+		schedule.ID,
+		schedule.NextRunAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrScheduleAlreadyFired
+	}
+
+	job.ScheduleID = &schedule.ID
+	jobID, err := insertJob(ctx, tx, job)
+	if isDuplicateKey(err) {
+		return 0, ErrScheduleAlreadyFired
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return jobID, tx.Commit()
+}
+
+/*
+Moves the schedule's current occurrence to retryAt after it failed to fire.
+Only matches while next_run_at still equals the occurrence,
+so an occurrence another instance fired meanwhile is left alone
+*/
+func (r MySQLRepository) DeferSchedule(ctx context.Context, schedule jobs.Schedule, retryAt time.Time) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE schedules SET next_run_at = ? WHERE id = ? AND next_run_at = ?",
+		retryAt,
+		schedule.ID,
+		schedule.NextRunAt,
+	)
+	return err
+}
+
+func (r MySQLRepository) querySchedules(ctx context.Context, query string, args ...any) ([]jobs.Schedule, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []jobs.Schedule
+
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *schedule)
+	}
+
+	return res, rows.Err()
+}
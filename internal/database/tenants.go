@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Saves the quotas of the tenant, replacing the ones it had
+*/
+func (r MySQLRepository) SaveTenant(ctx context.Context, tenant jobs.Tenant) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO tenants (name, max_running, rate_limit, updated_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE max_running = VALUES(max_running), rate_limit = VALUES(rate_limit), updated_at = VALUES(updated_at)`,
+		tenant.Name,
+		tenant.MaxRunning,
+		tenant.RateLimit,
+		tenant.UpdatedAt,
+	)
+	return err
+}
+
+/*
+Lists the tenants which were given quotas, ordered by name
+*/
+func (r MySQLRepository) ListTenants(ctx context.Context) ([]jobs.Tenant, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT name, max_running, rate_limit, updated_at FROM tenants ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []jobs.Tenant
+
+	for rows.Next() {
+		var tenant jobs.Tenant
+		if err := rows.Scan(&tenant.Name, &tenant.MaxRunning, &tenant.RateLimit, &tenant.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, tenant)
+	}
+
+	return res, rows.Err()
+}
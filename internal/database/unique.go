@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Gets the pending, retrying, executing or blocked job of the tenant holding the unique key
+*/
+func (r MySQLRepository) GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE tenant = ? AND active_unique_key = ?",
+		jobs.TenantName(tenant),
+		key,
+	)
+
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+
+	return job, err
+}
@@ -0,0 +1,43 @@
+package enums
+
+/*
+Priority decides which ready queue a job waits in,
+higher priorities are popped more often
+*/
+type Priority string
+
+const (
+	High   Priority = "high"
+	Normal Priority = "normal"
+	Low    Priority = "low"
+)
+
+/*
+All priorities, highest first
+*/
+var Priorities = []Priority{High, Normal, Low}
+
+/*
+Reports whether p is one of the known priorities
+*/
+func (p Priority) IsValid() bool {
+	switch p {
+	case High, Normal, Low:
+		return true
+	}
+	return false
+}
+
+/*
+Share of pops a priority's ready queue gets while every queue has jobs,
+so low priority jobs keep moving under a flood of high priority ones
+*/
+func (p Priority) Weight() int {
+	switch p {
+	case High:
+		return 6
+	case Low:
+		return 1
+	}
+	return 3
+}
@@ -0,0 +1,48 @@
+package enums
+
+/*
+Strategy used to space out the retries of a failed job
+*/
+type Backoff string
+
+const (
+	Fixed       Backoff = "fixed"
+	Linear      Backoff = "linear"
+	Exponential Backoff = "exponential"
+)
+
+/*
+Reports whether b is one of the known backoff strategies
+*/
+func (b Backoff) IsValid() bool {
+	switch b {
+	case Fixed, Linear, Exponential:
+		return true
+	}
+	return false
+}
+
+/*
+Kind of error a failed attempt ended with,
+retry policies list the kinds a job is retried on
+*/
+type ErrorClass string
+
+const (
+	TimeoutError ErrorClass = "timeout"
+	NetworkError ErrorClass = "network"
+	ClientError  ErrorClass = "4xx"
+	ServerError  ErrorClass = "5xx"
+	OtherError   ErrorClass = "error"
+)
+
+/*
+Reports whether c is one of the known error classes
+*/
+func (c ErrorClass) IsValid() bool {
+	switch c {
+	case TimeoutError, NetworkError, ClientError, ServerError, OtherError:
+		return true
+	}
+	return false
+}
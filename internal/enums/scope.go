@@ -0,0 +1,25 @@
+package enums
+
+/*
+Scope is what an API key is allowed to do:
+submit creates, cancels and replays jobs and schedules, read lists and watches them
+and admin does everything, including managing API keys
+*/
+type Scope string
+
+const (
+	SubmitScope Scope = "submit"
+	ReadScope   Scope = "read"
+	AdminScope  Scope = "admin"
+)
+
+/*
+Reports whether s is one of the known scopes
+*/
+func (s Scope) IsValid() bool {
+	switch s {
+	case SubmitScope, ReadScope, AdminScope:
+		return true
+	}
+	return false
+}
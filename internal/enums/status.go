@@ -8,4 +8,25 @@ const (
 	Completed Status = "completed"
 	Failed    Status = "failed"
 	Retrying  Status = "retrying"
+	Cancelled Status = "cancelled"
+	Blocked   Status = "blocked"
 )
+
+/*
+Reports whether s is a status a job never leaves on its own:
+completed, failed or cancelled
+*/
+func (s Status) IsFinal() bool {
+	return s == Completed || s == Failed || s == Cancelled
+}
+
+/*
+Reports whether s is one of the known job statuses
+*/
+func (s Status) IsValid() bool {
+	switch s {
+	case Pending, Executing, Completed, Failed, Retrying, Cancelled, Blocked:
+		return true
+	}
+	return false
+}
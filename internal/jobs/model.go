@@ -2,34 +2,436 @@ package jobs
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/blueberry-adii/tickr/internal/enums"
 )
 
+/*
+Number of attempts a job gets when the client doesn't ask for a different number
+*/
+const DefaultMaxAttempts = 3
+
+/*
+Queue jobs are sent to when the client doesn't pick one
+*/
+const DefaultQueue = "default"
+
+/*
+Idempotency keys starting with this are reserved for the jobs tickr queues itself, like callbacks
+*/
+const ReservedKeyPrefix = "tickr:"
+
+/*
+Tenant of the jobs submitted without an API key, or with a key created before tenants existed.
+API keys of the default tenant with the admin scope manage every tenant
+*/
+const DefaultTenant = "default"
+
+/*
+Longest tenant name, the size of the MySQL columns
+*/
+const maxTenantLen = 64
+
+/*
+How long a worker holds an executing job without renewing its lease,
+workers renew it every LeaseDuration/3. Jobs whose lease expired
+belong to a crashed worker and are handed back to the waiting queue
+*/
+const LeaseDuration = 30 * time.Second
+
+/*
+Cause of the context cancellation when a running job is cancelled through the API
+*/
+var ErrCancelled = errors.New("job cancelled")
+
 /*
 Structure of job to be stored in redis queues
 */
 type RedisJob struct {
-	JobID       int64     `json:"job_id"`
-	ScheduledAt time.Time `json:"scheduledAt"`
+	JobID       int64          `json:"job_id"`
+	ScheduledAt time.Time      `json:"scheduledAt"`
+	Queue       string         `json:"queue,omitempty"`
+	Priority    enums.Priority `json:"priority,omitempty"`
+	TraceParent string         `json:"traceparent,omitempty"`
+	Tenant      string         `json:"tenant,omitempty"`
 }
 
 /*
 Structure of job to be stored in MySQL
 */
 type Job struct {
-	ID          int64           `json:"id"`
-	JobType     string          `json:"jobtype"`
-	Payload     json.RawMessage `json:"payload"`
-	Result      json.RawMessage `json:"result"`
-	Status      enums.Status    `json:"status"`
-	Attempt     int             `json:"attempt"`
-	MaxAttempts int             `json:"maxAttempts"`
-	ScheduledAt time.Time       `json:"scheduledAt"`
-	CreatedAt   time.Time       `json:"createdAt"`
-	StartedAt   *time.Time      `json:"startedAt"`
-	FinishedAt  *time.Time      `json:"finishedAt"`
-	LastError   *string         `json:"lastError"`
-	WorkerID    *int            `json:"workerID"`
+	ID             int64           `json:"id"`
+	Tenant         string          `json:"tenant"`
+	JobType        string          `json:"jobtype"`
+	Payload        json.RawMessage `json:"payload"`
+	Result         json.RawMessage `json:"result"`
+	Status         enums.Status    `json:"status"`
+	Queue          string          `json:"queue"`
+	Priority       enums.Priority  `json:"priority"`
+	Attempt        int             `json:"attempt"`
+	MaxAttempts    int             `json:"maxAttempts"`
+	Timeout        int             `json:"timeout"`
+	RetryPolicy    *RetryPolicy    `json:"retryPolicy"`
+	ScheduledAt    time.Time       `json:"scheduledAt"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	StartedAt      *time.Time      `json:"startedAt"`
+	FinishedAt     *time.Time      `json:"finishedAt"`
+	LastError      *string         `json:"lastError"`
+	WorkerID       *int            `json:"workerID"`
+	LeasedUntil    *time.Time      `json:"leasedUntil"`
+	LeaseToken     string          `json:"-"`
+	ScheduleID     *int64          `json:"scheduleID"`
+	IdempotencyKey *string         `json:"idempotencyKey"`
+	UniqueKey      *string         `json:"uniqueKey"`
+	DependsOn      []int64         `json:"dependsOn,omitempty"`
+	BatchID        *int64          `json:"batchID"`
+	CallbackURL    *string         `json:"callbackUrl"`
+	CallbackSecret *string         `json:"-"`
+	TraceParent    *string         `json:"-"`
+	RequestID      *string         `json:"requestID"`
+	APIKeyID       *int64          `json:"apiKeyID"`
+}
+
+/*
+Longest part of an attempt's result kept in its history
+*/
+const resultSnippetLen = 512
+
+/*
+A single attempt of a job: when and where it ran, how it ended and,
+if the job is retried, when the next attempt is due.
+Status is the status the job moved to after the attempt
+*/
+type JobAttempt struct {
+	JobID         int64        `json:"jobID"`
+	Attempt       int          `json:"attempt"`
+	WorkerID      *int         `json:"workerID"`
+	Status        enums.Status `json:"status"`
+	StartedAt     *time.Time   `json:"startedAt"`
+	FinishedAt    time.Time    `json:"finishedAt"`
+	DurationMs    int64        `json:"durationMs"`
+	Error         *string      `json:"error"`
+	ResultSnippet *string      `json:"resultSnippet"`
+	NextRetryAt   *time.Time   `json:"nextRetryAt"`
+}
+
+/*
+Status transition of a job, streamed to clients as it happens.
+JobType and Queue are left out when only the job's ID and tenant are known
+*/
+type JobEvent struct {
+	JobID     int64        `json:"jobID"`
+	Tenant    string       `json:"tenant"`
+	JobType   string       `json:"jobtype,omitempty"`
+	Queue     string       `json:"queue,omitempty"`
+	Status    enums.Status `json:"status"`
+	Attempt   int          `json:"attempt"`
+	LastError *string      `json:"lastError"`
+	At        time.Time    `json:"at"`
+}
+
+/*
+Error a failed attempt of a job ended with
+*/
+type JobError struct {
+	JobID     int64     `json:"jobID"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+/*
+Jobs submitted together through the batch endpoint,
+Counts holds the number of the batch's jobs in each status.
+CompletedAt is set once every job reached a final status, OnCompleteJobID
+is the job created by OnComplete at that time
+*/
+type Batch struct {
+	ID              int64                `json:"id"`
+	Tenant          string               `json:"tenant"`
+	Total           int                  `json:"total"`
+	Counts          map[enums.Status]int `json:"counts"`
+	OnComplete      *OnComplete          `json:"onComplete,omitempty"`
+	OnCompleteJobID *int64               `json:"onCompleteJobID"`
+	CreatedAt       time.Time            `json:"createdAt"`
+	CompletedAt     *time.Time           `json:"completedAt"`
+}
+
+/*
+Runs once every job of a batch completed, failed or was cancelled:
+either a job of JobType with Payload, or a webhook POSTing the batch to URL
+*/
+type OnComplete struct {
+	JobType string          `json:"jobtype,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Queue   string          `json:"queue,omitempty"`
+	URL     string          `json:"url,omitempty"`
+}
+
+/*
+Returns the job created when the batch completed at now.
+A webhook is delivered by an http job, so it is retried like any other job
+*/
+func (o *OnComplete) Job(batch Batch, now time.Time) (Job, error) {
+	job := Job{
+		Tenant:      batch.Tenant,
+		JobType:     o.JobType,
+		Payload:     o.Payload,
+		Status:      enums.Pending,
+		Queue:       o.Queue,
+		Priority:    enums.Normal,
+		MaxAttempts: DefaultMaxAttempts,
+		CreatedAt:   now,
+		ScheduledAt: now,
+	}
+	if job.Queue == "" {
+		job.Queue = DefaultQueue
+	}
+
+	if o.URL != "" {
+		batch.OnComplete = nil
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return Job{}, err
+		}
+		job.JobType = "http"
+		job.Payload, err = json.Marshal(map[string]any{
+			"url":     o.URL,
+			"method":  "POST",
+			"headers": map[string]string{"Content-Type": "application/json"},
+			"body":    json.RawMessage(body),
+		})
+		if err != nil {
+			return Job{}, err
+		}
+	}
+
+	return job, nil
+}
+
+/*
+Structure of a recurring schedule stored in MySQL,
+every occurrence of the cron expression creates a new Job
+*/
+type Schedule struct {
+	ID        int64           `json:"id"`
+	Tenant    string          `json:"tenant"`
+	CronExpr  string          `json:"cron"`
+	Timezone  string          `json:"timezone"`
+	JobType   string          `json:"jobtype"`
+	Payload   json.RawMessage `json:"payload"`
+	Queue     string          `json:"queue"`
+	NextRunAt time.Time       `json:"nextRunAt"`
+	LastRunAt *time.Time      `json:"lastRunAt"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+/*
+Structure of an API key stored in MySQL. Only the SHA-256 hash of the key is kept,
+Prefix is its first characters to tell keys apart.
+A key without JobTypes may submit jobs of every type, and only sees the jobs of its Tenant
+*/
+type APIKey struct {
+	ID        int64         `json:"id"`
+	Tenant    string        `json:"tenant"`
+	Name      string        `json:"name"`
+	Prefix    string        `json:"prefix"`
+	KeyHash   string        `json:"-"`
+	Scopes    []enums.Scope `json:"scopes"`
+	JobTypes  []string      `json:"jobTypes"`
+	CreatedAt time.Time     `json:"createdAt"`
+	RevokedAt *time.Time    `json:"revokedAt"`
+}
+
+/*
+Reports whether the key was given scope, admin keys have every scope
+*/
+func (k *APIKey) HasScope(scope enums.Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == enums.AdminScope {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Reports whether the key may submit jobs of jobType
+*/
+func (k *APIKey) AllowsJobType(jobType string) bool {
+	return len(k.JobTypes) == 0 || slices.Contains(k.JobTypes, jobType)
+}
+
+/*
+Reports whether the key may manage every tenant: its API keys and quotas.
+Only admin keys of the default tenant can, an admin key of another tenant manages its own keys
+*/
+func (k *APIKey) IsOperator() bool {
+	return k.HasScope(enums.AdminScope) && TenantName(k.Tenant) == DefaultTenant
+}
+
+/*
+A team sharing the deployment, with its own jobs, queues and quotas.
+MaxRunning is the most jobs of the tenant executing at once and RateLimit
+the most jobs started per second, across every queue and tickr instance. 0 means unlimited
+*/
+type Tenant struct {
+	Name       string    `json:"name"`
+	MaxRunning int       `json:"maxRunning"`
+	RateLimit  int       `json:"rateLimit"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+/*
+Tenant of a job or key, those stored before tenants existed belong to the default tenant
+*/
+func TenantName(name string) string {
+	if name == "" {
+		return DefaultTenant
+	}
+	return name
+}
+
+/*
+Checks that a tenant name is 1 to 64 lowercase letters, digits, '-' or '_',
+so it can be used in Redis keys as is
+*/
+func ValidateTenant(name string) error {
+	if name == "" || len(name) > maxTenantLen {
+		return errors.New("tenant must be 1 to 64 characters")
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return fmt.Errorf("invalid character %q in tenant, expected lowercase letters, digits, '-' or '_'", c)
+		}
+	}
+	return nil
+}
+
+/*
+Controls how a failed job is retried, durations are in seconds.
+The delay before retry n is Base for fixed, Base*n for linear and Base*2^(n-1) for exponential,
+limited to Cap if set and never longer than MaxRetryDelay. Jitter randomly shortens the delay by up to that fraction.
+RetryOn lists the error classes worth retrying, an empty list retries on every error but 4xx responses
+*/
+type RetryPolicy struct {
+	Strategy enums.Backoff      `json:"strategy"`
+	Base     int                `json:"base"`
+	Cap      int                `json:"cap"`
+	Jitter   float64            `json:"jitter"`
+	RetryOn  []enums.ErrorClass `json:"retryOn"`
+}
+
+/*
+Longest a job waits before it is retried, whatever its policy
+*/
+const MaxRetryDelay = 24 * time.Hour
+
+/*
+Policy used by jobs submitted without one: linear backoff of 10 seconds per attempt,
+retrying every error but a 4xx response
+*/
+var DefaultRetryPolicy = RetryPolicy{
+	Strategy: enums.Linear,
+	Base:     10,
+}
+
+/*
+Checks that the policy only uses known strategies and error classes
+and sane durations
+*/
+func (p RetryPolicy) Validate() error {
+	if !p.Strategy.IsValid() {
+		return fmt.Errorf("unknown retry strategy %q", p.Strategy)
+	}
+	if p.Base < 0 || p.Cap < 0 {
+		return errors.New("retry base and cap can't be negative")
+	}
+	if limit := int(MaxRetryDelay / time.Second); p.Base > limit || p.Cap > limit {
+		return fmt.Errorf("retry base and cap can't be longer than %d seconds", limit)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	for _, class := range p.RetryOn {
+		if !class.IsValid() {
+			return fmt.Errorf("unknown error class %q in retryOn", class)
+		}
+	}
+	return nil
+}
+
+/*
+Returns the job's retry policy, or the default one if it has none
+*/
+func (j *Job) Retry() RetryPolicy {
+	if j.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *j.RetryPolicy
+}
+
+/*
+Returns the record of the attempt the job just finished on the worker,
+nextRetryAt is the time the job is retried at, if it is
+*/
+func (j *Job) FinishedAttempt(workerID *int, nextRetryAt *time.Time) JobAttempt {
+	attempt := JobAttempt{
+		JobID:       j.ID,
+		Attempt:     j.Attempt,
+		WorkerID:    workerID,
+		Status:      j.Status,
+		StartedAt:   j.StartedAt,
+		Error:       j.LastError,
+		NextRetryAt: nextRetryAt,
+	}
+	if j.FinishedAt != nil {
+		attempt.FinishedAt = *j.FinishedAt
+	}
+	if j.StartedAt != nil && j.FinishedAt != nil {
+		attempt.DurationMs = j.FinishedAt.Sub(*j.StartedAt).Milliseconds()
+	}
+
+	if len(j.Result) > 0 {
+		snippet := string(j.Result)
+		if len(snippet) > resultSnippetLen {
+			snippet = strings.ToValidUTF8(snippet[:resultSnippetLen], "")
+		}
+		attempt.ResultSnippet = &snippet
+	}
+
+	return attempt
+}
+
+/*
+Returns the event of the job reaching its current status at at
+*/
+func (j *Job) Event(at time.Time) JobEvent {
+	return JobEvent{
+		JobID:     j.ID,
+		Tenant:    TenantName(j.Tenant),
+		JobType:   j.JobType,
+		Queue:     j.Queue,
+		Status:    j.Status,
+		Attempt:   j.Attempt,
+		LastError: j.LastError,
+		At:        at,
+	}
+}
+
+/*
+Returns the queue entry of the job, due at scheduledAt,
+carrying the trace context the job was submitted with
+*/
+func (j *Job) RedisJob(scheduledAt time.Time) *RedisJob {
+	redisJob := &RedisJob{JobID: j.ID, ScheduledAt: scheduledAt, Queue: j.Queue, Priority: j.Priority, Tenant: j.Tenant}
+	if j.TraceParent != nil {
+		redisJob.TraceParent = *j.TraceParent
+	}
+	return redisJob
 }
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Header carrying the ID of a request, echoed in every response
+*/
+const RequestIDHeader = "X-Request-ID"
+
+/*
+Longest request ID taken from a client, longer ones are replaced
+*/
+const maxRequestIDLen = 64
+
+type requestIDKey struct{}
+
+/*
+Returns a logger writing to w at the given level ("debug", "info", "warn" or "error", defaults to "info"),
+as JSON lines unless format is "text". Lines logged with a request's context carry its request ID
+*/
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("unknown log level %q", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+/*
+Makes the logger of New writing to stderr the default one,
+the standard log package writes through it as well
+*/
+func Setup(level, format string) error {
+	logger, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+/*
+Returns the default logger with the fields correlating a line with the job:
+job_id, tenant, job_type, queue, attempt and worker_id, which is null while no worker holds it
+*/
+func Job(job *jobs.Job) *slog.Logger {
+	return slog.With(
+		slog.Int64("job_id", job.ID),
+		slog.String("tenant", jobs.TenantName(job.Tenant)),
+		slog.String("job_type", job.JobType),
+		slog.String("queue", job.Queue),
+		slog.Int("attempt", job.Attempt),
+		slog.Any("worker_id", job.WorkerID),
+	)
+}
+
+/*
+Same as Job for a job only known by its queue entry
+*/
+func QueuedJob(job *jobs.RedisJob) *slog.Logger {
+	return slog.With(
+		slog.Int64("job_id", job.JobID),
+		slog.String("tenant", jobs.TenantName(job.Tenant)),
+		slog.String("queue", job.Queue),
+	)
+}
+
+/*
+Returns a new random request ID
+*/
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+Returns the request ID sent by a client if it can be used as one,
+printable ASCII of at most 64 characters, "" otherwise
+*/
+func ValidRequestID(id string) string {
+	if len(id) > maxRequestIDLen {
+		return ""
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return ""
+		}
+	}
+	return id
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+/*
+Returns the ID of the request ctx belongs to, "" outside of a request
+*/
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+/*
+contextHandler adds the request ID of the context a line is logged with
+*/
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+Registry served on GET /metrics, holding tickr's metrics along with the Go runtime and process metrics
+*/
+var Registry = prometheus.NewRegistry()
+
+/*
+Jobs submitted through the API, by job type and queue
+*/
+var JobsSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tickr_jobs_submitted_total",
+	Help: "Jobs submitted through the API.",
+}, []string{"jobtype", "queue"})
+
+/*
+Executions finished by the workers, by job type and the status the job moved to:
+completed, retrying, failed, cancelled or interrupted by shutdown
+*/
+var JobExecutions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tickr_job_executions_total",
+	Help: "Job executions by outcome.",
+}, []string{"jobtype", "outcome"})
+
+/*
+Time the handler of a job type took to execute a job
+*/
+var JobExecutionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "tickr_job_execution_duration_seconds",
+	Help:    "Time taken by a single job execution.",
+	Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+}, []string{"jobtype"})
+
+/*
+How late the scheduler moved jobs from the waiting queue to the ready queue,
+now minus the job's ScheduledAt
+*/
+var PromotionLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "tickr_scheduler_promotion_lag_seconds",
+	Help:    "Delay between a job being due and its promotion to the ready queue.",
+	Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+}, []string{"queue"})
+
+/*
+Workers of every queue, by state: busy while executing a job, idle otherwise
+*/
+var Workers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tickr_workers",
+	Help: "Workers by queue and state.",
+}, []string{"queue", "state"})
+
+/*
+Queues rebuilt from MySQL after Redis lost their state
+*/
+var RecoveryRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tickr_recovery_runs_total",
+	Help: "Queues rebuilt from MySQL after Redis lost their state.",
+}, []string{"queue"})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		JobsSubmitted,
+		JobExecutions,
+		JobExecutionDuration,
+		PromotionLag,
+		Workers,
+		RecoveryRuns,
+	)
+}
+
+/*
+Serves the registry in the Prometheus text format
+*/
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,24 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+func (s *Scheduler) SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error) {
+	return s.Repository.SaveAPIKey(ctx, key)
+}
+
+func (s *Scheduler) GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error) {
+	return s.Repository.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *Scheduler) ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error) {
+	return s.Repository.ListAPIKeys(ctx, tenant)
+}
+
+func (s *Scheduler) RevokeAPIKey(ctx context.Context, keyID int64, tenant string) error {
+	return s.Repository.RevokeAPIKey(ctx, keyID, tenant, time.Now())
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/metrics"
+	"github.com/blueberry-adii/tickr/internal/tracing"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Saves the batch with its jobs and publishes the status of every job
+*/
+func (s *Scheduler) SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error) {
+	parent, request := traceParent(ctx), requestID(ctx)
+	for i := range batchJobs {
+		if batchJobs[i].TraceParent == nil {
+			batchJobs[i].TraceParent = parent
+		}
+		if batchJobs[i].RequestID == nil {
+			batchJobs[i].RequestID = request
+		}
+	}
+	batchID, jobIDs, err := s.Repository.SaveBatch(ctx, batch, batchJobs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now()
+	events := make([]jobs.JobEvent, 0, len(jobIDs))
+	for i, jobID := range jobIDs {
+		if i >= len(batchJobs) {
+			break
+		}
+		batchJobs[i].ID = jobID
+		events = append(events, batchJobs[i].Event(now))
+		metrics.JobsSubmitted.WithLabelValues(batchJobs[i].JobType, queueName(batchJobs[i].Queue)).Inc()
+	}
+	s.publish(ctx, events...)
+
+	return batchID, jobIDs, nil
+}
+
+func (s *Scheduler) GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error) {
+	return s.Repository.GetBatch(ctx, batchID)
+}
+
+/*
+Pushes many jobs in a single round trip: jobs due after now go onto the waiting queue
+of their tenant and queue, the rest onto their ready queue, then the fetchers of the queues
+which got ready jobs are woken up
+*/
+func (s *Scheduler) PushBatch(ctx context.Context, batch []*jobs.RedisJob, now time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "queue.push",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.Int("tickr.batch.size", len(batch))),
+	)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	parent := tracing.TraceParent(ctx)
+
+	delayed := false
+	notify := make(map[string]bool)
+
+	_, err = s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, job := range batch {
+			entry := *job
+			if entry.TraceParent == "" {
+				entry.TraceParent = parent
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			addTenant(ctx, pipe, job)
+			if job.ScheduledAt.After(now) {
+				pipe.ZAdd(ctx, waitingKey(job.Tenant, job.Queue), &redis.Z{
+					Score:  float64(job.ScheduledAt.Unix()),
+					Member: data,
+				})
+				delayed = true
+				continue
+			}
+			pipe.LPush(ctx, readyKey(job.Tenant, job.Queue, job.Priority), data)
+			notify[queueName(job.Queue)] = true
+		}
+
+		for name := range notify {
+			pipe.LPush(ctx, notifyKey(name), 1)
+			pipe.LTrim(ctx, notifyKey(name), 0, 0)
+		}
+		return nil
+	})
+
+	if err == nil && delayed {
+		select {
+		case s.wqCh <- 1:
+		default:
+		}
+	}
+
+	return err
+}
+
+/*
+Called when a job of the batch reached its final status. If it was the batch's last
+active job, the batch is completed and the job created by its OnComplete is queued
+*/
+func (s *Scheduler) completeBatch(ctx context.Context, batchID int64) {
+	job, err := s.Repository.CompleteBatch(ctx, batchID, time.Now())
+	if errors.Is(err, database.ErrBatchNotComplete) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to complete batch", "batch_id", batchID, "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "batch completed", "batch_id", batchID)
+	if job != nil {
+		logging.Job(job).InfoContext(ctx, "queued job on completion of batch", "batch_id", batchID)
+		s.publishJob(ctx, job)
+		s.PushReadyQueue(ctx, job.RedisJob(job.ScheduledAt))
+	}
+}
+
+/*
+Completes the batches whose last job finished while no tickr instance could complete them
+*/
+func (s *Scheduler) resolveBatches(ctx context.Context) {
+	batchIDs, err := s.Repository.GetFinishedBatches(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch finished batches", "error", err)
+		return
+	}
+
+	for _, batchID := range batchIDs {
+		s.completeBatch(ctx, batchID)
+	}
+}
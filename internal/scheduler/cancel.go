@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/go-redis/redis/v8"
+)
+
+/*
+Redis pub/sub channel on which cancelled job IDs are announced,
+so every tickr instance can stop the job if one of its workers is running it
+*/
+const cancelChannel = "tickr:jobs:cancelled"
+
+/*
+Cancels a pending, retrying, executing or blocked job in MySQL and removes
+its entries from the waiting and ready queues.
+If a worker already popped the job, the worker skips it
+because the job is no longer pending or retrying,
+if a worker is executing it, the running handler is cancelled.
+Jobs blocked on the cancelled job are cancelled as well
+*/
+func (s *Scheduler) CancelJob(ctx context.Context, jobID int64) error {
+	if err := s.Repository.CancelJob(ctx, jobID); err != nil {
+		return err
+	}
+
+	s.cancelRunning(jobID)
+	if err := s.redis.client.Publish(ctx, cancelChannel, jobID).Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to publish cancellation of job", "job_id", jobID, "error", err)
+	}
+	job, err := s.Repository.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	/*a worker popping an entry left behind skips it, the job is no longer pending or retrying*/
+	if err := s.removeFromQueues(ctx, jobID); err != nil {
+		logging.Job(job).ErrorContext(ctx, "failed to remove cancelled job from its queues", "error", err)
+	}
+	s.publishJob(ctx, job)
+	s.JobFinished(ctx, job)
+
+	return nil
+}
+
+/*
+Returns a context derived from ctx which is cancelled with jobs.ErrCancelled
+when the job is cancelled through the API on any tickr instance.
+The returned function must be called once the job finished executing
+*/
+func (s *Scheduler) WatchCancel(ctx context.Context, jobID int64) (context.Context, context.CancelFunc) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+
+	s.runningMu.Lock()
+	s.running[jobID] = cancel
+	s.runningMu.Unlock()
+
+	return jobCtx, func() {
+		s.runningMu.Lock()
+		delete(s.running, jobID)
+		s.runningMu.Unlock()
+		cancel(nil)
+	}
+}
+
+/*
+Called by the worker once a job cancelled while executing returned from its handler,
+the job held its slot in its tenant's quota until then
+*/
+func (s *Scheduler) JobStopped(ctx context.Context, job *jobs.Job) {
+	s.finishSlot(ctx, job)
+}
+
+/*
+Cancels the job's context if one of this instance's workers is running it
+*/
+func (s *Scheduler) cancelRunning(jobID int64) {
+	s.runningMu.Lock()
+	cancel, ok := s.running[jobID]
+	s.runningMu.Unlock()
+
+	if ok {
+		cancel(jobs.ErrCancelled)
+	}
+}
+
+/*
+Listens for cancellations announced by any tickr instance
+and stops the matching running jobs, till ctx is cancelled
+*/
+func (s *Scheduler) watchCancellations(ctx context.Context) {
+	pubsub := s.redis.client.Subscribe(ctx, cancelChannel)
+	defer pubsub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return
+			}
+			jobID, err := strconv.ParseInt(msg.Payload, 10, 64)
+			if err != nil {
+				continue
+			}
+			s.cancelRunning(jobID)
+		}
+	}
+}
+
+/*
+Removes the entries pushed for the job from the waiting and ready queues they were pushed onto.
+Every entry is recorded under queuedKey when it is pushed and names its own tenant, queue and priority,
+so the entries are removed by value without scanning the queues
+*/
+func (s *Scheduler) removeFromQueues(ctx context.Context, jobID int64) error {
+	items, err := s.redis.client.SMembers(ctx, queuedKey(jobID)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, item := range items {
+			var job jobs.RedisJob
+			if err := json.Unmarshal([]byte(item), &job); err != nil {
+				continue
+			}
+			pipe.ZRem(ctx, waitingKey(job.Tenant, job.Queue), item)
+			pipe.LRem(ctx, readyKey(job.Tenant, job.Queue, job.Priority), 0, item)
+		}
+		pipe.Del(ctx, queuedKey(jobID))
+		return nil
+	})
+	return err
+}
+
+/*
+Reports whether a serialized queue member belongs to the job
+*/
+func matchesJob(item string, jobID int64) bool {
+	var job jobs.RedisJob
+	if err := json.Unmarshal([]byte(item), &job); err != nil {
+		return false
+	}
+	return job.JobID == jobID
+}
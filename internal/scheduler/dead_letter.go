@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+func (s *Scheduler) SaveJobError(ctx context.Context, jobError jobs.JobError) error {
+	return s.Repository.SaveJobError(ctx, jobError)
+}
+
+func (s *Scheduler) SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error {
+	return s.Repository.SaveJobAttempt(ctx, attempt)
+}
+
+func (s *Scheduler) GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error) {
+	return s.Repository.GetJobAttempts(ctx, jobID)
+}
+
+func (s *Scheduler) GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error) {
+	return s.Repository.GetJobErrors(ctx, jobIDs)
+}
+
+/*
+Resets a permanently failed job in MySQL and pushes it onto the ready queue of its priority,
+the job then gets its full number of attempts again
+*/
+func (s *Scheduler) ReplayJob(ctx context.Context, jobID int64) error {
+	now := time.Now()
+	if err := s.Repository.ReplayJob(ctx, jobID, now); err != nil {
+		return err
+	}
+
+	job, err := s.Repository.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	s.publishJob(ctx, job)
+
+	return s.PushReadyQueue(ctx, job.RedisJob(now))
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+)
+
+/*
+Called once a job reached its final status.
+A completed job releases its blocked dependents whose other parents completed as well,
+pushing them onto the waiting or ready queue. A failed or cancelled job cancels its
+blocked dependents, and theirs, all the way down the chain.
+Completed and failed jobs with a callback URL get their callback queued.
+The job's batch is completed if this was its last active job
+*/
+func (s *Scheduler) JobFinished(ctx context.Context, job *jobs.Job) {
+	if job.BatchID != nil && job.Status.IsFinal() {
+		defer s.completeBatch(ctx, *job.BatchID)
+	}
+	if job.Status.IsFinal() {
+		if err := s.redis.client.Del(ctx, queuedKey(job.ID)).Err(); err != nil {
+			logging.Job(job).ErrorContext(ctx, "failed to drop queue entries of job", "error", err)
+		}
+	}
+
+	if job.Status == enums.Completed || job.Status == enums.Failed {
+		s.queueCallback(ctx, job)
+	}
+
+	switch job.Status {
+	case enums.Completed:
+		released, err := s.Repository.ReleaseDependents(ctx, job.ID)
+		if err != nil {
+			logging.Job(job).ErrorContext(ctx, "failed to release dependents of job", "error", err)
+		}
+		for _, dependent := range released {
+			logging.Job(&dependent).InfoContext(ctx, "job unblocked", "parent_id", job.ID)
+			s.publishJob(ctx, &dependent)
+			s.pushUnblocked(ctx, &dependent)
+		}
+
+	case enums.Failed, enums.Cancelled:
+		parents := []int64{job.ID}
+		status := job.Status
+		for len(parents) > 0 {
+			parentID := parents[0]
+			parents = parents[1:]
+
+			reason := fmt.Sprintf("dependency %d %s", parentID, status)
+			cancelled, err := s.Repository.CancelDependents(ctx, parentID, reason, time.Now())
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to cancel dependents of job", "job_id", parentID, "error", err)
+			}
+			now := time.Now()
+			events := make([]jobs.JobEvent, len(cancelled))
+			for i, id := range cancelled {
+				slog.InfoContext(ctx, "cancelled: dependency did not complete", "job_id", id, "reason", reason)
+				/*a job only depends on jobs of its own tenant*/
+				events[i] = jobs.JobEvent{JobID: id, Tenant: jobs.TenantName(job.Tenant), Status: enums.Cancelled, LastError: &reason, At: now}
+			}
+			s.publish(ctx, events...)
+
+			parents = append(parents, cancelled...)
+			/*the dependents further down were cancelled along with their parent*/
+			status = enums.Cancelled
+		}
+	}
+}
+
+/*
+Pushes a job released by its dependencies onto the waiting queue if it is still delayed,
+otherwise onto the ready queue
+*/
+func (s *Scheduler) pushUnblocked(ctx context.Context, job *jobs.Job) {
+	redisJob := job.RedisJob(job.ScheduledAt)
+	if job.ScheduledAt.After(time.Now()) {
+		s.PushWaitingQueue(ctx, redisJob)
+		return
+	}
+	s.PushReadyQueue(ctx, redisJob)
+}
+
+/*
+Resolves the dependents of jobs which finished while no tickr instance ran their hook,
+e.g. the process crashed right after a job completed
+*/
+func (s *Scheduler) resolveDependencies(ctx context.Context) {
+	parents, err := s.Repository.GetFinishedParents(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch finished parents of blocked jobs", "error", err)
+		return
+	}
+
+	for _, parent := range parents {
+		s.JobFinished(ctx, &parent)
+	}
+}
@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/go-redis/redis/v8"
+)
+
+/*
+Redis pub/sub channel on which every status transition is announced,
+so clients connected to any tickr instance see the jobs of all of them
+*/
+const eventsChannel = "tickr:jobs:events"
+
+/*
+Events buffered for a subscriber, a subscriber falling further behind is dropped
+*/
+const eventBuffer = 256
+
+/*
+Returns a channel receiving the status transitions of all jobs, published by any tickr instance,
+till ctx is cancelled. A subscriber which doesn't keep up has its channel closed instead of
+holding up everyone else, as is every channel once the scheduler shuts down
+*/
+func (s *Scheduler) Events(ctx context.Context) <-chan jobs.JobEvent {
+	ch := make(chan jobs.JobEvent, eventBuffer)
+
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	if s.eventsClosed {
+		close(ch)
+		return ch
+	}
+	s.subscribers[ch] = true
+
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(ch)
+	}()
+
+	return ch
+}
+
+func (s *Scheduler) unsubscribe(ch chan jobs.JobEvent) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	if s.subscribers[ch] {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+/*
+Publishes the current status of the jobs.
+Events are published even if ctx was cancelled, since the status they announce was already saved
+*/
+func (s *Scheduler) publish(ctx context.Context, events ...jobs.JobEvent) {
+	if len(events) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	_, err := s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			pipe.Publish(ctx, eventsChannel, data)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to publish job events", "events", len(events), "error", err)
+	}
+}
+
+/*
+Publishes the current status of a job
+*/
+func (s *Scheduler) publishJob(ctx context.Context, job *jobs.Job) {
+	s.publish(ctx, job.Event(time.Now()))
+}
+
+/*
+Listens for the events published by every tickr instance and hands them to this instance's subscribers,
+till ctx is cancelled
+*/
+func (s *Scheduler) watchEvents(ctx context.Context) {
+	pubsub := s.redis.client.Subscribe(ctx, eventsChannel)
+	defer pubsub.Close()
+	defer s.closeSubscribers()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return
+			}
+			var event jobs.JobEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			s.fanOut(event)
+		}
+	}
+}
+
+/*
+Hands the event to every subscriber without blocking,
+subscribers whose buffer is full are dropped
+*/
+func (s *Scheduler) fanOut(event jobs.JobEvent) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("dropping event subscriber which fell behind", "events", eventBuffer)
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+/*
+Closes every subscriber's channel on shutdown, so open streams end
+*/
+func (s *Scheduler) closeSubscribers() {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	s.eventsClosed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
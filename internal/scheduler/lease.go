@@ -0,0 +1,297 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/go-redis/redis/v8"
+)
+
+/*
+Expiring key which tells other instances this instance is still alive
+*/
+func aliveKey(instance string) string {
+	return "tickr:instance:" + instance
+}
+
+/*
+Random ID which tells this process's processing list apart from other instances
+*/
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+Random token a claim holds the job's lease with
+*/
+func newLeaseToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+Claims a popped job for the worker in MySQL with a fresh lease and
+removes it from this instance's processing list. The job keeps the slot it took
+in its tenant's quota while it executes, a job which can't be claimed frees it.
+Returns database.ErrJobNotClaimable if the job was cancelled or is
+already handled by another worker, the worker should skip it
+*/
+func (s *Scheduler) ClaimJob(ctx context.Context, redisJob *jobs.RedisJob, workerID int) (*jobs.Job, error) {
+	now := time.Now()
+	job, err := s.Repository.ClaimJob(ctx, redisJob.JobID, workerID, newLeaseToken(), now, now.Add(jobs.LeaseDuration))
+	if err != nil && !errors.Is(err, database.ErrJobNotClaimable) {
+		/*on shutdown the job stays on the processing list, which is handed back to the ready queue*/
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logging.QueuedJob(redisJob).ErrorContext(ctx, "failed to claim job, retrying in 5 seconds", "error", err)
+		retry := *redisJob
+		retry.ScheduledAt = now.Add(5 * time.Second)
+		s.PushWaitingQueue(ctx, &retry)
+	}
+
+	s.ack(context.WithoutCancel(ctx), redisJob)
+	if err != nil {
+		s.releaseSlot(context.WithoutCancel(ctx), redisJob.Tenant, redisJob.Queue, redisJob.JobID)
+		return job, err
+	}
+	s.holdSlot(job)
+	s.publishJob(ctx, job)
+	return job, nil
+}
+
+/*
+Renews the lease of a job the worker is executing, along with its slot in its tenant's quota
+*/
+func (s *Scheduler) ExtendLease(ctx context.Context, jobID int64, leaseToken string) error {
+	leasedUntil := time.Now().Add(jobs.LeaseDuration)
+	if err := s.Repository.ExtendLease(ctx, jobID, leaseToken, leasedUntil); err != nil {
+		return err
+	}
+	s.renewSlot(ctx, jobID, leasedUntil)
+	return nil
+}
+
+/*
+Removes the job's entry from this instance's processing list of the job's tenant and queue
+*/
+func (s *Scheduler) ack(ctx context.Context, redisJob *jobs.RedisJob) {
+	key := processingKey(redisJob.Tenant, redisJob.Queue, s.instance)
+
+	items, err := s.redis.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		logging.QueuedJob(redisJob).ErrorContext(ctx, "failed to ack job", "error", err)
+		return
+	}
+	for _, item := range items {
+		if matchesJob(item, redisJob.JobID) {
+			s.redis.client.LRem(ctx, key, 1, item)
+			return
+		}
+	}
+}
+
+/*
+Registers this instance with its queues and marks it alive for one lease duration
+*/
+func (s *Scheduler) heartbeatInstance(ctx context.Context) {
+	for _, name := range s.queueNames {
+		s.redis.client.SAdd(ctx, instancesKey(name), s.instance)
+	}
+	s.redis.client.Set(ctx, aliveKey(s.instance), time.Now().Unix(), jobs.LeaseDuration)
+}
+
+/*
+Runs every third of the lease duration till ctx is cancelled:
+keeps this instance alive, reaps the leases of crashed workers and instances
+and reloads the tenant quotas. On shutdown the jobs this instance popped but no worker claimed
+are handed back to the ready queue
+*/
+func (s *Scheduler) runLeases(ctx context.Context) {
+	ticker := time.NewTicker(jobs.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.heartbeatInstance(ctx)
+			s.reapLeases(ctx)
+			/*picks up quotas saved on other instances*/
+			s.loadQuotas(ctx)
+		}
+	}
+}
+
+/*
+Unregisters this instance and moves its processing lists back to the ready queues
+*/
+func (s *Scheduler) releaseInstance(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+
+	s.redis.client.Del(ctx, aliveKey(s.instance))
+	for _, name := range s.queueNames {
+		if n := s.requeueProcessing(ctx, name, s.instance); n > 0 {
+			slog.InfoContext(ctx, "handed unclaimed jobs back to queue", "jobs", n, "queue", name)
+		}
+		s.redis.client.SRem(ctx, instancesKey(name), s.instance)
+	}
+}
+
+/*
+Returns jobs whose worker stopped renewing their lease to the waiting queue,
+and moves the processing lists of instances which stopped heartbeating back to the ready queues.
+Only the queues this instance serves are checked, an instance serving another queue reaps it
+*/
+func (s *Scheduler) reapLeases(ctx context.Context) {
+	s.reapExpiredLeases(ctx)
+
+	for _, name := range s.queueNames {
+		instances, err := s.redis.client.SMembers(ctx, instancesKey(name)).Result()
+		if err != nil {
+			return
+		}
+		for _, instance := range instances {
+			if instance == s.instance {
+				continue
+			}
+			alive, err := s.redis.client.Exists(ctx, aliveKey(instance)).Result()
+			if err != nil || alive > 0 {
+				continue
+			}
+
+			if n := s.requeueProcessing(ctx, name, instance); n > 0 {
+				slog.WarnContext(ctx, "instance stopped, moved its jobs back to queue", "instance", instance, "jobs", n, "queue", name)
+			}
+			s.redis.client.SRem(ctx, instancesKey(name), instance)
+		}
+	}
+}
+
+/*
+Moves every entry of the processing list (KEYS[1]) to the front of the ready queue
+of its priority (KEYS[4..]), freeing its slot in the tenant's running jobs (KEYS[3]),
+and sets the notify token (KEYS[2]), returns the number of entries moved
+*/
+var requeueScript = redis.NewScript(routeLua + `
+local n = 0
+while true do
+	local item = redis.call("RPOP", KEYS[1])
+	if not item then
+		break
+	end
+	redis.call("RPUSH", readyFor(item, 4, 1), item)
+	local ok, job = pcall(cjson.decode, item)
+	if ok and type(job) == "table" and type(job.job_id) == "number" then
+		redis.call("ZREM", KEYS[3], string.format("%d", job.job_id))
+	end
+	n = n + 1
+end
+if n > 0 then
+	redis.call("LPUSH", KEYS[2], 1)
+	redis.call("LTRIM", KEYS[2], 0, 0)
+end
+return n
+`)
+
+/*
+Moves every job on the instance's processing lists of the queue to the front of its ready queue,
+in a single script per tenant so concurrent reapers never lose or duplicate an entry.
+Returns the number of jobs moved
+*/
+func (s *Scheduler) requeueProcessing(ctx context.Context, queue string, instance string) int {
+	tenants, err := s.queueTenants(ctx, queue)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to requeue processing list", "instance", instance, "queue", queue, "error", err)
+		return 0
+	}
+
+	total := 0
+	for _, tenant := range tenants {
+		keys := append([]string{processingKey(tenant, queue, instance), notifyKey(queue), runningKey(tenant)}, readyKeys(tenant, queue)...)
+
+		n, err := requeueScript.Run(ctx, s.redis.client, keys, priorityArgs()...).Int()
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to requeue processing list", "instance", instance, "queue", queue, "tenant", tenant, "error", err)
+		}
+		total += n
+	}
+	return total
+}
+
+/*
+Executing jobs whose lease expired belong to a worker which crashed or lost its connection.
+The lost execution counts as an attempt: the job goes back to the waiting queue
+or fails once it used all its attempts
+*/
+func (s *Scheduler) reapExpiredLeases(ctx context.Context) {
+	now := time.Now()
+
+	expired, err := s.Repository.GetExpiredLeases(ctx, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch expired leases", "error", err)
+		return
+	}
+
+	for _, job := range expired {
+		leasedUntil := *job.LeasedUntil
+
+		errMsg := "lease expired: worker stopped executing the job"
+		if job.WorkerID != nil {
+			errMsg = fmt.Sprintf("lease expired: worker %d stopped executing the job", *job.WorkerID)
+		}
+
+		job.Attempt = job.Attempt + 1
+		job.LastError = &errMsg
+		job.FinishedAt = &now
+		job.Status = enums.Retrying
+		if job.Attempt >= job.MaxAttempts {
+			job.Status = enums.Failed
+		}
+
+		released, err := s.Repository.ReleaseExpiredLease(ctx, &job, leasedUntil)
+		if err != nil {
+			logging.Job(&job).ErrorContext(ctx, "failed to release expired lease of job", "error", err)
+			continue
+		}
+		/*another instance reaped it first, or the worker renewed the lease just in time*/
+		if !released {
+			continue
+		}
+		s.releaseSlot(ctx, job.Tenant, job.Queue, job.ID)
+
+		s.SaveJobError(ctx, jobs.JobError{
+			JobID:     job.ID,
+			Attempt:   job.Attempt,
+			Error:     errMsg,
+			CreatedAt: now,
+		})
+		var nextRetryAt *time.Time
+		if job.Status == enums.Retrying {
+			nextRetryAt = &now
+		}
+		s.SaveJobAttempt(ctx, job.FinishedAttempt(job.WorkerID, nextRetryAt))
+		s.publishJob(ctx, &job)
+
+		if job.Status == enums.Failed {
+			logging.Job(&job).WarnContext(ctx, "failed: lease of job expired with max attempts", "max_attempts", job.MaxAttempts)
+			s.JobFinished(ctx, &job)
+			continue
+		}
+		logging.Job(&job).WarnContext(ctx, "retry: lease of job expired, sending back to waiting queue")
+		s.PushWaitingQueue(ctx, job.RedisJob(now))
+	}
+}
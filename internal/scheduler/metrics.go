@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	waitingDepthDesc = prometheus.NewDesc(
+		"tickr_queue_waiting_jobs",
+		"Jobs in the waiting queue, delayed or due for a retry.",
+		[]string{"queue", "tenant"}, nil,
+	)
+	readyDepthDesc = prometheus.NewDesc(
+		"tickr_queue_ready_jobs",
+		"Jobs in the ready queue of a priority.",
+		[]string{"queue", "tenant", "priority"}, nil,
+	)
+	runningDesc = prometheus.NewDesc(
+		"tickr_tenant_running_jobs",
+		"Jobs of the tenant holding a slot of its concurrency quota.",
+		[]string{"tenant"}, nil,
+	)
+)
+
+/*
+Longest a scrape waits on Redis for the queue depths
+*/
+const depthTimeout = 2 * time.Second
+
+/*
+Reads the depths of the waiting and ready queues of every tenant in the scheduler's queues,
+and the running jobs of the tenants, from Redis on every scrape, so they are shared by all tickr instances
+*/
+type depthCollector struct {
+	s *Scheduler
+}
+
+/*
+Returns a collector of the depths of the queues this scheduler serves
+*/
+func (s *Scheduler) DepthCollector() prometheus.Collector {
+	return depthCollector{s}
+}
+
+func (c depthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- waitingDepthDesc
+	ch <- readyDepthDesc
+	ch <- runningDesc
+}
+
+/*
+Depths of a tenant's queues
+*/
+type tenantDepth struct {
+	queue   string
+	tenant  string
+	waiting *redis.IntCmd
+	ready   []*redis.IntCmd
+}
+
+func (c depthCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), depthTimeout)
+	defer cancel()
+
+	var depths []tenantDepth
+	running := make(map[string]*redis.IntCmd)
+	for _, name := range c.s.queueNames {
+		tenants, err := c.s.queueTenants(ctx, name)
+		if err != nil {
+			slog.Error("failed to read queue depths", "error", err)
+			return
+		}
+		for _, tenant := range tenants {
+			depths = append(depths, tenantDepth{queue: name, tenant: tenant})
+			running[tenant] = nil
+		}
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	_, err := c.s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, depth := range depths {
+			depths[i].waiting = pipe.ZCard(ctx, waitingKey(depth.tenant, depth.queue))
+			for _, priority := range enums.Priorities {
+				depths[i].ready = append(depths[i].ready, pipe.LLen(ctx, readyKey(depth.tenant, depth.queue, priority)))
+			}
+		}
+		/*slots which expired belong to crashed workers*/
+		for tenant := range running {
+			running[tenant] = pipe.ZCount(ctx, runningKey(tenant), "("+now, "+inf")
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to read queue depths", "error", err)
+		return
+	}
+
+	for _, depth := range depths {
+		ch <- prometheus.MustNewConstMetric(waitingDepthDesc, prometheus.GaugeValue, float64(depth.waiting.Val()), depth.queue, depth.tenant)
+		for i, priority := range enums.Priorities {
+			ch <- prometheus.MustNewConstMetric(readyDepthDesc, prometheus.GaugeValue, float64(depth.ready[i].Val()), depth.queue, depth.tenant, string(priority))
+		}
+	}
+	for tenant, count := range running {
+		ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, float64(count.Val()), tenant)
+	}
+}
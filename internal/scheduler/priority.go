@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/tracing"
+	"github.com/go-redis/redis/v8"
+)
+
+/*
+Arguments of the scripts which route a queue entry to its ready queue:
+the priority names in the same order as readyKeys
+*/
+func priorityArgs() []any {
+	args := make([]any, len(enums.Priorities))
+	for i, priority := range enums.Priorities {
+		args[i] = string(priority)
+	}
+	return args
+}
+
+/*
+Lua helper shared by the scripts which move entries onto the ready queues.
+KEYS[first..] are the ready queues and ARGV[argFirst..] their priorities,
+entries with a missing or unknown priority go to the normal queue
+*/
+const routeLua = `
+local function readyFor(item, first, argFirst)
+	local ok, job = pcall(cjson.decode, item)
+	local priority = "normal"
+	if ok and type(job) == "table" and type(job.priority) == "string" and job.priority ~= "" then
+		priority = job.priority
+	end
+	local fallback
+	for i = argFirst, #ARGV do
+		local key = KEYS[first + i - argFirst]
+		if ARGV[i] == priority then
+			return key
+		end
+		if ARGV[i] == "normal" then
+			fallback = key
+		end
+	end
+	return fallback
+end
+`
+
+/*
+Pops the oldest job of the first non-empty ready queue of a tenant in KEYS[4..], in the given order,
+onto the processing list KEYS[1], unless the tenant used up its quotas:
+ARGV[3] jobs already running in KEYS[2], or ARGV[4] jobs popped this second, counted in KEYS[3].
+0 means unlimited. The popped job takes a slot in KEYS[2] till ARGV[2], slots which expired
+before ARGV[1] belong to crashed workers and are dropped
+*/
+var popScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+local maxRunning = tonumber(ARGV[3])
+if maxRunning > 0 and redis.call("ZCARD", KEYS[2]) >= maxRunning then
+	return false
+end
+local rateLimit = tonumber(ARGV[4])
+if rateLimit > 0 and tonumber(redis.call("GET", KEYS[3]) or "0") >= rateLimit then
+	return false
+end
+for i = 4, #KEYS do
+	local item = redis.call("RPOPLPUSH", KEYS[i], KEYS[1])
+	if item then
+		local ok, job = pcall(cjson.decode, item)
+		if ok and type(job) == "table" and type(job.job_id) == "number" then
+			redis.call("ZADD", KEYS[2], ARGV[2], string.format("%d", job.job_id))
+		end
+		if redis.call("INCR", KEYS[3]) == 1 then
+			redis.call("PEXPIRE", KEYS[3], 1000)
+		end
+		return item
+	end
+end
+return false
+`)
+
+/*
+Smooth weighted round robin over the priorities: every pop prefers the priority
+which is furthest behind its share, e.g. with weights 6/3/1 low priority jobs
+get one pop in ten even while high priority jobs keep arriving
+*/
+type fairness struct {
+	current map[enums.Priority]int
+}
+
+func newFairness() *fairness {
+	return &fairness{current: make(map[enums.Priority]int)}
+}
+
+/*
+Returns the order in which the ready queues are tried for the next pop:
+the preferred priority first, then the rest highest first
+*/
+func (f *fairness) next() []enums.Priority {
+	total := 0
+	var best enums.Priority
+	for _, priority := range enums.Priorities {
+		f.current[priority] += priority.Weight()
+		total += priority.Weight()
+		if best == "" || f.current[priority] > f.current[best] {
+			best = priority
+		}
+	}
+	f.current[best] -= total
+
+	order := []enums.Priority{best}
+	for _, priority := range enums.Priorities {
+		if priority != best {
+			order = append(order, priority)
+		}
+	}
+	return order
+}
+
+/*
+Moves the next job from the queue's ready queues onto this instance's processing list.
+The tenants take turns, starting after the one served last, so a tenant with a large
+backlog can't starve the others, and tenants which used up their quotas are skipped.
+The ready queue of the tenant is picked by weighted fair selection.
+Returns the job along with its tenant, redis.Nil if no tenant has a job it may run now
+*/
+func (s *Scheduler) popReady(ctx context.Context, q *queue) (string, string, error) {
+	/*a push from now on sets the token again, so the fetcher doesn't block while jobs wait*/
+	if err := s.redis.client.Del(ctx, notifyKey(q.name)).Err(); err != nil {
+		return "", "", err
+	}
+
+	tenants, err := s.queueTenants(ctx, q.name)
+	if err != nil {
+		return "", "", err
+	}
+	start, found := slices.BinarySearch(tenants, q.lastTenant)
+	if found {
+		start++
+	}
+
+	for i := range tenants {
+		tenant := tenants[(start+i)%len(tenants)]
+		item, err := s.popTenant(ctx, q, tenant)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		q.lastTenant = tenant
+		return item, tenant, nil
+	}
+	return "", "", redis.Nil
+}
+
+/*
+Pops the tenant's next job from the queue if its quotas allow it, redis.Nil otherwise
+*/
+func (s *Scheduler) popTenant(ctx context.Context, q *queue, tenant string) (string, error) {
+	keys := []string{processingKey(tenant, q.name, s.instance), runningKey(tenant), rateKey(tenant)}
+	for _, priority := range q.tenantFairness(tenant).next() {
+		keys = append(keys, readyKey(tenant, q.name, priority))
+	}
+
+	now := time.Now()
+	quota := s.quota(tenant)
+	return popScript.Run(ctx, s.redis.client, keys,
+		now.UnixMilli(),
+		now.Add(jobs.LeaseDuration).UnixMilli(),
+		quota.MaxRunning,
+		quota.RateLimit,
+	).Text()
+}
+
+/*
+Pushes job into the ready queue of its tenant, queue and priority
+and wakes up the queue's fetcher
+*/
+func (s *Scheduler) PushReadyQueue(ctx context.Context, job *jobs.RedisJob) (err error) {
+	ctx, span, job := startPush(ctx, job, "ready")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, readyKey(job.Tenant, job.Queue, job.Priority), data)
+		pipe.SAdd(ctx, queuedKey(job.JobID), data)
+		addTenant(ctx, pipe, job)
+		pipe.LPush(ctx, notifyKey(job.Queue), 1)
+		pipe.LTrim(ctx, notifyKey(job.Queue), 0, 0)
+		return nil
+	})
+	return err
+}
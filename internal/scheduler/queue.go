@@ -2,12 +2,42 @@ package scheduler
 
 import (
 	"context"
+	"time"
 
+	"github.com/blueberry-adii/tickr/internal/database"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 )
 
 type Queue interface {
 	SaveJob(ctx context.Context, job jobs.Job) (int64, error)
+	GetJob(ctx context.Context, jobID int64) (*jobs.Job, error)
+	ListJobs(ctx context.Context, filter database.JobFilter) ([]jobs.Job, error)
 	PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) error
 	PushReadyQueue(ctx context.Context, job *jobs.RedisJob) error
+	CancelJob(ctx context.Context, jobID int64) error
+	GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error)
+	ReplayJob(ctx context.Context, jobID int64) error
+	GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error)
+	HasQueue(name string) bool
+	GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error)
+	ReleaseIdempotencyKey(ctx context.Context, jobID int64) error
+	GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error)
+	Events(ctx context.Context) <-chan jobs.JobEvent
+
+	SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error)
+	GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error)
+	PushBatch(ctx context.Context, batch []*jobs.RedisJob, now time.Time) error
+
+	SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error)
+	GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error)
+	ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error)
+	DeleteSchedule(ctx context.Context, scheduleID int64) error
+
+	SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error)
+	ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID int64, tenant string) error
+
+	SaveTenant(ctx context.Context, tenant jobs.Tenant) error
+	ListTenants(ctx context.Context) ([]jobs.Tenant, error)
 }
@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+A named queue served by this scheduler: its own Redis keys,
+its own fetcher and its own channel feeding the queue's worker pool.
+The fetcher takes turns between the tenants, lastTenant is the one served last,
+and keeps the priority fairness of every tenant apart
+*/
+type queue struct {
+	name       string
+	jobCh      chan *jobs.RedisJob
+	fairness   map[string]*fairness
+	lastTenant string
+}
+
+func newQueue(name string) *queue {
+	return &queue{
+		name:     name,
+		jobCh:    make(chan *jobs.RedisJob),
+		fairness: make(map[string]*fairness),
+	}
+}
+
+/*
+Priority fairness of the tenant's ready queues, only used by the queue's fetcher
+*/
+func (q *queue) tenantFairness(tenant string) *fairness {
+	f, ok := q.fairness[tenant]
+	if !ok {
+		f = newFairness()
+		q.fairness[tenant] = f
+	}
+	return f
+}
+
+/*
+Queue of a job, jobs stored before queues existed belong to the default queue
+*/
+func queueName(name string) string {
+	if name == "" {
+		return jobs.DefaultQueue
+	}
+	return name
+}
+
+/*
+Prefix of the keys shared by every tenant of the queue
+*/
+func queuePrefix(queue string) string {
+	return "tickr:queue:" + queueName(queue) + ":"
+}
+
+/*
+Prefix of the keys of the tenant's jobs in the queue. The default tenant keeps
+the keys used before tenants existed, so jobs queued by then aren't stranded
+*/
+func tenantPrefix(tenant string, queue string) string {
+	tenant = jobs.TenantName(tenant)
+	if tenant == jobs.DefaultTenant {
+		return queuePrefix(queue)
+	}
+	return "tickr:tenant:" + tenant + ":queue:" + queueName(queue) + ":"
+}
+
+/*
+Delayed jobs of the tenant in the queue, scored by the time they are due
+*/
+func waitingKey(tenant string, queue string) string {
+	return tenantPrefix(tenant, queue) + "waiting"
+}
+
+/*
+Ready queue of the tenant in the queue for a priority, jobs without one wait in the normal queue
+*/
+func readyKey(tenant string, queue string, priority enums.Priority) string {
+	if !priority.IsValid() {
+		priority = enums.Normal
+	}
+	return tenantPrefix(tenant, queue) + "ready:" + string(priority)
+}
+
+/*
+Ready queues of the tenant in the queue of every priority, highest first
+*/
+func readyKeys(tenant string, queue string) []string {
+	keys := make([]string, len(enums.Priorities))
+	for i, priority := range enums.Priorities {
+		keys[i] = readyKey(tenant, queue, priority)
+	}
+	return keys
+}
+
+/*
+Set of the tenants which queued jobs in the queue, besides the default tenant
+*/
+func tenantsKey(queue string) string {
+	return queuePrefix(queue) + "tenants"
+}
+
+/*
+Jobs of the tenant popped for execution on any queue, scored by the time in milliseconds
+their slot expires unless the worker keeps renewing it. Counts against the tenant's MaxRunning
+*/
+func runningKey(tenant string) string {
+	return "tickr:tenant:" + jobs.TenantName(tenant) + ":running"
+}
+
+/*
+Jobs of the tenant popped in the current second, counts against the tenant's RateLimit
+*/
+func rateKey(tenant string) string {
+	return "tickr:tenant:" + jobs.TenantName(tenant) + ":rate"
+}
+
+/*
+Holds a token while any ready queue may have jobs, the fetcher blocks on it
+when every ready queue is empty since a blocking pop only watches a single list
+*/
+func notifyKey(queue string) string {
+	return queuePrefix(queue) + "ready:notify"
+}
+
+/*
+Queue entries pushed for the job which may still sit in a waiting or ready queue,
+so cancelling the job removes them without scanning the queues. Dropped once the job is final
+*/
+func queuedKey(jobID int64) string {
+	return "tickr:job:" + strconv.FormatInt(jobID, 10) + ":queued"
+}
+
+/*
+Jobs popped from the tenant's ready queues are moved onto the processing list of the
+instance which popped them, and stay there till a worker claims them in MySQL.
+If the instance dies in between, another instance moves them back to the ready queue
+*/
+func processingKey(tenant string, queue string, instance string) string {
+	return tenantPrefix(tenant, queue) + "processing:" + instance
+}
+
+/*
+Set of every tickr instance which serves the queue and owns a processing list
+*/
+func instancesKey(queue string) string {
+	return queuePrefix(queue) + "instances"
+}
+
+/*
+Assigned when the queue is built, if it is missing Redis lost the queue's state
+and the queue is rebuilt from MySQL
+*/
+func epochKey(queue string) string {
+	return queuePrefix(queue) + "epoch"
+}
+
+/*
+Reports whether this scheduler serves the named queue
+*/
+func (s *Scheduler) HasQueue(name string) bool {
+	_, ok := s.queues[name]
+	return ok
+}
+
+/*
+Names of the queues this scheduler serves
+*/
+func (s *Scheduler) Queues() []string {
+	return s.queueNames
+}
+
+/*
+Channel the queue's worker pool receives jobs from,
+nil if this scheduler doesn't serve the queue
+*/
+func (s *Scheduler) Jobs(name string) <-chan *jobs.RedisJob {
+	q, ok := s.queues[name]
+	if !ok {
+		return nil
+	}
+	return q.jobCh
+}
+
+/*
+Tenants with jobs in the named queue, sorted by name, the default tenant always among them
+*/
+func (s *Scheduler) queueTenants(ctx context.Context, name string) ([]string, error) {
+	tenants, err := s.redis.client.SMembers(ctx, tenantsKey(name)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(tenants, jobs.DefaultTenant) {
+		tenants = append(tenants, jobs.DefaultTenant)
+	}
+	slices.Sort(tenants)
+	return tenants, nil
+}
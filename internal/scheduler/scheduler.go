@@ -3,48 +3,118 @@ package scheduler
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/metrics"
+	"github.com/blueberry-adii/tickr/internal/tracing"
 	"github.com/go-redis/redis/v8"
 )
 
 type Scheduler struct {
 	recovering int32
+	instance   string
+	queues     map[string]*queue
+	queueNames []string
 	Repository database.Repository
 	redis      *Redis
-	JobCh      chan *jobs.RedisJob
 	wqCh       chan int
+	scCh       chan int
+
+	/*only touched by the Run loop*/
+	scheduleRetryAt time.Time
+
+	runningMu sync.Mutex
+	running   map[int64]context.CancelCauseFunc
+	slots     map[int64]string
+
+	tenantsMu sync.RWMutex
+	quotas    map[string]jobs.Tenant
+
+	eventsMu     sync.Mutex
+	subscribers  map[chan jobs.JobEvent]bool
+	eventsClosed bool
 }
 
-func NewScheduler(r *Redis, repo database.Repository) *Scheduler {
+/*
+Returns a scheduler serving the named queues,
+only the default queue when no names are given
+*/
+func NewScheduler(r *Redis, repo database.Repository, queueNames ...string) *Scheduler {
+	if len(queueNames) == 0 {
+		queueNames = []string{jobs.DefaultQueue}
+	}
+
+	queues := make(map[string]*queue, len(queueNames))
+	for _, name := range queueNames {
+		queues[name] = newQueue(name)
+	}
+
 	return &Scheduler{
+		instance:   newInstanceID(),
+		queues:     queues,
+		queueNames: queueNames,
 		Repository: repo,
 		redis:      r,
-		JobCh:      make(chan *jobs.RedisJob),
 		wqCh:       make(chan int),
+		scCh:       make(chan int),
+		running:    make(map[int64]context.CancelCauseFunc),
+		slots:      make(map[int64]string),
+		quotas:     make(map[string]jobs.Tenant),
+
+		subscribers: make(map[chan jobs.JobEvent]bool),
 	}
 }
 
 /*
 Run is a scheduler method which runs an infinite loop and serves many purposes:
-Checks whether Redis lost data/state, if true, runs recovery to refill Redis queues and Calculate the time when
-the job with least delay needs to be moved from waiting queue to ready queue, and Calculates the waiting time till nextExec
+Checks whether Redis lost data/state of any queue, if true, runs recovery to refill that queue and Calculate the time when
+the job with least delay needs to be moved from waiting queue to ready queue, and Calculates the waiting time till nextExec.
+It also wakes up when the earliest recurring schedule is due and creates its job.
+Before handing out jobs it reaps the leases left behind by crashed workers and instances,
+and resolves blocked jobs and batches whose jobs finished while no instance was running
 */
 func (s *Scheduler) Run(ctx context.Context) {
 	if s.redisStateLost(ctx) {
-		log.Println("important: redis state missing, rebuilding from MySQL")
+		slog.WarnContext(ctx, "important: redis state missing, rebuilding from MySQL")
 		s.recoverFromMySQL(ctx)
 	}
-	defer close(s.JobCh)
-	defer close(s.wqCh)
-	go s.PopReadyQueue(ctx)
+	s.loadQuotas(ctx)
+	s.heartbeatInstance(ctx)
+	s.reapLeases(ctx)
+	s.resolveDependencies(ctx)
+	s.resolveBatches(ctx)
+
+	var popping sync.WaitGroup
+	for _, q := range s.queues {
+		popping.Add(1)
+		go func() {
+			defer popping.Done()
+			s.PopReadyQueue(ctx, q.name)
+		}()
+	}
+	/*stop popping before handing unclaimed jobs back and closing the job channels*/
+	defer func() {
+		popping.Wait()
+		s.releaseInstance(ctx)
+		for _, q := range s.queues {
+			close(q.jobCh)
+		}
+	}()
+
+	go s.watchCancellations(ctx)
+	go s.watchEvents(ctx)
+	go s.runLeases(ctx)
 	for {
-		log.Printf("scheduler idle")
+		slog.DebugContext(ctx, "scheduler idle")
 		nextExec, err := s.nextExecutionTime(ctx)
 
 		var timer <-chan time.Time
@@ -57,60 +127,76 @@ func (s *Scheduler) Run(ctx context.Context) {
 			timer = time.After(wait)
 		}
 
+		scheduleTimer := s.nextScheduleTimer(ctx)
+
 		select {
 		case <-ctx.Done():
-			log.Printf("killing scheduler")
+			slog.InfoContext(ctx, "killing scheduler")
 			return
 		case <-s.wqCh:
-			log.Printf("new job in waiting queue")
+			slog.DebugContext(ctx, "new job in waiting queue")
 			continue
+		case <-s.scCh:
+			slog.DebugContext(ctx, "new schedule created")
+			continue
+		case <-scheduleTimer:
+			s.fireDueSchedules(ctx)
 		case <-timer:
 			jobs, _ := s.PopWaitingQueue(ctx)
 			for _, job := range jobs {
-				log.Printf("moving job %v from waiting to ready queue", job.JobID)
-				s.PushReadyQueue(ctx, job)
+				logging.QueuedJob(job).InfoContext(ctx, "moved job from waiting to ready queue")
 			}
 		}
 	}
 }
 
 /*
-Calculates the time when the least delayed job in waiting queue needs to be
+Calculates the time when the least delayed job across the waiting queues of every tenant needs to be
 moved from waiting queue to ready queue
 */
 func (s *Scheduler) nextExecutionTime(ctx context.Context) (int64, error) {
-	res, err := s.redis.client.ZRangeWithScores(
-		ctx,
-		"tickr:queue:waiting",
-		0,
-		0,
-	).Result()
-
-	if err != nil || len(res) == 0 {
-		return 0, redis.Nil
-	}
+	var next int64
+	found := false
 
-	return int64(res[0].Score), nil
-}
-
-/*
-Pushes job into Ready queue
-*/
-func (s *Scheduler) PushReadyQueue(ctx context.Context, job *jobs.RedisJob) error {
-	data, err := json.Marshal(job)
-	if err != nil {
-		return err
+	for _, name := range s.queueNames {
+		tenants, err := s.queueTenants(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, tenant := range tenants {
+			res, err := s.redis.client.ZRangeWithScores(
+				ctx,
+				waitingKey(tenant, name),
+				0,
+				0,
+			).Result()
+
+			if err != nil || len(res) == 0 {
+				continue
+			}
+			if score := int64(res[0].Score); !found || score < next {
+				next = score
+				found = true
+			}
+		}
 	}
 
-	return s.redis.client.LPush(ctx, "tickr:queue:ready", data).Err()
+	if !found {
+		return 0, redis.Nil
+	}
+	return next, nil
 }
 
 /*
-Pops job from ready queue and put it into
-Scheduler's job channel.
+Pops job from the named queue's ready queues and put it into
+the queue's job channel.
+The tenants take turns within their quotas, the ready queue of the tenant is picked by weighted
+fair selection over the priorities, and the job is atomically moved onto this instance's
+processing list, so it isn't lost if the process crashes before a worker claims it.
 runs an infinite for loop, which stops when context is cancelled
 */
-func (s *Scheduler) PopReadyQueue(ctx context.Context) {
+func (s *Scheduler) PopReadyQueue(ctx context.Context, name string) {
+	q := s.queues[name]
 	for {
 		select {
 		case <-ctx.Done():
@@ -118,16 +204,21 @@ func (s *Scheduler) PopReadyQueue(ctx context.Context) {
 		default:
 		}
 
-		res, err := s.redis.client.BRPop(ctx, 0, "tickr:queue:ready").Result()
+		res, tenant, err := s.popReady(ctx, q)
 
 		if err == redis.Nil {
+			/*
+				every ready queue is empty or its tenant is at its limits, block till the next push or released slot,
+				at most a second so shutdown and the next second of the rate limits are noticed
+			*/
+			s.redis.client.BRPop(ctx, time.Second, notifyKey(q.name))
 			continue
 		}
 		if err != nil {
 			if ctx.Err() != nil {
 				return
 			}
-			log.Printf("error popping from ready queue: %v", err)
+			slog.ErrorContext(ctx, "error popping from ready queue", "queue", q.name, "error", err)
 
 			s.watchRedis(ctx)
 
@@ -140,13 +231,14 @@ func (s *Scheduler) PopReadyQueue(ctx context.Context) {
 		}
 
 		var job *jobs.RedisJob = new(jobs.RedisJob)
-		if err := json.Unmarshal([]byte(res[1]), job); err != nil {
-			log.Printf("error unmarshalling job: %v", err)
+		if err := json.Unmarshal([]byte(res), job); err != nil {
+			slog.ErrorContext(ctx, "error unmarshalling job", "queue", q.name, "error", err)
+			s.redis.client.LRem(ctx, processingKey(tenant, q.name, s.instance), 1, res)
 			continue
 		}
 
 		select {
-		case s.JobCh <- job:
+		case q.jobCh <- job:
 		case <-ctx.Done():
 			return
 		}
@@ -154,18 +246,29 @@ func (s *Scheduler) PopReadyQueue(ctx context.Context) {
 }
 
 /*
-Pushes a job in waiting queue, with duration the job stays in waiting queue
+Pushes a job in the waiting queue of its tenant and queue, with duration the job stays in waiting queue
 */
-func (s *Scheduler) PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) error {
+func (s *Scheduler) PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) (err error) {
+	ctx, span, job := startPush(ctx, job, "waiting")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	data, err := json.Marshal(job)
 	if err != nil {
 		return err
 	}
 
-	err = s.redis.client.ZAdd(ctx, "tickr:queue:waiting", &redis.Z{
-		Score:  float64(job.ScheduledAt.Unix()),
-		Member: data,
-	}).Err()
+	_, err = s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, waitingKey(job.Tenant, job.Queue), &redis.Z{
+			Score:  float64(job.ScheduledAt.Unix()),
+			Member: data,
+		})
+		pipe.SAdd(ctx, queuedKey(job.JobID), data)
+		addTenant(ctx, pipe, job)
+		return nil
+	})
 
 	if err == nil {
 		select {
@@ -178,47 +281,92 @@ func (s *Scheduler) PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) er
 }
 
 /*
-Fetches all the jobs from waiting queue which have exceeded their waiting time
-iterate over each job's json data and unmarshal it into Go struct and
-push it into readyJobs slice, and remove from Redis set (waiting queue)
+Most jobs moved by a single promotion, so the script never blocks Redis for long.
+Remaining due jobs are promoted right after, as the next job is already overdue
 */
-func (s *Scheduler) PopWaitingQueue(ctx context.Context) ([]*jobs.RedisJob, error) {
-	now := time.Now().Unix()
+const promoteBatch = 1000
 
-	res, err := s.redis.client.ZRangeByScore(ctx, "tickr:queue:waiting", &redis.ZRangeBy{
-		Min: "-inf",
-		Max: strconv.FormatInt(now, 10),
-	}).Result()
+/*
+Moves due members of the waiting queue (KEYS[1]) onto the ready queue of their priority (KEYS[3..])
+and sets the notify token (KEYS[2]).
+A member is only pushed if this call removed it from the waiting queue,
+so a job is promoted once even when several tickr instances share Redis
+*/
+var promoteScript = redis.NewScript(routeLua + `
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+local moved = {}
+for _, item in ipairs(due) do
+	if redis.call("ZREM", KEYS[1], item) == 1 then
+		redis.call("LPUSH", readyFor(item, 3, 3), item)
+		table.insert(moved, item)
+	end
+end
+if #moved > 0 then
+	redis.call("LPUSH", KEYS[2], 1)
+	redis.call("LTRIM", KEYS[2], 0, 0)
+end
+return moved
+`)
 
-	if err != nil || len(res) == 0 {
-		return nil, err
-	}
+/*
+Atomically moves all the jobs from the waiting queues of every tenant which have exceeded their waiting time
+to the ready queue of their priority, and returns the moved jobs
+*/
+func (s *Scheduler) PopWaitingQueue(ctx context.Context) ([]*jobs.RedisJob, error) {
+	now := time.Now().Unix()
 
 	var readyJobs []*jobs.RedisJob
 
-	for _, item := range res {
-		var job *jobs.RedisJob
-		if err := json.Unmarshal([]byte(item), &job); err != nil {
-			continue
+	for _, name := range s.queueNames {
+		tenants, err := s.queueTenants(ctx, name)
+		if err != nil {
+			return readyJobs, err
 		}
 
-		readyJobs = append(readyJobs, job)
+		for _, tenant := range tenants {
+			keys := append([]string{waitingKey(tenant, name), notifyKey(name)}, readyKeys(tenant, name)...)
+			args := append([]any{strconv.FormatInt(now, 10), promoteBatch}, priorityArgs()...)
+
+			res, err := promoteScript.Run(ctx, s.redis.client, keys, args...).StringSlice()
+			if err != nil {
+				return readyJobs, err
+			}
+
+			promoted := time.Now()
+			for _, item := range res {
+				var job *jobs.RedisJob
+				if err := json.Unmarshal([]byte(item), &job); err != nil {
+					continue
+				}
 
-		s.redis.client.ZRem(ctx, "tickr:queue:waiting", item)
+				metrics.PromotionLag.WithLabelValues(name).Observe(promoted.Sub(job.ScheduledAt).Seconds())
+				tracePromotion(ctx, job, promoted)
+				readyJobs = append(readyJobs, job)
+			}
+		}
 	}
 
 	return readyJobs, nil
 }
 
 /*
-checks whether redis lost state/data after crash
+checks whether redis lost state/data of any queue after crash
 
-tickr:redis:epoch is a key which is assigned to redis in the start of the program,
-if it is missing -> redis state lost,
+tickr:queue:{name}:epoch is a key which is assigned to redis when the queue is built,
+if it is missing -> the queue's redis state lost,
 return true otherwise false
 */
 func (s *Scheduler) redisStateLost(ctx context.Context) bool {
-	exists, err := s.redis.client.Exists(ctx, "tickr:redis:epoch").Result()
+	for _, name := range s.queueNames {
+		if s.queueStateLost(ctx, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scheduler) queueStateLost(ctx context.Context, name string) bool {
+	exists, err := s.redis.client.Exists(ctx, epochKey(name)).Result()
 	if err != nil {
 		return false
 	}
@@ -239,23 +387,33 @@ func (s *Scheduler) triggerRecovery() {
 }
 
 /*
-fetches all pending jobs from mysql and
-pushes them back onto waiting queue
+for every queue which lost its redis state, fetches the queue's pending jobs
+from mysql and pushes them back onto its waiting queue.
+Blocked jobs aren't kept in redis, but the ones whose dependencies finished
+in the meantime are released afterwards
 */
 func (s *Scheduler) recoverFromMySQL(ctx context.Context) {
-	log.Println("redis state lost, rebuilding queues")
+	defer s.resolveDependencies(ctx)
 
-	jobs, err := s.Repository.GetPendingJobs(ctx)
-	if err != nil {
-		log.Printf("recovery failed: %v", err)
-		return
-	}
+	for _, name := range s.queueNames {
+		if !s.queueStateLost(ctx, name) {
+			continue
+		}
+		slog.WarnContext(ctx, "redis state of queue lost, rebuilding queue", "queue", name)
+		metrics.RecoveryRuns.WithLabelValues(name).Inc()
 
-	for _, job := range jobs {
-		s.PushWaitingQueue(ctx, &job)
-	}
+		jobs, err := s.Repository.GetPendingJobs(ctx, name)
+		if err != nil {
+			slog.ErrorContext(ctx, "recovery of queue failed", "queue", name, "error", err)
+			continue
+		}
 
-	s.redis.client.Set(ctx, "tickr:redis:epoch", time.Now().Unix(), 0)
+		for _, job := range jobs {
+			s.PushWaitingQueue(ctx, &job)
+		}
+
+		s.redis.client.Set(ctx, epochKey(name), time.Now().Unix(), 0)
+	}
 }
 
 /*
@@ -273,23 +431,91 @@ func (s *Scheduler) watchRedis(ctx context.Context) {
 			}
 			break
 		}
-		log.Printf("err: redis connection inactive!!")
+		slog.ErrorContext(ctx, "err: redis connection inactive!!")
 		time.Sleep(time.Second)
 	}
 }
 
-func (s *Scheduler) Jobs() <-chan *jobs.RedisJob {
-	return s.JobCh
-}
-
 func (s *Scheduler) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
 	return s.Repository.GetJob(ctx, jobID)
 }
 
+func (s *Scheduler) ListJobs(ctx context.Context, filter database.JobFilter) ([]jobs.Job, error) {
+	return s.Repository.ListJobs(ctx, filter)
+}
+
+/*
+Saves a new job along with the trace context and ID of the request it was submitted in and publishes its status,
+which is blocked for a job whose dependencies haven't completed yet
+*/
 func (s *Scheduler) SaveJob(ctx context.Context, job jobs.Job) (int64, error) {
-	return s.Repository.SaveJob(ctx, job)
+	if job.TraceParent == nil {
+		job.TraceParent = traceParent(ctx)
+	}
+	if job.RequestID == nil {
+		job.RequestID = requestID(ctx)
+	}
+	jobID, err := s.Repository.SaveJob(ctx, job)
+	if err != nil {
+		return 0, err
+	}
+
+	metrics.JobsSubmitted.WithLabelValues(job.JobType, queueName(job.Queue)).Inc()
+	job.ID = jobID
+	if len(job.DependsOn) > 0 {
+		if saved, err := s.Repository.GetJob(ctx, jobID); err == nil {
+			job = *saved
+		}
+	}
+	s.publishJob(ctx, &job)
+
+	return jobID, nil
 }
 
+/*
+Returns the ID of the request in ctx to be saved with a job, nil outside of a request
+*/
+func requestID(ctx context.Context) *string {
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		return &requestID
+	}
+	return nil
+}
+
+/*
+Saves the outcome of a job's execution and publishes its status.
+A job which stopped executing frees its slot in its tenant's quota.
+If the worker lost the job meanwhile, database.ErrLeaseLost is returned: a cancelled job
+still frees its slot, a reaped one may already hold a new slot for its next worker
+*/
 func (s *Scheduler) UpdateJob(ctx context.Context, job *jobs.Job) error {
-	return s.Repository.UpdateJob(ctx, job)
+	err := s.Repository.UpdateJob(ctx, job)
+	if errors.Is(err, database.ErrLeaseLost) {
+		if job.Status == enums.Cancelled {
+			s.finishSlot(ctx, job)
+		} else {
+			s.forgetSlot(job.ID)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if job.Status != enums.Executing {
+		s.finishSlot(ctx, job)
+	}
+	s.publishJob(ctx, job)
+	return nil
+}
+
+func (s *Scheduler) GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	return s.Repository.GetJobByIdempotencyKey(ctx, tenant, key)
+}
+
+func (s *Scheduler) ReleaseIdempotencyKey(ctx context.Context, jobID int64) error {
+	return s.Repository.ReleaseIdempotencyKey(ctx, jobID)
+}
+
+func (s *Scheduler) GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	return s.Repository.GetActiveJobByUniqueKey(ctx, tenant, key)
 }
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/cron"
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+)
+
+/*
+Calculates the first occurrence of the cron expression after the given time,
+evaluated in the given IANA timezone
+*/
+func NextRun(cronExpr string, timezone string, after time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(cronExpr)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return schedule.Next(after.In(loc))
+}
+
+/*
+Saves a schedule and wakes up the scheduler loop,
+so the new schedule's first occurrence is taken into account
+*/
+func (s *Scheduler) SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error) {
+	id, err := s.Repository.SaveSchedule(ctx, schedule)
+	if err == nil {
+		select {
+		case s.scCh <- 1:
+		default:
+		}
+	}
+
+	return id, err
+}
+
+func (s *Scheduler) GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error) {
+	return s.Repository.GetSchedule(ctx, scheduleID)
+}
+
+func (s *Scheduler) ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error) {
+	return s.Repository.ListSchedules(ctx, tenant)
+}
+
+func (s *Scheduler) DeleteSchedule(ctx context.Context, scheduleID int64) error {
+	return s.Repository.DeleteSchedule(ctx, scheduleID)
+}
+
+/*
+How long a schedule which failed to fire waits before it is tried again,
+and the scheduler before checking schedules again when MySQL can't be reached
+*/
+const scheduleRetryDelay = time.Minute
+
+/*
+Returns a channel which fires when the earliest schedule is due,
+nil if there are no schedules.
+If MySQL can't be reached, checks again after a minute
+*/
+func (s *Scheduler) nextScheduleTimer(ctx context.Context) <-chan time.Time {
+	next, err := s.Repository.NextScheduleTime(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch next schedule time", "error", err)
+		return time.After(scheduleRetryDelay)
+	}
+	if next == nil {
+		return nil
+	}
+
+	return time.After(max(time.Until(*next), time.Until(s.scheduleRetryAt), 0))
+}
+
+/*
+Creates a job for every schedule whose occurrence is due and pushes it onto the waiting queue.
+Occurrences missed while tickr was down are collapsed into a single job,
+the schedule then continues from its next occurrence after now.
+A schedule which fails to fire is deferred by the retry delay, so it doesn't hold back the others
+*/
+func (s *Scheduler) fireDueSchedules(ctx context.Context) {
+	now := time.Now()
+
+	due, err := s.Repository.GetDueSchedules(ctx, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fetch due schedules", "error", err)
+		s.scheduleRetryAt = now.Add(scheduleRetryDelay)
+		return
+	}
+
+	for _, schedule := range due {
+		next, err := NextRun(schedule.CronExpr, schedule.Timezone, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "schedule has no next occurrence", "schedule_id", schedule.ID, "error", err)
+			s.deferSchedule(ctx, schedule, now)
+			continue
+		}
+
+		job := jobs.Job{
+			Tenant:      schedule.Tenant,
+			JobType:     schedule.JobType,
+			Payload:     schedule.Payload,
+			Status:      enums.Pending,
+			Queue:       queueName(schedule.Queue),
+			Priority:    enums.Normal,
+			Attempt:     0,
+			MaxAttempts: jobs.DefaultMaxAttempts,
+			CreatedAt:   now,
+			ScheduledAt: schedule.NextRunAt,
+		}
+
+		jobID, err := s.Repository.FireSchedule(ctx, schedule, job, next)
+		if errors.Is(err, database.ErrScheduleAlreadyFired) {
+			slog.InfoContext(ctx, "schedule occurrence already fired", "schedule_id", schedule.ID, "occurrence", schedule.NextRunAt)
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to fire schedule", "schedule_id", schedule.ID, "error", err)
+			s.deferSchedule(ctx, schedule, now)
+			continue
+		}
+
+		job.ID = jobID
+		logging.Job(&job).InfoContext(ctx, "schedule created job", "schedule_id", schedule.ID)
+		s.publishJob(ctx, &job)
+		s.PushWaitingQueue(ctx, job.RedisJob(schedule.NextRunAt))
+	}
+}
+
+/*
+Moves the failed occurrence of the schedule a retry delay ahead, so it no longer counts as due.
+If that fails as well MySQL is likely unreachable, and every schedule waits out the retry delay
+*/
+func (s *Scheduler) deferSchedule(ctx context.Context, schedule jobs.Schedule, now time.Time) {
+	retryAt := now.Add(scheduleRetryDelay)
+	if err := s.Repository.DeferSchedule(ctx, schedule, retryAt); err != nil {
+		slog.ErrorContext(ctx, "failed to defer schedule", "schedule_id", schedule.ID, "error", err)
+		s.scheduleRetryAt = retryAt
+	}
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/go-redis/redis/v8"
+)
+
+/*
+Saves the quotas of the tenant, they apply on this instance right away
+and on the other instances once they reload the quotas
+*/
+func (s *Scheduler) SaveTenant(ctx context.Context, tenant jobs.Tenant) error {
+	if err := s.Repository.SaveTenant(ctx, tenant); err != nil {
+		return err
+	}
+
+	s.tenantsMu.Lock()
+	s.quotas[tenant.Name] = tenant
+	s.tenantsMu.Unlock()
+	return nil
+}
+
+func (s *Scheduler) ListTenants(ctx context.Context) ([]jobs.Tenant, error) {
+	return s.Repository.ListTenants(ctx)
+}
+
+/*
+Reloads the quotas of every tenant from MySQL, the last ones loaded are kept if that fails
+*/
+func (s *Scheduler) loadQuotas(ctx context.Context) {
+	tenants, err := s.Repository.ListTenants(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load tenant quotas", "error", err)
+		return
+	}
+
+	quotas := make(map[string]jobs.Tenant, len(tenants))
+	for _, tenant := range tenants {
+		quotas[tenant.Name] = tenant
+	}
+
+	s.tenantsMu.Lock()
+	s.quotas = quotas
+	s.tenantsMu.Unlock()
+}
+
+/*
+Quotas of the tenant, a tenant which wasn't given any is unlimited
+*/
+func (s *Scheduler) quota(tenant string) jobs.Tenant {
+	s.tenantsMu.RLock()
+	defer s.tenantsMu.RUnlock()
+	return s.quotas[jobs.TenantName(tenant)]
+}
+
+/*
+Adds the job's tenant to the tenants of its queue, so the queue's fetchers serve it
+*/
+func addTenant(ctx context.Context, pipe redis.Pipeliner, job *jobs.RedisJob) {
+	if tenant := jobs.TenantName(job.Tenant); tenant != jobs.DefaultTenant {
+		pipe.SAdd(ctx, tenantsKey(job.Queue), tenant)
+	}
+}
+
+/*
+Remembers the tenant of a job claimed by a worker of this instance,
+so renewing its lease renews its slot as well
+*/
+func (s *Scheduler) holdSlot(job *jobs.Job) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	s.slots[job.ID] = jobs.TenantName(job.Tenant)
+}
+
+/*
+Keeps the slot of an executing job for another lease duration
+*/
+func (s *Scheduler) renewSlot(ctx context.Context, jobID int64, until time.Time) {
+	s.runningMu.Lock()
+	tenant, ok := s.slots[jobID]
+	s.runningMu.Unlock()
+	if !ok {
+		return
+	}
+
+	err := s.redis.client.ZAddXX(ctx, runningKey(tenant), &redis.Z{
+		Score:  float64(until.UnixMilli()),
+		Member: strconv.FormatInt(jobID, 10),
+	}).Err()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to renew slot of job", "job_id", jobID, "tenant", tenant, "error", err)
+	}
+}
+
+/*
+Frees the job's slot in its tenant's quota and wakes up the fetcher of the job's queue,
+which may have skipped the tenant while it was at its limit.
+Fetchers of the tenant's other queues notice within a second
+*/
+func (s *Scheduler) releaseSlot(ctx context.Context, tenant string, queue string, jobID int64) {
+	s.runningMu.Lock()
+	delete(s.slots, jobID)
+	s.runningMu.Unlock()
+
+	_, err := s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, runningKey(tenant), strconv.FormatInt(jobID, 10))
+		pipe.LPush(ctx, notifyKey(queue), 1)
+		pipe.LTrim(ctx, notifyKey(queue), 0, 0)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release slot of job", "job_id", jobID, "tenant", tenant, "error", err)
+	}
+}
+
+/*
+Forgets the slot of a job this instance no longer executes, without freeing it
+*/
+func (s *Scheduler) forgetSlot(jobID int64) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	delete(s.slots, jobID)
+}
+
+/*
+Releases the slot of a job executed by this instance once it stopped executing
+*/
+func (s *Scheduler) finishSlot(ctx context.Context, job *jobs.Job) {
+	s.runningMu.Lock()
+	_, ok := s.slots[job.ID]
+	s.runningMu.Unlock()
+	if ok {
+		s.releaseSlot(ctx, job.Tenant, job.Queue, job.ID)
+	}
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Starts the span of pushing a job onto one of its queues. Without a span in ctx, e.g. when a retry
+or recovery pushes the job, the span continues the trace the entry carries.
+Returns the entry to store, which carries the push span so the job's execution continues from there
+*/
+func startPush(ctx context.Context, job *jobs.RedisJob, list string) (context.Context, trace.Span, *jobs.RedisJob) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.WithTraceParent(ctx, job.TraceParent)
+	}
+	ctx, span := tracing.Start(ctx, "queue.push",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			tracing.JobID.Int64(job.JobID),
+			tracing.Queue.String(queueName(job.Queue)),
+			tracing.Tenant.String(jobs.TenantName(job.Tenant)),
+			attribute.String("tickr.queue.list", list),
+		),
+	)
+
+	entry := *job
+	if traceParent := tracing.TraceParent(ctx); traceParent != "" {
+		entry.TraceParent = traceParent
+	}
+	return ctx, span, &entry
+}
+
+/*
+Returns the traceparent of the span in ctx to be saved with a job, nil if there is none
+*/
+func traceParent(ctx context.Context) *string {
+	if traceParent := tracing.TraceParent(ctx); traceParent != "" {
+		return &traceParent
+	}
+	return nil
+}
+
+/*
+Records the promotion of a job from the waiting to the ready queue,
+the span lasts from the time the job was due till its promotion
+*/
+func tracePromotion(ctx context.Context, job *jobs.RedisJob, promoted time.Time) {
+	_, span := tracing.Start(tracing.WithTraceParent(ctx, job.TraceParent), "queue.promote",
+		trace.WithTimestamp(job.ScheduledAt),
+		trace.WithAttributes(
+			tracing.JobID.Int64(job.JobID),
+			tracing.Queue.String(queueName(job.Queue)),
+			tracing.Tenant.String(jobs.TenantName(job.Tenant)),
+		),
+	)
+	span.End(trace.WithTimestamp(promoted))
+}
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+)
+
+/*
+Attempts a callback gets before it is given up on
+*/
+const callbackMaxAttempts = 6
+
+/*
+Backs off from 5 seconds up to 10 minutes between failed deliveries of a callback,
+receivers answering 4xx are retried as well
+*/
+var callbackRetryPolicy = jobs.RetryPolicy{
+	Strategy: enums.Exponential,
+	Base:     5,
+	Cap:      600,
+	Jitter:   0.2,
+	RetryOn:  []enums.ErrorClass{enums.TimeoutError, enums.NetworkError, enums.ClientError, enums.ServerError, enums.OtherError},
+}
+
+/*
+Body POSTed to a job's callback URL once it completed or failed
+*/
+type callbackEvent struct {
+	JobID      int64           `json:"jobID"`
+	JobType    string          `json:"jobtype"`
+	Status     enums.Status    `json:"status"`
+	Attempt    int             `json:"attempt"`
+	Result     json.RawMessage `json:"result"`
+	LastError  *string         `json:"lastError"`
+	FinishedAt *time.Time      `json:"finishedAt"`
+}
+
+/*
+Queues the delivery of the job's callback as a webhook job on the job's queue,
+so the worker finishing the job never waits on the receiver and a failed
+delivery is retried on its own. The webhook job signs the body with the job's secret.
+Its idempotency key makes sure a job gets a single callback
+*/
+func (s *Scheduler) queueCallback(ctx context.Context, job *jobs.Job) {
+	if job.CallbackURL == nil {
+		return
+	}
+
+	result := job.Result
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	body, err := json.Marshal(callbackEvent{
+		JobID:      job.ID,
+		JobType:    job.JobType,
+		Status:     job.Status,
+		Attempt:    job.Attempt,
+		Result:     result,
+		LastError:  job.LastError,
+		FinishedAt: job.FinishedAt,
+	})
+	if err != nil {
+		logging.Job(job).ErrorContext(ctx, "failed to encode callback of job", "error", err)
+		return
+	}
+	payload, err := json.Marshal(map[string]any{
+		"url":  *job.CallbackURL,
+		"body": json.RawMessage(body),
+	})
+	if err != nil {
+		logging.Job(job).ErrorContext(ctx, "failed to encode callback of job", "error", err)
+		return
+	}
+
+	now := time.Now()
+	policy := callbackRetryPolicy
+	key := jobs.ReservedKeyPrefix + "callback:" + strconv.FormatInt(job.ID, 10)
+	delivery := jobs.Job{
+		Tenant:         job.Tenant,
+		JobType:        "webhook",
+		Payload:        payload,
+		Status:         enums.Pending,
+		Queue:          job.Queue,
+		Priority:       enums.Normal,
+		MaxAttempts:    callbackMaxAttempts,
+		RetryPolicy:    &policy,
+		CreatedAt:      now,
+		ScheduledAt:    now,
+		IdempotencyKey: &key,
+		CallbackSecret: job.CallbackSecret,
+		TraceParent:    job.TraceParent,
+		RequestID:      job.RequestID,
+		APIKeyID:       job.APIKeyID,
+	}
+
+	delivery.ID, err = s.Repository.SaveJob(ctx, delivery)
+	/*the hook ran again for this job, e.g. when resolving dependencies on startup*/
+	if errors.Is(err, database.ErrIdempotencyKeyUsed) {
+		return
+	}
+	if err != nil {
+		logging.Job(job).ErrorContext(ctx, "failed to queue callback of job", "error", err)
+		return
+	}
+
+	logging.Job(job).InfoContext(ctx, "queued callback of job", "callback_job_id", delivery.ID)
+	s.publishJob(ctx, &delivery)
+	s.PushReadyQueue(ctx, delivery.RedisJob(now))
+}
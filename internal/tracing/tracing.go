@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Name of the instrumentation creating tickr's spans
+*/
+const instrumentation = "github.com/blueberry-adii/tickr"
+
+/*
+Attributes set on the spans of a job
+*/
+const (
+	JobID      = attribute.Key("tickr.job.id")
+	JobType    = attribute.Key("tickr.job.type")
+	JobAttempt = attribute.Key("tickr.job.attempt")
+	Queue      = attribute.Key("tickr.queue")
+	Tenant     = attribute.Key("tickr.tenant")
+)
+
+/*
+Sets up the global tracer provider exporting spans with the named exporter:
+"otlp" sends them over OTLP/HTTP, configured with the standard OTEL_EXPORTER_OTLP_* env vars,
+"stdout" or "console" prints them, "none" or "" turns tracing off.
+The trace context is propagated in any case. The returned function flushes the spans left on shutdown
+*/
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "stdout", "console":
+		spanExporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected otlp, stdout or none", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	/*OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults*/
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "tickr")),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+/*
+Starts a span with tickr's tracer from the global provider
+*/
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+/*
+Returns the W3C traceparent of the span in ctx, "" if there is none
+*/
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+/*
+Returns ctx carrying the remote span of a W3C traceparent, ctx itself if traceParent is empty or invalid
+*/
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+/*
+Sets the traceparent header of an outgoing request to the span in ctx
+*/
+func InjectHeaders(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+/*
+Marks the span as failed with err, if there is one
+*/
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -2,48 +2,91 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
+/*
+Reported in the job's LastError when it runs longer than its timeout
+*/
+var ErrJobTimeout = errors.New("timeout")
+
+/*
+Used by http jobs which don't set a timeout of their own
+*/
+const defaultHttpTimeout = 10 * time.Second
+
 type Executor struct {
+	registry *Registry
 }
 
-func NewExecutor() *Executor {
-	return &Executor{}
+func NewExecutor(registry *Registry) *Executor {
+	return &Executor{
+		registry: registry,
+	}
 }
 
 /*
-runs switch case conditions
-to decide which job handler to run based on job type
+looks up the handler registered for the job type
+and runs it, limited to the job's timeout if it has one.
+The handler call is traced as a child of the span in ctx
 */
-func (e *Executor) ExecuteJob(job *jobs.Job) error {
-	switch job.JobType {
-	case "email":
-		return e.handleEmail(job)
-	case "report":
-		return e.handleReport(job)
-	case "http":
-		return e.sendHttpRequest(job)
-	default:
+func (e *Executor) ExecuteJob(ctx context.Context, job *jobs.Job) (err error) {
+	ctx, span := tracing.Start(ctx, "job.handle", trace.WithAttributes(
+		tracing.JobID.Int64(job.ID),
+		tracing.JobType.String(job.JobType),
+	))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	handler, ok := e.registry.Handler(job.JobType)
+	if !ok {
 		job.Result = []byte(`unrecognized job`)
 		return errors.New("unrecognized job")
 	}
+
+	if job.Timeout <= 0 {
+		return handler.Handle(ctx, job)
+	}
+
+	limit := time.Duration(job.Timeout) * time.Second
+	ctx, cancel := context.WithTimeoutCause(ctx, limit, ErrJobTimeout)
+	defer cancel()
+
+	err = handler.Handle(ctx, job)
+	if err != nil && errors.Is(context.Cause(ctx), ErrJobTimeout) {
+		return fmt.Errorf("%w: job exceeded its %v limit", ErrJobTimeout, limit)
+	}
+
+	return err
 }
 
 /*
 Sends an http request
 */
-func (e *Executor) sendHttpRequest(job *jobs.Job) error {
-	client := &http.Client{
-		Timeout: time.Second * 10,
+func sendHttpRequest(ctx context.Context, job *jobs.Job) error {
+	client := &http.Client{}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultHttpTimeout)
+		defer cancel()
 	}
 
 	var request struct {
@@ -67,11 +110,15 @@ func (e *Executor) sendHttpRequest(job *jobs.Job) error {
 
 	if err := json.Unmarshal([]byte(job.Payload), &request); err != nil {
 		Obj.Data = "error: invalid http request"
-		log.Printf("invalid http request format: %v", err)
+		logging.Job(job).ErrorContext(ctx, "invalid http request format", "error", err)
 		return err
 	}
 
-	req, _ := http.NewRequest(request.Method, request.Url, bytes.NewBuffer(request.Body))
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.Url, bytes.NewBuffer(request.Body))
+	if err != nil {
+		Obj.Data = "error: invalid http request"
+		return err
+	}
 
 	if len(request.Headers) > 0 {
 		var headerMap map[string]string
@@ -85,17 +132,19 @@ func (e *Executor) sendHttpRequest(job *jobs.Job) error {
 			req.Header.Set(key, value)
 		}
 	}
+	/*lets the target continue the job's trace*/
+	tracing.InjectHeaders(ctx, req.Header)
 
 	resp, err := client.Do(req)
 	if err != nil {
 		Obj.Data = err.Error()
-		log.Printf("err: %v", err.Error())
+		logging.Job(job).ErrorContext(ctx, "http request failed", "error", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		err := fmt.Errorf("request failed with status %d", resp.StatusCode)
+		err := &HTTPStatusError{StatusCode: resp.StatusCode}
 		Obj.Data = err.Error()
 		return err
 	}
@@ -103,7 +152,7 @@ func (e *Executor) sendHttpRequest(job *jobs.Job) error {
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		Obj.Data = err.Error()
-		log.Printf("err: %v", err.Error())
+		logging.Job(job).ErrorContext(ctx, "failed to read http response", "error", err)
 		return err
 	}
 
@@ -112,10 +161,68 @@ func (e *Executor) sendHttpRequest(job *jobs.Job) error {
 	return nil
 }
 
+/*
+Header carrying the HMAC-SHA256 of a webhook's timestamp and body, hex encoded and prefixed with "sha256="
+*/
+const SignatureHeader = "X-Tickr-Signature"
+
+/*
+Header carrying the Unix time in seconds a webhook was sent at, part of the signed content
+so receivers can reject old deliveries replayed to them
+*/
+const TimestampHeader = "X-Tickr-Timestamp"
+
+/*
+POSTs the body of the payload to its url as JSON.
+The body is signed with the job's callback secret if it has one, the signature
+is computed over the timestamp, a dot and the exact bytes sent
+*/
+func deliverWebhook(ctx context.Context, job *jobs.Job) error {
+	var webhook struct {
+		Url  string          `json:"url"`
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(job.Payload, &webhook); err != nil {
+		job.Result = []byte(`{"data":"error: invalid webhook"}`)
+		return Permanent(err)
+	}
+
+	var body bytes.Buffer
+	if err := json.Compact(&body, webhook.Body); err != nil {
+		job.Result = []byte(`{"data":"error: invalid webhook body"}`)
+		return Permanent(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{"Content-Type": "application/json", TimestampHeader: timestamp}
+	if job.CallbackSecret != nil {
+		mac := hmac.New(sha256.New, []byte(*job.CallbackSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body.Bytes())
+		headers[SignatureHeader] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	request := *job
+	payload, err := json.Marshal(map[string]any{
+		"url":     webhook.Url,
+		"method":  http.MethodPost,
+		"headers": headers,
+		"body":    json.RawMessage(body.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+	request.Payload = payload
+
+	err = sendHttpRequest(ctx, &request)
+	job.Result = request.Result
+	return err
+}
+
 /*
 simulates email sending
 */
-func (e *Executor) handleEmail(job *jobs.Job) error {
+func handleEmail(ctx context.Context, job *jobs.Job) error {
 	var email struct {
 		To   string `json:"to"`
 		From string `json:"from"`
@@ -133,13 +240,13 @@ func (e *Executor) handleEmail(job *jobs.Job) error {
 
 	if err := json.Unmarshal([]byte(job.Payload), &email); err != nil {
 		Obj.Data = "error: invalid email"
-		log.Printf("invalid email format: %v", err)
+		logging.Job(job).ErrorContext(ctx, "invalid email format", "error", err)
 		return err
 	}
 
-	log.Printf("sending email from %s to %s", email.From, email.To)
+	logging.Job(job).InfoContext(ctx, "sending email", "from", email.From, "to", email.To)
 	// time.Sleep(time.Second * 5)
-	log.Printf("sent email: %s", email.Body)
+	logging.Job(job).InfoContext(ctx, "sent email", "body", email.Body)
 	Obj.Data = fmt.Sprintf("sent Email to %v successfully", email.To)
 
 	return nil
@@ -148,7 +255,7 @@ func (e *Executor) handleEmail(job *jobs.Job) error {
 /*
 simulates report handling
 */
-func (e *Executor) handleReport(job *jobs.Job) error {
+func handleReport(ctx context.Context, job *jobs.Job) error {
 	var report struct {
 		Title string `json:"title"`
 		Body  string `json:"body"`
@@ -165,14 +272,19 @@ func (e *Executor) handleReport(job *jobs.Job) error {
 	}()
 
 	if err := json.Unmarshal([]byte(job.Payload), &report); err != nil {
-		log.Printf("invalid report format: %v", err)
+		logging.Job(job).ErrorContext(ctx, "invalid report format", "error", err)
 		Obj.Data = "error: invalid report format"
 		return err
 	}
 
-	log.Printf("scheduled report for %d seconds", report.Time)
-	time.Sleep(time.Second * time.Duration(report.Time))
-	log.Printf("Title: %s | Body: %s", report.Title, report.Body)
+	logging.Job(job).InfoContext(ctx, "scheduled report", "seconds", report.Time)
+	select {
+	case <-time.After(time.Second * time.Duration(report.Time)):
+	case <-ctx.Done():
+		Obj.Data = "error: report interrupted"
+		return ctx.Err()
+	}
+	logging.Job(job).InfoContext(ctx, "published report", "title", report.Title, "body", report.Body)
 
 	Obj.Data = "report successful"
 	return nil
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Handler executes jobs of a single job type.
+It reads the job's payload, does the work and sets job.Result,
+a returned error fails the attempt and the job is retried.
+Handlers must return promptly once ctx is done, which happens on timeout,
+cancellation of the job or server shutdown
+*/
+type Handler interface {
+	Handle(ctx context.Context, job *jobs.Job) error
+}
+
+/*
+HandlerFunc lets an ordinary function be used as a Handler
+*/
+type HandlerFunc func(ctx context.Context, job *jobs.Job) error
+
+func (f HandlerFunc) Handle(ctx context.Context, job *jobs.Job) error {
+	return f(ctx, job)
+}
+
+/*
+Registry maps job types to their handlers.
+It is shared by the executor, which runs the handlers,
+and the API, which rejects job types that have no handler or are internal
+*/
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	internal map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]Handler),
+		internal: make(map[string]bool),
+	}
+}
+
+/*
+Returns a registry with the built-in email, report and http handlers,
+and the internal webhook handler delivering job callbacks
+*/
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register("email", HandlerFunc(handleEmail))
+	r.Register("report", HandlerFunc(handleReport))
+	r.Register("http", HandlerFunc(sendHttpRequest))
+	r.RegisterInternal("webhook", HandlerFunc(deliverWebhook))
+	return r
+}
+
+/*
+Registers the handler for a job type,
+replacing any handler already registered for it
+*/
+func (r *Registry) Register(jobType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[jobType] = h
+	delete(r.internal, jobType)
+}
+
+/*
+Registers the handler for a job type only tickr itself creates,
+the workers run it but clients can't submit, schedule or be granted it
+*/
+func (r *Registry) RegisterInternal(jobType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[jobType] = h
+	r.internal[jobType] = true
+}
+
+/*
+Returns the handler registered for the job type
+*/
+func (r *Registry) Handler(jobType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[jobType]
+	return h, ok
+}
+
+/*
+Reports whether a handler is registered for the job type and clients may submit it
+*/
+func (r *Registry) Has(jobType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.handlers[jobType]
+	return ok && !r.internal[jobType]
+}
+
+/*
+Returns the job types clients may submit in sorted order
+*/
+func (r *Registry) JobTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.handlers))
+	for jobType := range r.handlers {
+		if r.internal[jobType] {
+			continue
+		}
+		types = append(types, jobType)
+	}
+	sort.Strings(types)
+	return types
+}
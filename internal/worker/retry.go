@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+)
+
+/*
+Returned by the http handler when the target responds with a 4xx or 5xx status
+*/
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("request failed with status %d", e.StatusCode)
+}
+
+/*
+permanentError marks an error which must not be retried
+*/
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+/*
+Wraps err so the job fails right away instead of being retried,
+whatever its retry policy says. Meant for handlers which know the job can never succeed
+*/
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+/*
+Classifies the error a failed attempt ended with
+*/
+func Classify(err error) enums.ErrorClass {
+	var statusErr *HTTPStatusError
+	var urlErr *url.Error
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrJobTimeout):
+		return enums.TimeoutError
+	case errors.As(err, &statusErr) && statusErr.StatusCode >= 500:
+		return enums.ServerError
+	case errors.As(err, &statusErr) && statusErr.StatusCode >= 400:
+		return enums.ClientError
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return enums.NetworkError
+	default:
+		return enums.OtherError
+	}
+}
+
+/*
+Reports whether a failed attempt is worth retrying under the policy,
+without retryOn every error but a 4xx response is. Attempt limits are checked by the worker
+*/
+func ShouldRetry(policy jobs.RetryPolicy, err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if len(policy.RetryOn) == 0 {
+		return Classify(err) != enums.ClientError
+	}
+	return slices.Contains(policy.RetryOn, Classify(err))
+}
+
+/*
+Calculates how long to wait before retrying after the given (1 based) attempt failed,
+at most jobs.MaxRetryDelay
+*/
+func RetryDelay(policy jobs.RetryPolicy, attempt int) time.Duration {
+	maxSeconds := int(jobs.MaxRetryDelay / time.Second)
+	base := time.Duration(min(max(policy.Base, 0), maxSeconds)) * time.Second
+
+	var factor time.Duration
+	switch policy.Strategy {
+	case enums.Fixed:
+		factor = 1
+	case enums.Exponential:
+		factor = time.Duration(math.Pow(2, float64(min(attempt-1, 30))))
+	default:
+		factor = time.Duration(attempt)
+	}
+
+	/*a multiplication which overflowed would retry right away*/
+	delay := base * factor
+	if delay < 0 || (base > 0 && delay/base != factor) || delay > jobs.MaxRetryDelay {
+		delay = jobs.MaxRetryDelay
+	}
+
+	if policy.Cap > 0 {
+		delay = min(delay, time.Duration(policy.Cap)*time.Second)
+	}
+
+	if policy.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+	}
+
+	return delay
+}
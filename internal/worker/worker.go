@@ -2,34 +2,58 @@ package worker
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"time"
 
+	"github.com/blueberry-adii/tickr/internal/database"
 	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/metrics"
+	"github.com/blueberry-adii/tickr/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
 Dispatcher is the interface the worker needs from the scheduler:
-a channel to receive jobs from, DB read/write access, and retry queuing.
+a channel to receive jobs from, claiming jobs under a lease and renewing it,
+DB write access, error and attempt history, retry queuing
+a context which is cancelled when a running job is cancelled
+a hook resolving the jobs which depend on a finished job
+and one freeing the tenant slot of a job which stopped executing after its cancellation.
 Defined here so the worker package has no import dependency on scheduler.
 */
 type Dispatcher interface {
-	Jobs() <-chan *jobs.RedisJob
-	GetJob(ctx context.Context, jobID int64) (*jobs.Job, error)
+	Jobs(queue string) <-chan *jobs.RedisJob
+	ClaimJob(ctx context.Context, redisJob *jobs.RedisJob, workerID int) (*jobs.Job, error)
+	ExtendLease(ctx context.Context, jobID int64, leaseToken string) error
 	UpdateJob(ctx context.Context, job *jobs.Job) error
 	PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) error
+	SaveJobError(ctx context.Context, jobError jobs.JobError) error
+	SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error
+	WatchCancel(ctx context.Context, jobID int64) (context.Context, context.CancelFunc)
+	JobFinished(ctx context.Context, job *jobs.Job)
+	JobStopped(ctx context.Context, job *jobs.Job)
 }
 
 type Worker struct {
 	ID        int
+	Queue     string
 	Scheduler Dispatcher
+	executor  *Executor
 }
 
-func NewWorker(id int, s Dispatcher) *Worker {
+/*
+Returns a worker executing the jobs of the named queue
+*/
+func NewWorker(id int, queue string, s Dispatcher, registry *Registry) *Worker {
 	return &Worker{
 		ID:        id,
+		Queue:     queue,
 		Scheduler: s,
+		executor:  NewExecutor(registry),
 	}
 }
 
@@ -43,63 +67,225 @@ the block is executed and worker moves onto next iteration and blocks again
 till the next signal
 */
 func (w *Worker) Run(ctx context.Context) {
+	idle := metrics.Workers.WithLabelValues(w.Queue, "idle")
+	idle.Inc()
+	defer idle.Dec()
+
 	for {
-		log.Printf("worker %v idle", w.ID)
+		slog.DebugContext(ctx, "worker idle", "worker_id", w.ID, "queue", w.Queue)
 		select {
 		case <-ctx.Done():
-			log.Printf("worker %d shutting down", w.ID)
+			slog.InfoContext(ctx, "worker shutting down", "worker_id", w.ID, "queue", w.Queue)
 			return
 
-		case redisJob, ok := <-w.Scheduler.Jobs():
+		case redisJob, ok := <-w.Scheduler.Jobs(w.Queue):
 			if !ok {
-				log.Printf("worker %d shutting down", w.ID)
+				slog.InfoContext(ctx, "worker shutting down", "worker_id", w.ID, "queue", w.Queue)
 				return
 			}
-			log.Printf("worker %v took job %v", w.ID, redisJob.JobID)
+			logging.QueuedJob(redisJob).DebugContext(ctx, "worker took job", "worker_id", w.ID)
+			w.process(ctx, redisJob)
+		}
+	}
+}
 
-			job, err := w.Scheduler.GetJob(ctx, redisJob.JobID)
-			if err != nil {
-				log.Printf("failed to fetch job %d: %v", redisJob.JobID, err)
-				continue
-			}
+/*
+Claims, executes and persists the outcome of a single job popped from the queue.
+The execution is traced as part of the trace the job was submitted in
+*/
+func (w *Worker) process(ctx context.Context, redisJob *jobs.RedisJob) {
+	/*moves the job to executing under a lease held by this worker*/
+	job, err := w.Scheduler.ClaimJob(ctx, redisJob, w.ID)
+	if errors.Is(err, database.ErrJobNotClaimable) {
+		/*job may have been cancelled or already run after it was queued*/
+		logging.QueuedJob(redisJob).InfoContext(ctx, "skipping job which is no longer pending", "worker_id", w.ID)
+		return
+	}
+	if err != nil {
+		logging.QueuedJob(redisJob).ErrorContext(ctx, "failed to claim job", "worker_id", w.ID, "error", err)
+		return
+	}
+
+	/*the lines logged about the job carry the ID of the request which created it*/
+	if job.RequestID != nil {
+		ctx = logging.WithRequestID(ctx, *job.RequestID)
+	}
+	logging.Job(job).InfoContext(ctx, "executing job")
+
+	traceParent := redisJob.TraceParent
+	if traceParent == "" && job.TraceParent != nil {
+		traceParent = *job.TraceParent
+	}
+	spanCtx, span := tracing.Start(tracing.WithTraceParent(ctx, traceParent), "job.execute",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			tracing.JobID.Int64(job.ID),
+			tracing.JobType.String(job.JobType),
+			tracing.Queue.String(w.Queue),
+			tracing.Tenant.String(jobs.TenantName(job.Tenant)),
+			attribute.Int("tickr.worker.id", w.ID),
+		),
+	)
+	defer func() {
+		span.SetAttributes(tracing.JobAttempt.Int(job.Attempt), attribute.String("tickr.job.status", string(job.Status)))
+		span.End()
+	}()
+
+	/*
+		execCtx is cancelled when the server shuts down,
+		when the job is cancelled through the API
+		or when the worker loses the job's lease
+	*/
+	watchCtx, stopWatching := w.Scheduler.WatchCancel(spanCtx, job.ID)
+	execCtx, stopLease := context.WithCancelCause(watchCtx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		w.heartbeat(execCtx, job.ID, job.LeaseToken, stopLease)
+	}()
+
+	idle := metrics.Workers.WithLabelValues(w.Queue, "idle")
+	busy := metrics.Workers.WithLabelValues(w.Queue, "busy")
+	idle.Dec()
+	busy.Inc()
+	start := time.Now()
+	err = w.executor.ExecuteJob(execCtx, job)
+	metrics.JobExecutionDuration.WithLabelValues(job.JobType).Observe(time.Since(start).Seconds())
+	busy.Dec()
+	idle.Inc()
+	cause := context.Cause(execCtx)
+	stopLease(nil)
+	<-heartbeatDone
+	stopWatching()
+	/*not cancelled on shutdown, so the outcome is always persisted*/
+	jobCtx := trace.ContextWithSpan(context.WithoutCancel(ctx), span)
+	tracing.RecordError(span, err)
+
+	cancelled := errors.Is(cause, jobs.ErrCancelled)
+
+	/*the lease reaper already counted this attempt and handed the job on*/
+	if errors.Is(cause, database.ErrLeaseLost) {
+		logging.Job(job).WarnContext(jobCtx, "lease lost: dropping job")
+		countExecution(job, "lease_lost")
+		return
+	}
+
+	end := time.Now()
+	job.FinishedAt = &end
+
+	/*the job was already moved to cancelled status, only its attempt is left to record*/
+	if err != nil && cancelled {
+		logging.Job(job).InfoContext(jobCtx, "cancelled: job was cancelled while executing")
+		errMsg := jobs.ErrCancelled.Error()
+		job.Attempt = job.Attempt + 1
+		job.LastError = &errMsg
+		job.Status = enums.Cancelled
+		w.Scheduler.SaveJobError(jobCtx, jobs.JobError{
+			JobID:     job.ID,
+			Attempt:   job.Attempt,
+			Error:     errMsg,
+			CreatedAt: end,
+		})
+		w.Scheduler.SaveJobAttempt(jobCtx, job.FinishedAttempt(&w.ID, nil))
+		w.Scheduler.JobStopped(jobCtx, job)
+		countExecution(job, string(job.Status))
+		return
+	}
+
+	/*interrupted by shutdown, the attempt doesn't count and the job runs again after restart*/
+	if err != nil && ctx.Err() != nil {
+		logging.Job(job).InfoContext(jobCtx, "interrupted: job was interrupted by shutdown, sending back to waiting queue")
+		job.Status = enums.Pending
+		if job.Attempt > 0 {
+			job.Status = enums.Retrying
+		}
+		job.StartedAt = nil
+		job.FinishedAt = nil
+		if err := w.Scheduler.UpdateJob(jobCtx, job); errors.Is(err, database.ErrLeaseLost) {
+			countExecution(job, "lease_lost")
+			return
+		}
+		w.Scheduler.PushWaitingQueue(jobCtx, job.RedisJob(end))
+		countExecution(job, "interrupted")
+		return
+	}
 
-			now := time.Now()
-			job.StartedAt = &now
-			job.FinishedAt = nil
-
-			job.Status = enums.Executing
-			job.WorkerID = &w.ID
-
-			w.Scheduler.UpdateJob(ctx, job)
-
-			exec := NewExecutor()
-			err = exec.ExecuteJob(job)
-			jobCtx := context.Background()
-
-			end := time.Now()
-			job.FinishedAt = &end
-
-			job.Attempt = job.Attempt + 1
-			if err != nil {
-				log.Printf("error: %v", err.Error())
-				errMsg := err.Error()
-				job.LastError = &errMsg
-				if job.Attempt < job.MaxAttempts {
-					log.Printf("retry: attempt %d of job %d failed, sending back to waiting queue", job.Attempt, job.ID)
-					job.Status = enums.Retrying
-					w.Scheduler.UpdateJob(jobCtx, job)
-					delay := end.Add(time.Second * 10 * time.Duration(job.Attempt))
-					w.Scheduler.PushWaitingQueue(jobCtx, &jobs.RedisJob{JobID: job.ID, ScheduledAt: delay})
-				} else {
-					log.Printf("failed: attempt %d of job %d failed with max 3 attempts", job.Attempt, job.ID)
-					job.Status = enums.Failed
-					w.Scheduler.UpdateJob(jobCtx, job)
-				}
-			} else {
-				log.Printf("success: attempt %d of job %d was successful", job.Attempt, job.ID)
-				job.LastError = nil
-				job.Status = enums.Completed
-				w.Scheduler.UpdateJob(jobCtx, job)
+	job.Attempt = job.Attempt + 1
+	var retryAt *time.Time
+	if err != nil {
+		errMsg := err.Error()
+		job.LastError = &errMsg
+		policy := job.Retry()
+		if job.Attempt < job.MaxAttempts && ShouldRetry(policy, err) {
+			delay := end.Add(RetryDelay(policy, job.Attempt))
+			logging.Job(job).WarnContext(jobCtx, "retry: attempt failed, sending back to waiting queue", "error", err, "next_retry_at", delay)
+			job.Status = enums.Retrying
+			retryAt = &delay
+		} else if job.Attempt < job.MaxAttempts {
+			logging.Job(job).ErrorContext(jobCtx, "failed: attempt failed with non-retryable error", "error", err, "error_class", Classify(err))
+			job.Status = enums.Failed
+		} else {
+			logging.Job(job).ErrorContext(jobCtx, "failed: attempt failed with max attempts", "error", err, "max_attempts", job.MaxAttempts)
+			job.Status = enums.Failed
+		}
+	} else {
+		logging.Job(job).InfoContext(jobCtx, "success: attempt was successful")
+		job.LastError = nil
+		job.Status = enums.Completed
+	}
+
+	/*cancelled or reaped while executing, whoever took the job over already recorded its fate*/
+	if err := w.Scheduler.UpdateJob(jobCtx, job); errors.Is(err, database.ErrLeaseLost) {
+		logging.Job(job).WarnContext(jobCtx, "lease lost: job changed while executing, dropping outcome", "status", job.Status)
+		countExecution(job, "lease_lost")
+		return
+	}
+
+	if job.LastError != nil {
+		w.Scheduler.SaveJobError(jobCtx, jobs.JobError{
+			JobID:     job.ID,
+			Attempt:   job.Attempt,
+			Error:     *job.LastError,
+			CreatedAt: end,
+		})
+	}
+	w.Scheduler.SaveJobAttempt(jobCtx, job.FinishedAttempt(&w.ID, retryAt))
+	if retryAt != nil {
+		w.Scheduler.PushWaitingQueue(jobCtx, job.RedisJob(*retryAt))
+	} else {
+		w.Scheduler.JobFinished(jobCtx, job)
+	}
+	countExecution(job, string(job.Status))
+}
+
+/*
+Counts an execution of the job which ended with outcome
+*/
+func countExecution(job *jobs.Job, outcome string) {
+	metrics.JobExecutions.WithLabelValues(job.JobType, outcome).Inc()
+}
+
+/*
+Renews the job's lease every third of the lease duration while it executes.
+If the lease was lost, e.g. the reaper gave the job to another worker after
+the database was unreachable for too long, the execution is stopped
+*/
+func (w *Worker) heartbeat(ctx context.Context, jobID int64, leaseToken string, stop context.CancelCauseFunc) {
+	ticker := time.NewTicker(jobs.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := w.Scheduler.ExtendLease(ctx, jobID, leaseToken)
+			if errors.Is(err, database.ErrLeaseLost) {
+				stop(err)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "failed to renew lease of job", "job_id", jobID, "worker_id", w.ID, "error", err)
 			}
 		}
 	}
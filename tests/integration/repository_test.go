@@ -0,0 +1,389 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/go-sql-driver/mysql"
+)
+
+const testDatabase = "tickr_test"
+
+/*
+Creates a fresh tickr_test database from docker/mysql/init.sql on the MySQL server
+in TICKR_TEST_MYSQL_DSN and returns a repository using it, skips the test without a server
+*/
+func newTestRepository(t *testing.T) (*database.MySQLRepository, *sql.DB) {
+	dsn := os.Getenv("TICKR_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TICKR_TEST_MYSQL_DSN not set")
+	}
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("invalid TICKR_TEST_MYSQL_DSN: %v", err)
+	}
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+
+	schema, err := os.ReadFile("../../docker/mysql/init.sql")
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	ddl := strings.Replace(string(schema), "CREATE DATABASE IF NOT EXISTS tickr;\nUSE tickr;", "USE "+testDatabase+";", 1)
+
+	cfg.DBName = ""
+	admin, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer admin.Close()
+
+	conn, err := admin.Conn(t.Context())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(t.Context(), "DROP DATABASE IF EXISTS "+testDatabase+"; CREATE DATABASE "+testDatabase+"; "+ddl); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	cfg.DBName = testDatabase
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return database.NewMySQLRepository(db), db
+}
+
+/*
+Returns a pending job of the tenant, as SubmitJob saves it
+*/
+func newJob(tenant string, jobType string) jobs.Job {
+	now := time.Now().UTC().Truncate(time.Second)
+	return jobs.Job{
+		Tenant:      tenant,
+		JobType:     jobType,
+		Payload:     []byte(`{}`),
+		Status:      enums.Pending,
+		Queue:       jobs.DefaultQueue,
+		Priority:    enums.Normal,
+		MaxAttempts: jobs.DefaultMaxAttempts,
+		CreatedAt:   now,
+		ScheduledAt: now,
+	}
+}
+
+func saveJob(t *testing.T, repo *database.MySQLRepository, job jobs.Job) int64 {
+	id, err := repo.SaveJob(t.Context(), job)
+	if err != nil {
+		t.Fatalf("failed to save job: %v", err)
+	}
+	return id
+}
+
+func setStatus(t *testing.T, db *sql.DB, jobID int64, status enums.Status) {
+	if _, err := db.ExecContext(t.Context(), "UPDATE jobs SET status = ? WHERE id = ?", status, jobID); err != nil {
+		t.Fatalf("failed to set status of job %d: %v", jobID, err)
+	}
+}
+
+func TestListJobsPaginatesAndFilters(t *testing.T) {
+	repo, db := newTestRepository(t)
+
+	var emails []int64
+	for i := range 5 {
+		emails = append(emails, saveJob(t, repo, newJob("", "email")))
+		saveJob(t, repo, newJob("", "report"))
+		saveJob(t, repo, newJob("acme", "email"))
+		if i == 1 {
+			setStatus(t, db, emails[i], enums.Failed)
+		}
+	}
+
+	filter := database.JobFilter{Tenant: jobs.DefaultTenant, JobType: "email", Limit: 2}
+	var pages [][]int64
+	for {
+		list, err := repo.ListJobs(t.Context(), filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) == 0 {
+			break
+		}
+		var page []int64
+		for _, job := range list {
+			if job.Tenant != jobs.DefaultTenant || job.JobType != "email" {
+				t.Errorf("job %d doesn't match the filter: %s %s", job.ID, job.Tenant, job.JobType)
+			}
+			page = append(page, job.ID)
+		}
+		pages = append(pages, page)
+		filter.AfterID = list[len(list)-1].ID
+	}
+
+	expected := [][]int64{emails[0:2], emails[2:4], emails[4:5]}
+	if fmt.Sprint(pages) != fmt.Sprint(expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+
+	failed, err := repo.ListJobs(t.Context(), database.JobFilter{
+		Tenant:   jobs.DefaultTenant,
+		Statuses: []enums.Status{enums.Failed, enums.Cancelled},
+		Limit:    10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 1 || failed[0].ID != emails[1] {
+		t.Errorf("expected only job %d to be failed, got %+v", emails[1], failed)
+	}
+}
+
+func TestJobLeaseIsFencedByToken(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := t.Context()
+	jobID := saveJob(t, repo, newJob("", "email"))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	job, err := repo.ClaimJob(ctx, jobID, 1, "first", now, now.Add(jobs.LeaseDuration))
+	if err != nil {
+		t.Fatalf("expected job to be claimed, got %v", err)
+	}
+	if job.Status != enums.Executing || job.LeaseToken != "first" {
+		t.Errorf("expected executing job held by the first claim, got %s %q", job.Status, job.LeaseToken)
+	}
+
+	if _, err := repo.ClaimJob(ctx, jobID, 1, "second", now, now.Add(jobs.LeaseDuration)); !errors.Is(err, database.ErrJobNotClaimable) {
+		t.Errorf("expected executing job not to be claimed again, got %v", err)
+	}
+
+	/*the second renewal writes the same value, which MySQL reports as no affected rows*/
+	for range 2 {
+		if err := repo.ExtendLease(ctx, jobID, "first", now.Add(2*jobs.LeaseDuration)); err != nil {
+			t.Errorf("expected lease to be renewed by its holder, got %v", err)
+		}
+	}
+	if err := repo.ExtendLease(ctx, jobID, "second", now.Add(2*jobs.LeaseDuration)); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected lease not to be renewed under another token, got %v", err)
+	}
+
+	stale := *job
+	stale.LeaseToken = "second"
+	stale.Status = enums.Completed
+	if err := repo.UpdateJob(ctx, &stale); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected outcome under another token to be dropped, got %v", err)
+	}
+
+	job.Status = enums.Completed
+	job.FinishedAt = &now
+	if err := repo.UpdateJob(ctx, job); err != nil {
+		t.Errorf("expected outcome of the holder to be saved, got %v", err)
+	}
+	if err := repo.ExtendLease(ctx, jobID, "first", now.Add(3*jobs.LeaseDuration)); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected lease of a completed job to be lost, got %v", err)
+	}
+
+	saved, err := repo.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != enums.Completed || saved.WorkerID != nil || saved.LeasedUntil != nil {
+		t.Errorf("expected completed job without a lease, got %s %v %v", saved.Status, saved.WorkerID, saved.LeasedUntil)
+	}
+}
+
+func TestCancelledRunningJobKeepsItsAttempt(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := t.Context()
+	jobID := saveJob(t, repo, newJob("", "email"))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	job, err := repo.ClaimJob(ctx, jobID, 1, "first", now, now.Add(jobs.LeaseDuration))
+	if err != nil {
+		t.Fatalf("expected job to be claimed, got %v", err)
+	}
+	if err := repo.CancelJob(ctx, jobID); err != nil {
+		t.Fatalf("expected executing job to be cancelled, got %v", err)
+	}
+
+	saved, err := repo.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved.Status != enums.Cancelled || saved.WorkerID != nil || saved.LeasedUntil != nil {
+		t.Errorf("expected cancelled job without a lease, got %s %v %v", saved.Status, saved.WorkerID, saved.LeasedUntil)
+	}
+	if err := repo.ExtendLease(ctx, jobID, "first", now.Add(2*jobs.LeaseDuration)); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected lease of a cancelled job to be lost, got %v", err)
+	}
+
+	/*the worker records the attempt it was executing once its handler returned*/
+	errMsg := jobs.ErrCancelled.Error()
+	job.Attempt = 1
+	job.Status = enums.Cancelled
+	job.LastError = &errMsg
+	job.FinishedAt = &now
+	workerID := 1
+	if err := repo.SaveJobAttempt(ctx, job.FinishedAttempt(&workerID, nil)); err != nil {
+		t.Fatalf("expected attempt to be saved, got %v", err)
+	}
+
+	attempts, err := repo.GetJobAttempts(ctx, jobID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(attempts) != 1 || attempts[0].Status != enums.Cancelled || attempts[0].Error == nil || *attempts[0].Error != errMsg {
+		t.Errorf("expected one cancelled attempt, got %+v", attempts)
+	}
+}
+
+func TestSaveJobChecksDependencies(t *testing.T) {
+	repo, db := newTestRepository(t)
+
+	pending := saveJob(t, repo, newJob("", "email"))
+	completed := saveJob(t, repo, newJob("", "email"))
+	setStatus(t, db, completed, enums.Completed)
+	failed := saveJob(t, repo, newJob("", "email"))
+	setStatus(t, db, failed, enums.Failed)
+	otherTenant := saveJob(t, repo, newJob("acme", "email"))
+	setStatus(t, db, otherTenant, enums.Completed)
+
+	tests := []struct {
+		name           string
+		dependsOn      []int64
+		expectedErr    error
+		expectedStatus enums.Status
+	}{
+		{name: "parent still pending", dependsOn: []int64{pending, completed}, expectedStatus: enums.Blocked},
+		{name: "every parent completed", dependsOn: []int64{completed}, expectedStatus: enums.Pending},
+		{name: "parent failed", dependsOn: []int64{completed, failed}, expectedErr: database.ErrDependencyFailed},
+		{name: "parent missing", dependsOn: []int64{completed, 999999}, expectedErr: database.ErrDependencyNotFound},
+		{name: "parent of another tenant", dependsOn: []int64{otherTenant}, expectedErr: database.ErrDependencyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newJob("", "report")
+			job.DependsOn = tt.dependsOn
+
+			id, err := repo.SaveJob(t.Context(), job)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			saved, err := repo.GetJob(t.Context(), id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if saved.Status != tt.expectedStatus {
+				t.Errorf("expected %s, got %s", tt.expectedStatus, saved.Status)
+			}
+
+			var parents int
+			db.QueryRowContext(t.Context(), "SELECT COUNT(*) FROM job_dependencies WHERE job_id = ?", id).Scan(&parents)
+			if parents != len(tt.dependsOn) {
+				t.Errorf("expected %d dependencies saved, got %d", len(tt.dependsOn), parents)
+			}
+		})
+	}
+}
+
+func TestUniqueKeyHeldByActiveJobs(t *testing.T) {
+	repo, db := newTestRepository(t)
+	ctx := t.Context()
+
+	withKey := func(tenant string) jobs.Job {
+		job := newJob(tenant, "email")
+		key := "invoice-7"
+		job.UniqueKey = &key
+		return job
+	}
+
+	first := saveJob(t, repo, withKey(""))
+	saveJob(t, repo, withKey("acme"))
+
+	for _, status := range []enums.Status{enums.Pending, enums.Retrying, enums.Executing, enums.Blocked} {
+		setStatus(t, db, first, status)
+		if _, err := repo.SaveJob(ctx, withKey("")); !errors.Is(err, database.ErrUniqueKeyActive) {
+			t.Errorf("expected key to be held by a %s job, got %v", status, err)
+		}
+
+		active, err := repo.GetActiveJobByUniqueKey(ctx, "", "invoice-7")
+		if err != nil || active.ID != first {
+			t.Errorf("expected job %d to hold the key while %s, got %v %v", first, status, active, err)
+		}
+	}
+
+	setStatus(t, db, first, enums.Completed)
+	if _, err := repo.GetActiveJobByUniqueKey(ctx, "", "invoice-7"); !errors.Is(err, database.ErrJobNotFound) {
+		t.Errorf("expected no job to hold the key, got %v", err)
+	}
+	if _, err := repo.SaveJob(ctx, withKey("")); err != nil {
+		t.Errorf("expected key to be free once the job completed, got %v", err)
+	}
+}
+
+func TestSaveBatchReturnsIDsInJobOrder(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := t.Context()
+
+	/*more jobs than a single insert takes, saved alongside another batch*/
+	const size = 1500
+	batchJobs := func(tag string) []jobs.Job {
+		res := make([]jobs.Job, size)
+		for i := range res {
+			res[i] = newJob("", "email")
+			res[i].Payload = fmt.Appendf(nil, `{"tag":%q,"index":%d}`, tag, i)
+		}
+		return res
+	}
+
+	var wg sync.WaitGroup
+	ids := make(map[string][]int64)
+	var mu sync.Mutex
+	for _, tag := range []string{"a", "b"} {
+		wg.Go(func() {
+			_, batchIDs, err := repo.SaveBatch(ctx, jobs.Batch{CreatedAt: time.Now().UTC()}, batchJobs(tag))
+			if err != nil {
+				t.Errorf("failed to save batch %s: %v", tag, err)
+				return
+			}
+			mu.Lock()
+			ids[tag] = batchIDs
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for tag, batchIDs := range ids {
+		if len(batchIDs) != size {
+			t.Fatalf("expected %d IDs for batch %s, got %d", size, tag, len(batchIDs))
+		}
+		for _, i := range []int{0, 999, 1000, size - 1} {
+			job, err := repo.GetJob(context.Background(), batchIDs[i])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := fmt.Sprintf(`{"tag":%q,"index":%d}`, tag, i); strings.ReplaceAll(string(job.Payload), " ", "") != expected {
+				t.Errorf("expected ID %d to be job %s of batch %s, got %s", batchIDs[i], expected, tag, job.Payload)
+			}
+		}
+	}
+}
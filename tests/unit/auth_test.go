@@ -0,0 +1,275 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/worker"
+)
+
+const testAdminKey = "admin-secret"
+
+/*
+Creates an API key through the admin endpoint and returns it
+*/
+func createAPIKey(t *testing.T, handler *api.Handler, body string) string {
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/api-keys", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	rr := httptest.NewRecorder()
+	handler.Auth(enums.AdminScope, handler.CreateAPIKey).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected api key to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var res struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+	return res.Data.Key
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		header             string
+		scope              enums.Scope
+		body               string
+		expectedStatusCode int
+		expectAPIKeyID     bool
+	}{
+		{
+			name:               "missing key",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "unknown key",
+			key:                "tickr_unknown",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "admin key",
+			key:                testAdminKey,
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"http", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "submit key",
+			key:                "submit",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectAPIKeyID:     true,
+		},
+		{
+			name:               "submit key in X-API-Key header",
+			key:                "submit",
+			header:             "X-API-Key",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectAPIKeyID:     true,
+		},
+		{
+			name:               "job type the key may not submit",
+			key:                "submit",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"http", "payload":""}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "callback needs the http job type",
+			key:                "submit",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":"", "callbackUrl":"https://example.com/hook"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "read key can't submit",
+			key:                "read",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "revoked key",
+			key:                "revoked",
+			scope:              enums.SubmitScope,
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			handler.AdminKey = testAdminKey
+
+			keys := map[string]string{
+				"submit":  createAPIKey(t, handler, `{"name":"billing", "scopes":["submit"], "jobTypes":["email"]}`),
+				"read":    createAPIKey(t, handler, `{"name":"dashboard", "scopes":["read"]}`),
+				"revoked": createAPIKey(t, handler, `{"name":"old", "scopes":["submit"]}`),
+			}
+			s.RevokeAPIKey(t.Context(), 3, "")
+
+			key := tt.key
+			if stored, ok := keys[key]; ok {
+				key = stored
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(tt.body))
+			switch {
+			case key == "":
+			case tt.header != "":
+				req.Header.Set(tt.header, key)
+			default:
+				req.Header.Set("Authorization", "Bearer "+key)
+			}
+			rr := httptest.NewRecorder()
+			handler.Auth(tt.scope, handler.SubmitJob).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			job := s.jobs[1]
+			if tt.expectAPIKeyID && (job.APIKeyID == nil || *job.APIKeyID != 1) {
+				t.Errorf("expected job submitted by api key 1, got %v", job.APIKeyID)
+			}
+			if !tt.expectAPIKeyID && job.APIKeyID != nil {
+				t.Errorf("expected job without api key, got %v", *job.APIKeyID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{name: "valid key", body: `{"name":"billing", "scopes":["submit","read"], "jobTypes":["email","report"]}`, expectedStatusCode: http.StatusOK},
+		{name: "missing name", body: `{"scopes":["read"]}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "missing scopes", body: `{"name":"billing"}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "unknown scope", body: `{"name":"billing", "scopes":["root"]}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "unknown job type", body: `{"name":"billing", "scopes":["submit"], "jobTypes":["sms"]}`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/api-keys", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.CreateAPIKey(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				if len(s.apiKeys) != 0 {
+					t.Errorf("expected no api key saved, got %d", len(s.apiKeys))
+				}
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Key    string `json:"key"`
+					APIKey struct {
+						ID     int64  `json:"id"`
+						Prefix string `json:"prefix"`
+					} `json:"apiKey"`
+				} `json:"data"`
+			}
+			json.NewDecoder(rr.Body).Decode(&res)
+			if !strings.HasPrefix(res.Data.Key, "tickr_") || !strings.HasPrefix(res.Data.Key, res.Data.APIKey.Prefix) {
+				t.Errorf("expected key starting with its prefix %q, got %q", res.Data.APIKey.Prefix, res.Data.Key)
+			}
+			stored := s.apiKeys[0]
+			if stored.KeyHash == "" || strings.Contains(stored.KeyHash, res.Data.Key) {
+				t.Errorf("expected key stored hashed, got %q", stored.KeyHash)
+			}
+			if strings.Contains(rr.Body.String(), stored.KeyHash) {
+				t.Error("expected key hash not to be returned")
+			}
+
+			for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+				req := httptest.NewRequest(http.MethodDelete, "/api/v2/api-keys/1", nil)
+				req.SetPathValue("id", "1")
+				rr := httptest.NewRecorder()
+				handler.RevokeAPIKey(rr, req)
+				if rr.Code != expected {
+					t.Errorf("expected revoke status %d, got %d", expected, rr.Code)
+				}
+			}
+			if s.apiKeys[0].RevokedAt == nil {
+				t.Error("expected api key to be revoked")
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyGrantsOnlyWhatCreatorHolds(t *testing.T) {
+	tests := []struct {
+		name               string
+		creator            string
+		body               string
+		expectedStatusCode int
+	}{
+		{name: "operator creates key of every job type", creator: "admin", body: `{"name":"ops", "scopes":["admin"]}`, expectedStatusCode: http.StatusOK},
+		{name: "tenant admin creates key of its job types", creator: "acme", body: `{"name":"mailer", "scopes":["submit"], "jobTypes":["email"]}`, expectedStatusCode: http.StatusOK},
+		{name: "tenant admin creates key of another job type", creator: "acme", body: `{"name":"fetcher", "scopes":["submit"], "jobTypes":["email","http"]}`, expectedStatusCode: http.StatusForbidden},
+		{name: "tenant admin creates key of every job type", creator: "acme", body: `{"name":"anything", "scopes":["submit"]}`, expectedStatusCode: http.StatusForbidden},
+		{name: "submit key creates key of its scopes", creator: "submit", body: `{"name":"mailer", "scopes":["submit"], "jobTypes":["email"]}`, expectedStatusCode: http.StatusOK},
+		{name: "submit key creates admin key", creator: "submit", body: `{"name":"root", "scopes":["submit","admin"], "jobTypes":["email"]}`, expectedStatusCode: http.StatusForbidden},
+		{name: "submit key creates read key", creator: "submit", body: `{"name":"dashboard", "scopes":["read"], "jobTypes":["email"]}`, expectedStatusCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			handler.AdminKey = testAdminKey
+			keys := map[string]string{
+				"admin":  testAdminKey,
+				"acme":   createAPIKey(t, handler, `{"tenant":"acme", "name":"acme admin", "scopes":["admin"], "jobTypes":["email"]}`),
+				"submit": createAPIKey(t, handler, `{"name":"billing", "scopes":["submit"], "jobTypes":["email"]}`),
+			}
+			saved := len(s.apiKeys)
+
+			/*the route only lets admin keys in, a submit key shows what the handler checks on its own*/
+			scope := enums.AdminScope
+			if tt.creator == "submit" {
+				scope = enums.SubmitScope
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/api-keys", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+keys[tt.creator])
+			rr := httptest.NewRecorder()
+			handler.Auth(scope, handler.CreateAPIKey).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK && len(s.apiKeys) != saved {
+				t.Errorf("expected no api key saved, got %d", len(s.apiKeys)-saved)
+			}
+		})
+	}
+}
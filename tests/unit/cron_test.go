@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/cron"
+)
+
+func TestCronNext(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		after    time.Time
+		expected time.Time
+	}{
+		{
+			name:     "every minute",
+			expr:     "* * * * *",
+			after:    time.Date(2026, 1, 12, 8, 38, 21, 0, time.UTC),
+			expected: time.Date(2026, 1, 12, 8, 39, 0, 0, time.UTC),
+		},
+		{
+			name:     "occurrence is strictly after the given time",
+			expr:     "30 8 * * *",
+			after:    time.Date(2026, 1, 12, 8, 30, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 13, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "steps",
+			expr:     "*/15 * * * *",
+			after:    time.Date(2026, 1, 12, 8, 31, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 12, 8, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekdays only",
+			expr:     "0 9 * * 1-5",
+			after:    time.Date(2026, 1, 16, 10, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 19, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday as 7",
+			expr:     "0 0 * * 7",
+			after:    time.Date(2026, 1, 12, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			expr:     "0 0 1 * 1",
+			after:    time.Date(2026, 1, 27, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "monthly macro",
+			expr:     "@monthly",
+			after:    time.Date(2026, 12, 5, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "evaluated in the given location",
+			expr:     "0 9 * * *",
+			after:    time.Date(2026, 1, 12, 4, 0, 0, 0, time.UTC).In(kolkata),
+			expected: time.Date(2026, 1, 13, 9, 0, 0, 0, kolkata),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			next, err := schedule.Next(tt.after)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !next.Equal(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, next)
+			}
+		})
+	}
+}
+
+func TestCronParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := cron.Parse(expr); err == nil {
+				t.Errorf("expected error for %q, got nil", expr)
+			}
+		})
+	}
+}
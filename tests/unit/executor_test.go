@@ -1,10 +1,16 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 	"github.com/blueberry-adii/tickr/internal/worker"
 )
@@ -77,9 +83,9 @@ func TestExecutor(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			e := worker.NewExecutor()
+			e := worker.NewExecutor(worker.DefaultRegistry())
 
-			err := e.ExecuteJob(tt.job)
+			err := e.ExecuteJob(context.Background(), tt.job)
 
 			if err != nil && !tt.expectedError {
 				t.Errorf("expected no error, got: %v", err)
@@ -93,3 +99,151 @@ func TestExecutor(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutorCustomHandler(t *testing.T) {
+	registry := worker.DefaultRegistry()
+	registry.Register("sms", worker.HandlerFunc(func(ctx context.Context, job *jobs.Job) error {
+		job.Result = []byte(`{"data":"sent sms"}`)
+		return nil
+	}))
+
+	if !registry.Has("sms") {
+		t.Fatalf("expected sms handler to be registered")
+	}
+
+	job := &jobs.Job{JobType: "sms", Payload: []byte(`{}`)}
+	if err := worker.NewExecutor(registry).ExecuteJob(context.Background(), job); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(job.Result) != `{"data":"sent sms"}` {
+		t.Errorf("expected custom handler result, got %s", job.Result)
+	}
+
+	if worker.DefaultRegistry().Has("sms") {
+		t.Errorf("expected custom handler to be registered on its own registry only")
+	}
+}
+
+func TestRegistryInternalJobTypes(t *testing.T) {
+	registry := worker.DefaultRegistry()
+
+	if registry.Has("webhook") {
+		t.Errorf("expected webhook job type not to be submittable")
+	}
+	if _, ok := registry.Handler("webhook"); !ok {
+		t.Errorf("expected webhook handler to run on the workers")
+	}
+	if types := registry.JobTypes(); slices.Contains(types, "webhook") {
+		t.Errorf("expected internal job types to be left out, got %v", types)
+	}
+}
+
+func TestExecutorTimeout(t *testing.T) {
+	job := &jobs.Job{
+		JobType: "report",
+		Payload: []byte(`{"title":"slow","body":"report","time":10}`),
+		Timeout: 1,
+	}
+
+	start := time.Now()
+	err := worker.NewExecutor(worker.DefaultRegistry()).ExecuteJob(context.Background(), job)
+
+	if !errors.Is(err, worker.ErrJobTimeout) {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected report to be interrupted after its timeout, took %v", elapsed)
+	}
+}
+
+func TestExecutorCancelledContext(t *testing.T) {
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slowServer.Close()
+
+	tests := []struct {
+		name string
+		job  *jobs.Job
+	}{
+		{
+			name: "report is interrupted",
+			job: &jobs.Job{
+				JobType: "report",
+				Payload: []byte(`{"title":"slow","body":"report","time":10}`),
+			},
+		},
+		{
+			name: "http request is interrupted",
+			job: &jobs.Job{
+				JobType: "http",
+				Payload: []byte(`{"url":"` + slowServer.URL + `","method":"GET"}`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			err := worker.NewExecutor(worker.DefaultRegistry()).ExecuteJob(ctx, tt.job)
+
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if errors.Is(err, worker.ErrJobTimeout) {
+				t.Errorf("expected cancellation not to be reported as a job timeout")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected handler to stop once the context is done, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestOnCompleteWebhookDeliversBatch(t *testing.T) {
+	received := make(chan jobs.Batch, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch jobs.Batch
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&batch) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- batch
+	}))
+	defer receiver.Close()
+
+	now := time.Now()
+	onComplete := &jobs.OnComplete{URL: receiver.URL}
+	batch := jobs.Batch{
+		ID:          3,
+		Total:       2,
+		Counts:      map[enums.Status]int{enums.Completed: 1, enums.Failed: 1},
+		OnComplete:  onComplete,
+		CreatedAt:   now,
+		CompletedAt: &now,
+	}
+
+	job, err := onComplete.Job(batch, now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if job.JobType != "http" || job.Status != enums.Pending || job.Queue != jobs.DefaultQueue {
+		t.Fatalf("unexpected webhook job %+v", job)
+	}
+
+	if err := worker.NewExecutor(worker.DefaultRegistry()).ExecuteJob(context.Background(), &job); err != nil {
+		t.Fatalf("unexpected error delivering webhook %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got.ID != 3 || got.Counts[enums.Failed] != 1 || got.OnComplete != nil {
+			t.Errorf("unexpected batch delivered %+v", got)
+		}
+	default:
+		t.Fatalf("expected webhook to be delivered")
+	}
+}
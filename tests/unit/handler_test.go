@@ -2,32 +2,273 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 	"github.com/blueberry-adii/tickr/internal/scheduler"
+	"github.com/blueberry-adii/tickr/internal/worker"
 )
 
 type MockScheduler struct {
 	readyQueue   []*jobs.RedisJob
 	waitingQueue []*jobs.RedisJob
+	jobs         map[int64]*jobs.Job
+	filter       database.JobFilter
+	schedules    []jobs.Schedule
+	jobErrors    map[int64][]jobs.JobError
+	batches      map[int64]*jobs.Batch
+	events       []jobs.JobEvent
+	attempts     map[int64][]jobs.JobAttempt
+	apiKeys      []jobs.APIKey
+	tenants      []jobs.Tenant
 }
 
 func (q *MockScheduler) SaveJob(ctx context.Context, job jobs.Job) (int64, error) {
-	return 0, nil
+	if q.jobs == nil {
+		q.jobs = make(map[int64]*jobs.Job)
+	}
+	if job.IdempotencyKey != nil {
+		if _, err := q.GetJobByIdempotencyKey(ctx, job.Tenant, *job.IdempotencyKey); err == nil {
+			return 0, database.ErrIdempotencyKeyUsed
+		}
+	}
+	if job.UniqueKey != nil {
+		if _, err := q.GetActiveJobByUniqueKey(ctx, job.Tenant, *job.UniqueKey); err == nil {
+			return 0, database.ErrUniqueKeyActive
+		}
+	}
+	completed := true
+	for _, parentID := range job.DependsOn {
+		parent, ok := q.jobs[parentID]
+		if !ok {
+			return 0, database.ErrDependencyNotFound
+		}
+		if parent.Status == enums.Failed || parent.Status == enums.Cancelled {
+			return 0, database.ErrDependencyFailed
+		}
+		completed = completed && parent.Status == enums.Completed
+	}
+	if !completed {
+		job.Status = enums.Blocked
+	}
+	job.ID = int64(len(q.jobs) + 1)
+	q.jobs[job.ID] = &job
+	return job.ID, nil
+}
+func (q *MockScheduler) GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	for _, job := range q.jobs {
+		sameTenant := jobs.TenantName(job.Tenant) == jobs.TenantName(tenant)
+		if sameTenant && job.IdempotencyKey != nil && *job.IdempotencyKey == key {
+			return job, nil
+		}
+	}
+	return nil, database.ErrJobNotFound
+}
+func (q *MockScheduler) GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	for _, job := range q.jobs {
+		active := job.Status == enums.Pending || job.Status == enums.Retrying || job.Status == enums.Executing || job.Status == enums.Blocked
+		active = active && jobs.TenantName(job.Tenant) == jobs.TenantName(tenant)
+		if active && job.UniqueKey != nil && *job.UniqueKey == key {
+			return job, nil
+		}
+	}
+	return nil, database.ErrJobNotFound
+}
+func (q *MockScheduler) SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error) {
+	if q.batches == nil {
+		q.batches = make(map[int64]*jobs.Batch)
+	}
+	batchID := int64(len(q.batches) + 1)
+	batch.ID = batchID
+	batch.Total = len(batchJobs)
+	batch.Counts = map[enums.Status]int{}
+	q.batches[batchID] = &batch
+
+	var ids []int64
+	for _, job := range batchJobs {
+		job.BatchID = &batchID
+		id, err := q.SaveJob(ctx, job)
+		if err != nil {
+			return 0, nil, err
+		}
+		q.batches[batchID].Counts[job.Status]++
+		ids = append(ids, id)
+	}
+	return batchID, ids, nil
+}
+func (q *MockScheduler) GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error) {
+	batch, ok := q.batches[batchID]
+	if !ok {
+		return nil, database.ErrBatchNotFound
+	}
+	return batch, nil
+}
+func (q *MockScheduler) PushBatch(ctx context.Context, batch []*jobs.RedisJob, now time.Time) error {
+	for _, job := range batch {
+		if job.ScheduledAt.After(now) {
+			q.waitingQueue = append(q.waitingQueue, job)
+		} else {
+			q.readyQueue = append(q.readyQueue, job)
+		}
+	}
+	return nil
+}
+func (q *MockScheduler) ReleaseIdempotencyKey(ctx context.Context, jobID int64) error {
+	if job, ok := q.jobs[jobID]; ok {
+		job.IdempotencyKey = nil
+	}
+	return nil
+}
+func (q *MockScheduler) GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error) {
+	return q.attempts[jobID], nil
+}
+
+/*
+Returns the preset events, then closes the channel like a scheduler shutting down
+*/
+func (q *MockScheduler) Events(ctx context.Context) <-chan jobs.JobEvent {
+	ch := make(chan jobs.JobEvent, len(q.events))
+	for _, event := range q.events {
+		ch <- event
+	}
+	close(ch)
+	return ch
+}
+
+func (q *MockScheduler) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return nil, database.ErrJobNotFound
+	}
+	return job, nil
+}
+func (q *MockScheduler) CancelJob(ctx context.Context, jobID int64) error {
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return database.ErrJobNotFound
+	}
+	if job.Status != enums.Pending && job.Status != enums.Retrying && job.Status != enums.Executing {
+		return database.ErrJobNotCancellable
+	}
+	job.Status = enums.Cancelled
+	return nil
+}
+func (q *MockScheduler) SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error) {
+	q.schedules = append(q.schedules, schedule)
+	return int64(len(q.schedules)), nil
+}
+func (q *MockScheduler) GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error) {
+	return nil, database.ErrScheduleNotFound
+}
+func (q *MockScheduler) ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error) {
+	var res []jobs.Schedule
+	for _, schedule := range q.schedules {
+		if jobs.TenantName(schedule.Tenant) == tenant {
+			res = append(res, schedule)
+		}
+	}
+	return res, nil
+}
+func (q *MockScheduler) DeleteSchedule(ctx context.Context, scheduleID int64) error {
+	return database.ErrScheduleNotFound
+}
+func (q *MockScheduler) GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error) {
+	return q.jobErrors, nil
+}
+func (q *MockScheduler) ReplayJob(ctx context.Context, jobID int64) error {
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return database.ErrJobNotFound
+	}
+	if job.Status != enums.Failed {
+		return database.ErrJobNotReplayable
+	}
+	job.Status = enums.Pending
+	job.Attempt = 0
+	q.readyQueue = append(q.readyQueue, &jobs.RedisJob{JobID: jobID})
+	return nil
+}
+func (q *MockScheduler) ListJobs(ctx context.Context, filter database.JobFilter) ([]jobs.Job, error) {
+	q.filter = filter
+	var res []jobs.Job
+	for id := filter.AfterID + 1; len(res) < filter.Limit; id++ {
+		job, ok := q.jobs[id]
+		if !ok {
+			break
+		}
+		if len(filter.Statuses) == 1 && job.Status != filter.Statuses[0] {
+			continue
+		}
+		res = append(res, *job)
+	}
+	return res, nil
 }
 func (q *MockScheduler) PushWaitingQueue(ctx context.Context, job *jobs.RedisJob) error {
 	q.waitingQueue = append(q.waitingQueue, job)
 	return nil
 }
+func (q *MockScheduler) HasQueue(name string) bool {
+	return name == jobs.DefaultQueue || name == "bulk"
+}
 func (q *MockScheduler) PushReadyQueue(ctx context.Context, job *jobs.RedisJob) error {
 	q.readyQueue = append(q.readyQueue, job)
 	return nil
 }
+func (q *MockScheduler) SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error) {
+	key.ID = int64(len(q.apiKeys) + 1)
+	q.apiKeys = append(q.apiKeys, key)
+	return key.ID, nil
+}
+func (q *MockScheduler) GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error) {
+	for i := range q.apiKeys {
+		if q.apiKeys[i].KeyHash == hash {
+			return &q.apiKeys[i], nil
+		}
+	}
+	return nil, database.ErrAPIKeyNotFound
+}
+func (q *MockScheduler) ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error) {
+	var res []jobs.APIKey
+	for _, key := range q.apiKeys {
+		if tenant == "" || key.Tenant == tenant {
+			res = append(res, key)
+		}
+	}
+	return res, nil
+}
+func (q *MockScheduler) RevokeAPIKey(ctx context.Context, keyID int64, tenant string) error {
+	for i := range q.apiKeys {
+		sameTenant := tenant == "" || q.apiKeys[i].Tenant == tenant
+		if q.apiKeys[i].ID == keyID && sameTenant && q.apiKeys[i].RevokedAt == nil {
+			now := time.Now()
+			q.apiKeys[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return database.ErrAPIKeyNotFound
+}
+
+func (q *MockScheduler) SaveTenant(ctx context.Context, tenant jobs.Tenant) error {
+	for i := range q.tenants {
+		if q.tenants[i].Name == tenant.Name {
+			q.tenants[i] = tenant
+			return nil
+		}
+	}
+	q.tenants = append(q.tenants, tenant)
+	return nil
+}
+func (q *MockScheduler) ListTenants(ctx context.Context) ([]jobs.Tenant, error) {
+	return q.tenants, nil
+}
 
 var _ scheduler.Queue = &MockScheduler{}
 
@@ -61,6 +302,83 @@ func TestSubmitJobHandler(t *testing.T) {
 			expectedWaitingLen: 0,
 			expectedReadyLen:   1,
 		},
+		{
+			name:               "Unknown jobtype",
+			body:               `{"jobtype":"sms", "payload":""}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Internal jobtype",
+			body:               `{"jobtype":"webhook", "payload":{"url":"https://example.com/hook", "body":{}}, "callbackSecret":"s3cret"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Invalid retry policy",
+			body:               `{"jobtype":"email", "payload":"", "retryPolicy":{"strategy":"random"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Unknown retryOn error class",
+			body:               `{"jobtype":"http", "payload":"", "retryPolicy":{"strategy":"fixed","base":5,"retryOn":["3xx"]}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Retry base longer than a day",
+			body:               `{"jobtype":"email", "payload":"", "retryPolicy":{"strategy":"linear","base":86401}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Negative max attempts",
+			body:               `{"jobtype":"email", "payload":"", "maxAttempts":-1}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Custom retry policy",
+			body:               `{"jobtype":"http", "payload":"", "maxAttempts":5, "retryPolicy":{"strategy":"exponential","base":2,"cap":60,"jitter":0.1,"retryOn":["5xx","network"]}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   1,
+		},
+		{
+			name:               "High priority job",
+			body:               `{"jobtype":"email", "payload":"", "priority":"high"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   1,
+		},
+		{
+			name:               "Job on a named queue",
+			body:               `{"jobtype":"report", "payload":"", "queue":"bulk"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   1,
+		},
+		{
+			name:               "Unknown queue",
+			body:               `{"jobtype":"report", "payload":"", "queue":"nope"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Unknown priority",
+			body:               `{"jobtype":"email", "payload":"", "priority":"urgent"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
 		{
 			name:               "Job Delayed by few seconds",
 			body:               `{"jobtype":"email", "payload":"", "delay":5}`,
@@ -68,12 +386,33 @@ func TestSubmitJobHandler(t *testing.T) {
 			expectedWaitingLen: 1,
 			expectedReadyLen:   0,
 		},
+		{
+			name:               "Job with signed callback",
+			body:               `{"jobtype":"email", "payload":"", "callbackUrl":"https://example.com/hook", "callbackSecret":"s3cret"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   1,
+		},
+		{
+			name:               "Callback url without scheme",
+			body:               `{"jobtype":"email", "payload":"", "callbackUrl":"example.com/hook"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "Callback secret without url",
+			body:               `{"jobtype":"email", "payload":"", "callbackSecret":"s3cret"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedWaitingLen: 0,
+			expectedReadyLen:   0,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &MockScheduler{}
-			handler := api.NewHandler(s)
+			handler := api.NewHandler(s, worker.DefaultRegistry())
 			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(tt.body))
 			req.Header.Set("Content-Type", "application/json")
 
@@ -97,3 +436,1243 @@ func TestSubmitJobHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestSubmitJobIdempotency(t *testing.T) {
+	key := "order-42"
+	tests := []struct {
+		name               string
+		existing           *jobs.Job
+		headers            []string
+		body               string
+		expectedStatusCode int
+		expectedReadyLen   int
+		expectedJobs       int
+		expectedReplayed   bool
+	}{
+		{
+			name:               "repeat within window returns the original job",
+			headers:            []string{key, key},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobs:       1,
+			expectedReplayed:   true,
+		},
+		{
+			name:               "key in body",
+			headers:            []string{"", ""},
+			body:               `{"jobtype":"email", "payload":"", "idempotencyKey":"order-42"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobs:       1,
+			expectedReplayed:   true,
+		},
+		{
+			name:               "different keys create separate jobs",
+			headers:            []string{"order-1", "order-2"},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   2,
+			expectedJobs:       2,
+		},
+		{
+			name:               "no key creates separate jobs",
+			headers:            []string{"", ""},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   2,
+			expectedJobs:       2,
+		},
+		{
+			name:               "key outside window creates a new job",
+			existing:           &jobs.Job{ID: 1, Status: enums.Completed, IdempotencyKey: &key, CreatedAt: time.Now().Add(-25 * time.Hour)},
+			headers:            []string{key},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobs:       2,
+		},
+		{
+			name:               "reserved key prefix",
+			headers:            []string{"tickr:callback:1"},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReadyLen:   0,
+			expectedJobs:       0,
+		},
+		{
+			name:               "key too long",
+			headers:            []string{strings.Repeat("k", 256)},
+			body:               `{"jobtype":"email", "payload":""}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReadyLen:   0,
+			expectedJobs:       0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{jobs: map[int64]*jobs.Job{}}
+			if tt.existing != nil {
+				s.jobs[tt.existing.ID] = tt.existing
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			var rr *httptest.ResponseRecorder
+			var firstID, lastID int64
+			for i, header := range tt.headers {
+				req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", "application/json")
+				if header != "" {
+					req.Header.Set("Idempotency-Key", header)
+				}
+
+				rr = httptest.NewRecorder()
+				handler.SubmitJob(rr, req)
+
+				var res struct {
+					Data struct {
+						JobID int64 `json:"jobID"`
+					} `json:"data"`
+				}
+				json.NewDecoder(rr.Body).Decode(&res)
+				if i == 0 {
+					firstID = res.Data.JobID
+				}
+				lastID = res.Data.JobID
+			}
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatusCode)
+			}
+			if len(s.readyQueue) != tt.expectedReadyLen {
+				t.Errorf("expected %d jobs in ready queue, got %d", tt.expectedReadyLen, len(s.readyQueue))
+			}
+
+			if len(s.jobs) != tt.expectedJobs {
+				t.Errorf("expected %d jobs, got %d", tt.expectedJobs, len(s.jobs))
+			}
+
+			replayed := rr.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.expectedReplayed {
+				t.Errorf("expected replayed %v, got %v", tt.expectedReplayed, replayed)
+			}
+			if tt.expectedReplayed && firstID != lastID {
+				t.Errorf("expected the original job %d, got %d", firstID, lastID)
+			}
+		})
+	}
+}
+
+func TestSubmitJobUniqueKey(t *testing.T) {
+	key := "report-customer-7"
+	tests := []struct {
+		name               string
+		existingStatus     enums.Status
+		body               string
+		expectedStatusCode int
+		expectedReadyLen   int
+		expectedJobID      int64
+	}{
+		{
+			name:               "rejected while pending",
+			existingStatus:     enums.Pending,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7"}`,
+			expectedStatusCode: http.StatusConflict,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "rejected while retrying",
+			existingStatus:     enums.Retrying,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7", "onConflict":"reject"}`,
+			expectedStatusCode: http.StatusConflict,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "rejected while blocked",
+			existingStatus:     enums.Blocked,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7"}`,
+			expectedStatusCode: http.StatusConflict,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "merged while executing",
+			existingStatus:     enums.Executing,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7", "onConflict":"merge"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   0,
+			expectedJobID:      1,
+		},
+		{
+			name:               "released once completed",
+			existingStatus:     enums.Completed,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobID:      2,
+		},
+		{
+			name:               "released once failed",
+			existingStatus:     enums.Failed,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobID:      2,
+		},
+		{
+			name:               "different key",
+			existingStatus:     enums.Pending,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-8"}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedJobID:      2,
+		},
+		{
+			name:               "invalid onConflict",
+			existingStatus:     enums.Pending,
+			body:               `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7", "onConflict":"replace"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReadyLen:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "report", Status: tt.existingStatus, UniqueKey: &key},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.SubmitJob(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatusCode)
+			}
+			if len(s.readyQueue) != tt.expectedReadyLen {
+				t.Errorf("expected %d jobs in ready queue, got %d", tt.expectedReadyLen, len(s.readyQueue))
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					JobID int64 `json:"jobID"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if res.Data.JobID != tt.expectedJobID {
+				t.Errorf("expected job %d, got %d", tt.expectedJobID, res.Data.JobID)
+			}
+		})
+	}
+}
+
+func TestSubmitJobDependsOn(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedReadyLen   int
+		expectedStatus     enums.Status
+	}{
+		{
+			name:               "blocked on an executing job",
+			body:               `{"jobtype":"email", "payload":"", "dependsOn":[1, 2]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   0,
+			expectedStatus:     enums.Blocked,
+		},
+		{
+			name:               "dependencies already completed",
+			body:               `{"jobtype":"email", "payload":"", "dependsOn":[1, 1]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedStatus:     enums.Pending,
+		},
+		{
+			name:               "failed dependency",
+			body:               `{"jobtype":"email", "payload":"", "dependsOn":[3]}`,
+			expectedStatusCode: http.StatusConflict,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "unknown dependency",
+			body:               `{"jobtype":"email", "payload":"", "dependsOn":[9]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReadyLen:   0,
+		},
+		{
+			name:               "invalid dependency",
+			body:               `{"jobtype":"email", "payload":"", "dependsOn":[0]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReadyLen:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "email", Status: enums.Completed},
+					2: {ID: 2, JobType: "email", Status: enums.Executing},
+					3: {ID: 3, JobType: "email", Status: enums.Failed},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.SubmitJob(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatusCode)
+			}
+			if len(s.readyQueue) != tt.expectedReadyLen {
+				t.Errorf("expected %d jobs in ready queue, got %d", tt.expectedReadyLen, len(s.readyQueue))
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Status enums.Status `json:"status"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if res.Data.Status != tt.expectedStatus {
+				t.Errorf("expected status %v, got %v", tt.expectedStatus, res.Data.Status)
+			}
+		})
+	}
+}
+
+func TestSubmitJobUniqueKeyWhileBlocked(t *testing.T) {
+	s := &MockScheduler{
+		jobs: map[int64]*jobs.Job{
+			1: {ID: 1, JobType: "report", Status: enums.Executing},
+		},
+	}
+	handler := api.NewHandler(s, worker.DefaultRegistry())
+	body := `{"jobtype":"report", "payload":"", "uniqueKey":"report-customer-7", "dependsOn":[1]}`
+
+	/*the blocked job holds the key till it runs, so the second submission can't take it*/
+	for _, expected := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.SubmitJob(rr, req)
+
+		if rr.Code != expected {
+			t.Fatalf("expected status %d, got %d: %s", expected, rr.Code, rr.Body.String())
+		}
+	}
+	if len(s.jobs) != 2 || s.jobs[2].Status != enums.Blocked {
+		t.Errorf("expected a single blocked job holding the key, got %d jobs", len(s.jobs))
+	}
+}
+
+func TestSubmitBatchHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedReadyLen   int
+		expectedWaitingLen int
+		expectedErrors     []int
+		expectedBatch      bool
+	}{
+		{
+			name:               "valid and invalid jobs",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}, {"jobtype":"sms", "payload":""}, {"jobtype":"report", "payload":"", "delay":5}, {"jobtype":"email", "payload":"", "queue":"nope"}]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedWaitingLen: 1,
+			expectedErrors:     []int{1, 3},
+			expectedBatch:      true,
+		},
+		{
+			name:               "webhook on complete",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}], "onComplete":{"url":"https://example.com/hook"}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedBatch:      true,
+		},
+		{
+			name:               "job on complete",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}], "onComplete":{"jobtype":"report", "payload":{}}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+			expectedBatch:      true,
+		},
+		{
+			name:               "on complete with job and webhook",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}], "onComplete":{"jobtype":"report", "url":"https://example.com/hook"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "on complete with invalid url",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}], "onComplete":{"url":"ftp://example.com"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "on complete with unknown jobtype",
+			body:               `{"jobs":[{"jobtype":"email", "payload":""}], "onComplete":{"jobtype":"sms"}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "every job invalid",
+			body:               `{"jobs":[{"jobtype":"sms", "payload":""}]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedErrors:     []int{0},
+		},
+		{
+			name:               "empty batch",
+			body:               `{"jobs":[]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "too many jobs",
+			body:               `{"jobs":[` + strings.Repeat(`{"jobtype":"email"},`, 10000) + `{"jobtype":"email"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid body",
+			body:               `[{"jobtype":"email"}]`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.SubmitBatch(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatusCode)
+			}
+			if len(s.readyQueue) != tt.expectedReadyLen {
+				t.Errorf("expected %d jobs in ready queue, got %d", tt.expectedReadyLen, len(s.readyQueue))
+			}
+			if len(s.waitingQueue) != tt.expectedWaitingLen {
+				t.Errorf("expected %d jobs in waiting queue, got %d", tt.expectedWaitingLen, len(s.waitingQueue))
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					BatchID *int64 `json:"batchID"`
+					Results []struct {
+						Index int    `json:"index"`
+						JobID int64  `json:"jobID"`
+						Error string `json:"error"`
+					} `json:"results"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if (res.Data.BatchID != nil) != tt.expectedBatch {
+				t.Errorf("expected batch %v, got batch ID %v", tt.expectedBatch, res.Data.BatchID)
+			}
+
+			var errs []int
+			for i, result := range res.Data.Results {
+				if result.Index != i {
+					t.Errorf("expected result %d to have index %d, got %d", i, i, result.Index)
+				}
+				if result.Error != "" {
+					errs = append(errs, i)
+				} else if result.JobID == 0 {
+					t.Errorf("expected job ID for result %d", i)
+				}
+			}
+			if len(errs) != len(tt.expectedErrors) {
+				t.Fatalf("expected errors at %v, got %v", tt.expectedErrors, errs)
+			}
+			for i := range errs {
+				if errs[i] != tt.expectedErrors[i] {
+					t.Errorf("expected errors at %v, got %v", tt.expectedErrors, errs)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetBatchHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name:               "existing batch",
+			id:                 "1",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "missing batch",
+			id:                 "2",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid batch id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				batches: map[int64]*jobs.Batch{
+					1: {ID: 1, Total: 3, Counts: map[enums.Status]int{enums.Completed: 2, enums.Pending: 1}},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v2/batches/{id}", handler.GetBatch)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/batches/"+tt.id, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatusCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data jobs.Batch `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if res.Data.Total != 3 || res.Data.Counts[enums.Completed] != 2 || res.Data.Counts[enums.Pending] != 1 {
+				t.Errorf("unexpected batch in response: %+v", res.Data)
+			}
+		})
+	}
+}
+
+/*
+Returns the statuses of the events in a Server-Sent Events body, in order
+*/
+func streamedStatuses(t *testing.T, body string) []enums.Status {
+	var statuses []enums.Status
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event jobs.JobEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("failed to decode event %q: %v", data, err)
+		}
+		statuses = append(statuses, event.Status)
+	}
+	return statuses
+}
+
+func TestJobEventsHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		events             []jobs.JobEvent
+		expectedStatusCode int
+		expectedStatuses   []enums.Status
+	}{
+		{
+			name: "streams transitions till the job finished",
+			id:   "1",
+			events: []jobs.JobEvent{
+				{JobID: 2, Status: enums.Completed},
+				{JobID: 1, Status: enums.Retrying, Attempt: 1},
+				{JobID: 1, Status: enums.Executing, Attempt: 1},
+				{JobID: 1, Status: enums.Failed, Attempt: 2},
+				{JobID: 1, Status: enums.Pending},
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []enums.Status{enums.Executing, enums.Retrying, enums.Executing, enums.Failed},
+		},
+		{
+			name:               "finished job only gets its status",
+			id:                 "3",
+			events:             []jobs.JobEvent{{JobID: 3, Status: enums.Pending}},
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []enums.Status{enums.Completed},
+		},
+		{
+			name:               "missing job",
+			id:                 "4",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid job id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "email", Status: enums.Executing},
+					3: {ID: 3, JobType: "email", Status: enums.Completed, Attempt: 1},
+				},
+				events: tt.events,
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v2/jobs/{id}/events", handler.JobEvents)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/jobs/"+tt.id+"/events", nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+				t.Errorf("expected an event stream, got %q", contentType)
+			}
+			statuses := streamedStatuses(t, rr.Body.String())
+			if strings.Join(toStrings(statuses), ",") != strings.Join(toStrings(tt.expectedStatuses), ",") {
+				t.Errorf("expected statuses %v, got %v", tt.expectedStatuses, statuses)
+			}
+		})
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	events := []jobs.JobEvent{
+		{JobID: 1, JobType: "email", Queue: "default", Status: enums.Executing},
+		{JobID: 1, JobType: "email", Queue: "default", Status: enums.Failed},
+		{JobID: 2, JobType: "http", Queue: "bulk", Status: enums.Failed},
+		{JobID: 3, JobType: "http", Queue: "default", Status: enums.Completed},
+	}
+	tests := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedStatuses   []enums.Status
+	}{
+		{
+			name:               "all events",
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []enums.Status{enums.Executing, enums.Failed, enums.Failed, enums.Completed},
+		},
+		{
+			name:               "failed jobs",
+			query:              "?status=failed",
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []enums.Status{enums.Failed, enums.Failed},
+		},
+		{
+			name:               "http jobs on the default queue",
+			query:              "?jobtype=http&queue=default",
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []enums.Status{enums.Completed},
+		},
+		{
+			name:               "invalid status",
+			query:              "?status=done",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{events: events}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/events"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			handler.Events(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+			statuses := streamedStatuses(t, rr.Body.String())
+			if strings.Join(toStrings(statuses), ",") != strings.Join(toStrings(tt.expectedStatuses), ",") {
+				t.Errorf("expected statuses %v, got %v", tt.expectedStatuses, statuses)
+			}
+		})
+	}
+}
+
+func toStrings(statuses []enums.Status) []string {
+	res := make([]string, len(statuses))
+	for i, status := range statuses {
+		res[i] = string(status)
+	}
+	return res
+}
+
+func TestGetJobHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name:               "existing job",
+			id:                 "1",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "missing job",
+			id:                 "2",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid job id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "email", Status: enums.Completed, Attempt: 1},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v2/jobs/{id}", handler.GetJob)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/jobs/"+tt.id, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data jobs.Job `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if res.Data.ID != 1 || res.Data.Status != enums.Completed {
+				t.Errorf("unexpected job in response: %+v", res.Data)
+			}
+		})
+	}
+}
+
+func TestGetJobAttemptsHandler(t *testing.T) {
+	errMsg := "request failed with status 503"
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedAttempts   int
+	}{
+		{
+			name:               "job with attempts",
+			id:                 "1",
+			expectedStatusCode: http.StatusOK,
+			expectedAttempts:   2,
+		},
+		{
+			name:               "job without attempts",
+			id:                 "2",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "missing job",
+			id:                 "3",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid job id",
+			id:                 "abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "http", Status: enums.Completed, Attempt: 2},
+					2: {ID: 2, JobType: "http", Status: enums.Pending},
+				},
+				attempts: map[int64][]jobs.JobAttempt{
+					1: {
+						{JobID: 1, Attempt: 1, Status: enums.Retrying, Error: &errMsg},
+						{JobID: 1, Attempt: 2, Status: enums.Completed},
+					},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v2/jobs/{id}/attempts", handler.GetJobAttempts)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/jobs/"+tt.id+"/attempts", nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Attempts []jobs.JobAttempt `json:"attempts"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(res.Data.Attempts) != tt.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.expectedAttempts, len(res.Data.Attempts))
+			}
+		})
+	}
+}
+
+func TestListJobsHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedLen        int
+		expectedCursor     *int64
+		expectedStatuses   []enums.Status
+	}{
+		{
+			name:               "first page with next cursor",
+			query:              "?limit=2",
+			expectedStatusCode: http.StatusOK,
+			expectedLen:        2,
+			expectedCursor:     func() *int64 { c := int64(2); return &c }(),
+		},
+		{
+			name:               "last page without next cursor",
+			query:              "?limit=2&cursor=2",
+			expectedStatusCode: http.StatusOK,
+			expectedLen:        1,
+		},
+		{
+			name:               "status filter is parsed",
+			query:              "?status=failed,retrying",
+			expectedStatusCode: http.StatusOK,
+			expectedLen:        3,
+			expectedStatuses:   []enums.Status{enums.Failed, enums.Retrying},
+		},
+		{
+			name:               "invalid status",
+			query:              "?status=unknown",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid batch id",
+			query:              "?batch_id=abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid time range",
+			query:              "?created_after=yesterday",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid limit",
+			query:              "?limit=0",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "email", Status: enums.Failed},
+					2: {ID: 2, JobType: "email", Status: enums.Retrying},
+					3: {ID: 3, JobType: "report", Status: enums.Failed},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/jobs"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(handler.ListJobs).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Jobs       []jobs.Job `json:"jobs"`
+					NextCursor *int64     `json:"nextCursor"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if len(res.Data.Jobs) != tt.expectedLen {
+				t.Errorf("expected %d jobs, got %d", tt.expectedLen, len(res.Data.Jobs))
+			}
+
+			if (res.Data.NextCursor == nil) != (tt.expectedCursor == nil) ||
+				(tt.expectedCursor != nil && *res.Data.NextCursor != *tt.expectedCursor) {
+				t.Errorf("expected next cursor %v, got %v", tt.expectedCursor, res.Data.NextCursor)
+			}
+
+			if len(s.filter.Statuses) != len(tt.expectedStatuses) {
+				t.Errorf("expected statuses %v, got %v", tt.expectedStatuses, s.filter.Statuses)
+			}
+		})
+	}
+}
+
+func TestCancelJobHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{
+			name:               "pending job is cancelled",
+			id:                 "1",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "retrying job is cancelled",
+			id:                 "2",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "executing job is cancelled",
+			id:                 "3",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "completed job can't be cancelled",
+			id:                 "4",
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "missing job",
+			id:                 "5",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, Status: enums.Pending},
+					2: {ID: 2, Status: enums.Retrying},
+					3: {ID: 3, Status: enums.Executing},
+					4: {ID: 4, Status: enums.Completed},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("DELETE /api/v2/jobs/{id}", handler.CancelJob)
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v2/jobs/"+tt.id, nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+		})
+	}
+}
+
+func TestCreateScheduleHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedSaved      int
+	}{
+		{
+			name:               "valid schedule",
+			body:               `{"cron":"*/5 * * * *", "timezone":"Asia/Kolkata", "jobtype":"email", "payload":{}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedSaved:      1,
+		},
+		{
+			name:               "timezone defaults to UTC",
+			body:               `{"cron":"@daily", "jobtype":"report", "payload":{}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedSaved:      1,
+		},
+		{
+			name:               "invalid cron expression",
+			body:               `{"cron":"every day", "jobtype":"email", "payload":{}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid timezone",
+			body:               `{"cron":"* * * * *", "timezone":"Mars/Olympus", "jobtype":"email", "payload":{}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "missing jobtype",
+			body:               `{"cron":"* * * * *", "payload":{}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "unknown jobtype",
+			body:               `{"cron":"* * * * *", "jobtype":"sms", "payload":{}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/schedules", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(handler.CreateSchedule).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+
+			if len(s.schedules) != tt.expectedSaved {
+				t.Fatalf("expected %d saved schedules, got %d", tt.expectedSaved, len(s.schedules))
+			}
+			if tt.expectedSaved > 0 && !s.schedules[0].NextRunAt.After(time.Now()) {
+				t.Errorf("expected next run in the future, got %v", s.schedules[0].NextRunAt)
+			}
+		})
+	}
+}
+
+func TestListDeadLetterHandler(t *testing.T) {
+	s := &MockScheduler{
+		jobs: map[int64]*jobs.Job{
+			1: {ID: 1, JobType: "http", Status: enums.Failed, Attempt: 3},
+			2: {ID: 2, JobType: "email", Status: enums.Completed, Attempt: 1},
+			3: {ID: 3, JobType: "http", Status: enums.Failed, Attempt: 3},
+		},
+		jobErrors: map[int64][]jobs.JobError{
+			1: {
+				{JobID: 1, Attempt: 1, Error: "request failed with status 503"},
+				{JobID: 1, Attempt: 2, Error: "request failed with status 503"},
+				{JobID: 1, Attempt: 3, Error: "request failed with status 502"},
+			},
+		},
+	}
+	handler := api.NewHandler(s, worker.DefaultRegistry())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/dead-letter?status=completed", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handler.ListDeadLetter).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var res struct {
+		Data struct {
+			Jobs []struct {
+				ID     int64           `json:"id"`
+				Status enums.Status    `json:"status"`
+				Errors []jobs.JobError `json:"errors"`
+			} `json:"jobs"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(res.Data.Jobs) != 2 {
+		t.Fatalf("expected 2 failed jobs, got %d", len(res.Data.Jobs))
+	}
+	for _, job := range res.Data.Jobs {
+		if job.Status != enums.Failed {
+			t.Errorf("expected only failed jobs, got %v", job.Status)
+		}
+	}
+	if len(res.Data.Jobs[0].Errors) != 3 {
+		t.Errorf("expected 3 errors in history of job 1, got %d", len(res.Data.Jobs[0].Errors))
+	}
+	if res.Data.Jobs[1].Errors == nil {
+		t.Errorf("expected empty error history to be an empty list")
+	}
+}
+
+func TestReplayJobHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedReadyLen   int
+	}{
+		{
+			name:               "failed job is replayed",
+			id:                 "1",
+			expectedStatusCode: http.StatusOK,
+			expectedReadyLen:   1,
+		},
+		{
+			name:               "completed job can't be replayed",
+			id:                 "2",
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "missing job",
+			id:                 "3",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, Status: enums.Failed, Attempt: 3},
+					2: {ID: 2, Status: enums.Completed, Attempt: 1},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/v2/jobs/{id}/replay", handler.ReplayJob)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs/"+tt.id+"/replay", nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatusCode)
+			}
+			if len(s.readyQueue) != tt.expectedReadyLen {
+				t.Errorf("expected %d job in ready queue, got %d", tt.expectedReadyLen, len(s.readyQueue))
+			}
+		})
+	}
+}
+
+func TestReplayDeadLetterHandler(t *testing.T) {
+	s := &MockScheduler{
+		jobs: map[int64]*jobs.Job{
+			1: {ID: 1, JobType: "http", Status: enums.Failed},
+			2: {ID: 2, JobType: "http", Status: enums.Completed},
+			3: {ID: 3, JobType: "http", Status: enums.Failed},
+		},
+	}
+	handler := api.NewHandler(s, worker.DefaultRegistry())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/dead-letter/replay?jobtype=http", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handler.ReplayDeadLetter).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if s.filter.JobType != "http" {
+		t.Errorf("expected jobtype filter to be applied, got %q", s.filter.JobType)
+	}
+	if len(s.readyQueue) != 2 {
+		t.Errorf("expected 2 replayed jobs, got %d", len(s.readyQueue))
+	}
+	if s.jobs[1].Status != enums.Pending || s.jobs[3].Status != enums.Pending {
+		t.Errorf("expected failed jobs to be pending again")
+	}
+}
+
+func TestReplayDeadLetterSkipsForbiddenJobTypes(t *testing.T) {
+	s := &MockScheduler{
+		jobs: map[int64]*jobs.Job{
+			1: {ID: 1, JobType: "email", Status: enums.Failed},
+			2: {ID: 2, JobType: "http", Status: enums.Failed},
+		},
+	}
+	handler := api.NewHandler(s, worker.DefaultRegistry())
+	handler.AdminKey = testAdminKey
+	key := createAPIKey(t, handler, `{"name":"ops", "scopes":["admin"], "jobTypes":["email"]}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/dead-letter/replay", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rr := httptest.NewRecorder()
+	handler.Auth(enums.AdminScope, handler.ReplayDeadLetter).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var res struct {
+		Data struct {
+			Replayed []int64          `json:"replayed"`
+			Failed   map[int64]string `json:"failed"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rr.Body).Decode(&res)
+
+	if len(res.Data.Replayed) != 1 || res.Data.Replayed[0] != 1 {
+		t.Errorf("expected only job 1 to be replayed, got %v", res.Data.Replayed)
+	}
+	if !strings.Contains(res.Data.Failed[2], "API key can't submit jobtype") {
+		t.Errorf("expected job 2 to fail with the job type check, got %v", res.Data.Failed)
+	}
+	if s.jobs[2].Status != enums.Failed {
+		t.Errorf("expected forbidden job to stay failed, got %s", s.jobs[2].Status)
+	}
+}
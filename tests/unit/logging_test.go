@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/logging"
+	"github.com/blueberry-adii/tickr/internal/worker"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name        string
+		level       string
+		format      string
+		expectError bool
+		expectDebug bool
+		expectJSON  bool
+	}{
+		{name: "defaults to info as json", expectJSON: true},
+		{name: "debug level", level: "debug", format: "json", expectDebug: true, expectJSON: true},
+		{name: "text format", level: "warn", format: "text"},
+		{name: "unknown level", level: "loud", expectError: true},
+		{name: "unknown format", format: "xml", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := logging.New(&buf, tt.level, tt.format)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			logger.Debug("debug line")
+			logger.Error("error line", "job_id", 7)
+			if got := strings.Contains(buf.String(), "debug line"); got != tt.expectDebug {
+				t.Errorf("expected debug line logged %v, got %q", tt.expectDebug, buf.String())
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			var line map[string]any
+			isJSON := json.Unmarshal([]byte(lines[len(lines)-1]), &line) == nil
+			if isJSON != tt.expectJSON {
+				t.Errorf("expected json %v, got %q", tt.expectJSON, buf.String())
+			}
+		})
+	}
+}
+
+/*
+Captures the lines logged by the default logger as JSON objects
+*/
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "debug", "json")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("expected json log line, got %q", raw)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestJobLogFields(t *testing.T) {
+	buf := captureLogs(t)
+
+	workerID := 3
+	job := &jobs.Job{ID: 12, Tenant: "payments", JobType: "email", Queue: "default", Attempt: 2, WorkerID: &workerID}
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	logging.Job(job).InfoContext(ctx, "success: attempt was successful")
+
+	line := logLines(t, buf)[0]
+	expected := map[string]any{
+		"job_id":     float64(12),
+		"tenant":     "payments",
+		"job_type":   "email",
+		"queue":      "default",
+		"attempt":    float64(2),
+		"worker_id":  float64(3),
+		"request_id": "req-1",
+		"level":      "INFO",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expected %v %v, got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "generated without header"},
+		{name: "client id echoed", header: "checkout-42", expected: "checkout-42"},
+		{name: "too long id replaced", header: strings.Repeat("a", 65)},
+		{name: "unprintable id replaced", header: "bad id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t)
+			repo := &MockRepository{}
+			sc, mr := newTestScheduler(t, repo)
+			defer mr.Close()
+
+			handler := api.NewHandler(sc, worker.DefaultRegistry())
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(`{"jobtype":"email","payload":{"to":"john@gmail.com"}}`))
+			if tt.header != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			api.Logging(handler.SubmitJob).ServeHTTP(rec, req)
+
+			requestID := rec.Header().Get(logging.RequestIDHeader)
+			if tt.expected != "" && requestID != tt.expected {
+				t.Errorf("expected request id %q, got %q", tt.expected, requestID)
+			}
+			if tt.expected == "" && len(requestID) != 32 {
+				t.Errorf("expected a generated request id, got %q", requestID)
+			}
+
+			if len(repo.saved) != 1 || repo.saved[0].RequestID == nil || *repo.saved[0].RequestID != requestID {
+				t.Fatalf("expected job saved with request id %q, got %+v", requestID, repo.saved)
+			}
+
+			lines := logLines(t, buf)
+			last := lines[len(lines)-1]
+			if last["msg"] != "request" || last["request_id"] != requestID || last["status"] != float64(http.StatusOK) {
+				t.Errorf("expected request line with request id %q, got %v", requestID, last)
+			}
+		})
+	}
+}
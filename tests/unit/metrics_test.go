@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/metrics"
+	"github.com/blueberry-adii/tickr/internal/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+Returns the label names of every metric in the family, sorted
+*/
+func labelNames(family *dto.MetricFamily) []string {
+	var names []string
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if !slices.Contains(names, label.GetName()) {
+				names = append(names, label.GetName())
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestMetricsNamesAndLabels(t *testing.T) {
+	ctx := context.Background()
+
+	/*records a recovery run, as the queue's redis state is missing on startup*/
+	repo := &MockRepository{}
+	sc, mr := newTestScheduler(t, repo)
+	runCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	sc.Run(runCtx)
+	cancel()
+
+	if _, err := sc.SaveJob(ctx, jobs.Job{JobType: "email", Queue: "default", Status: enums.Pending}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	mr.ZAdd("tickr:queue:default:waiting", float64(time.Now().Add(-time.Second).Unix()), `{"job_id":1,"scheduledAt":"`+time.Now().Add(-time.Second).Format(time.RFC3339)+`"}`)
+	if _, err := sc.PopWaitingQueue(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	d := &MockDispatcher{
+		ch:  make(chan *jobs.RedisJob, 1),
+		job: &jobs.Job{ID: 1, JobType: "email", Payload: []byte(`{"to":"john@gmail.com"}`), Status: enums.Pending, MaxAttempts: 3, ScheduledAt: time.Now()},
+	}
+	d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+	close(d.ch)
+	worker.NewWorker(1, "default", d, worker.DefaultRegistry()).Run(ctx)
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics %v", err)
+	}
+	gathered := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		gathered[family.GetName()] = family
+	}
+
+	tests := []struct {
+		name           string
+		metricType     dto.MetricType
+		expectedLabels []string
+	}{
+		{"tickr_jobs_submitted_total", dto.MetricType_COUNTER, []string{"jobtype", "queue"}},
+		{"tickr_job_executions_total", dto.MetricType_COUNTER, []string{"jobtype", "outcome"}},
+		{"tickr_job_execution_duration_seconds", dto.MetricType_HISTOGRAM, []string{"jobtype"}},
+		{"tickr_scheduler_promotion_lag_seconds", dto.MetricType_HISTOGRAM, []string{"queue"}},
+		{"tickr_workers", dto.MetricType_GAUGE, []string{"queue", "state"}},
+		{"tickr_recovery_runs_total", dto.MetricType_COUNTER, []string{"queue"}},
+		{"go_goroutines", dto.MetricType_GAUGE, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family, ok := gathered[tt.name]
+			if !ok {
+				t.Fatalf("metric %v not exported", tt.name)
+			}
+			if family.GetType() != tt.metricType {
+				t.Errorf("expected %v to be a %v, got %v", tt.name, tt.metricType, family.GetType())
+			}
+			if labels := labelNames(family); !slices.Equal(labels, tt.expectedLabels) {
+				t.Errorf("expected %v labels %v, got %v", tt.name, tt.expectedLabels, labels)
+			}
+		})
+	}
+
+	if v := testutil.ToFloat64(metrics.JobExecutions.WithLabelValues("email", "completed")); v < 1 {
+		t.Errorf("expected the completed execution to be counted, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.Workers.WithLabelValues("default", "busy")); v != 0 {
+		t.Errorf("expected no busy workers once the worker stopped, got %v", v)
+	}
+}
+
+func TestQueueDepthMetrics(t *testing.T) {
+	sc, mr := newTestScheduler(t, &MockRepository{}, "default", "bulk")
+	mr.ZAdd("tickr:queue:default:waiting", 1, "a")
+	mr.ZAdd("tickr:queue:default:waiting", 2, "b")
+	mr.Lpush("tickr:queue:default:ready:high", "c")
+	mr.Lpush("tickr:queue:bulk:ready:low", "d")
+	mr.Lpush("tickr:queue:bulk:ready:low", "e")
+	mr.SAdd("tickr:queue:bulk:tenants", "payments")
+	mr.Lpush("tickr:tenant:payments:queue:bulk:ready:normal", "f")
+	mr.ZAdd("tickr:tenant:payments:running", float64(time.Now().Add(time.Minute).UnixMilli()), "7")
+	mr.ZAdd("tickr:tenant:payments:running", 1, "8")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(sc.DepthCollector())
+
+	expected := `
+# HELP tickr_queue_ready_jobs Jobs in the ready queue of a priority.
+# TYPE tickr_queue_ready_jobs gauge
+tickr_queue_ready_jobs{priority="high",queue="bulk",tenant="default"} 0
+tickr_queue_ready_jobs{priority="high",queue="bulk",tenant="payments"} 0
+tickr_queue_ready_jobs{priority="high",queue="default",tenant="default"} 1
+tickr_queue_ready_jobs{priority="low",queue="bulk",tenant="default"} 2
+tickr_queue_ready_jobs{priority="low",queue="bulk",tenant="payments"} 0
+tickr_queue_ready_jobs{priority="low",queue="default",tenant="default"} 0
+tickr_queue_ready_jobs{priority="normal",queue="bulk",tenant="default"} 0
+tickr_queue_ready_jobs{priority="normal",queue="bulk",tenant="payments"} 1
+tickr_queue_ready_jobs{priority="normal",queue="default",tenant="default"} 0
+# HELP tickr_queue_waiting_jobs Jobs in the waiting queue, delayed or due for a retry.
+# TYPE tickr_queue_waiting_jobs gauge
+tickr_queue_waiting_jobs{queue="bulk",tenant="default"} 0
+tickr_queue_waiting_jobs{queue="bulk",tenant="payments"} 0
+tickr_queue_waiting_jobs{queue="default",tenant="default"} 2
+# HELP tickr_tenant_running_jobs Jobs of the tenant holding a slot of its concurrency quota.
+# TYPE tickr_tenant_running_jobs gauge
+tickr_tenant_running_jobs{tenant="default"} 0
+tickr_tenant_running_jobs{tenant="payments"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics.JobsSubmitted.WithLabelValues("report", "default").Inc()
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected prometheus text format, got %v %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `tickr_jobs_submitted_total{jobtype="report",queue="default"}`) {
+		t.Errorf("expected submissions in the scraped metrics, got %s", body)
+	}
+}
+
+func TestMetricsNeedOperatorKey(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		expectedStatusCode int
+	}{
+		{name: "missing key", expectedStatusCode: http.StatusUnauthorized},
+		{name: "read key", key: "read", expectedStatusCode: http.StatusForbidden},
+		{name: "admin key of another tenant", key: "acme", expectedStatusCode: http.StatusForbidden},
+		{name: "operator key", key: "admin", expectedStatusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := api.NewHandler(&MockScheduler{}, worker.DefaultRegistry())
+			handler.AdminKey = testAdminKey
+			keys := map[string]string{
+				"admin": testAdminKey,
+				"read":  createAPIKey(t, handler, `{"name":"dashboard", "scopes":["read"]}`),
+				"acme":  createAPIKey(t, handler, `{"tenant":"acme", "name":"acme admin", "scopes":["admin"]}`),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+keys[tt.key])
+			}
+			rr := httptest.NewRecorder()
+			handler.Auth(enums.AdminScope, handler.Metrics).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "go_goroutines") {
+				t.Errorf("expected the scraped metrics, got %s", rr.Body.String())
+			}
+		})
+	}
+}
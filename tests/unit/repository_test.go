@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+Returns a repository on a mocked connection, the expectations are checked when the test ends
+*/
+func newMockRepository(t *testing.T) (*database.MySQLRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return database.NewMySQLRepository(db), mock
+}
+
+func TestListJobsQuery(t *testing.T) {
+	workerID := 3
+	created := time.Date(2026, 1, 12, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		filter        database.JobFilter
+		expectedQuery string
+		expectedArgs  []any
+	}{
+		{
+			name:          "no filters",
+			filter:        database.JobFilter{Limit: 50},
+			expectedQuery: "FROM jobs ORDER BY id LIMIT ?",
+			expectedArgs:  []any{50},
+		},
+		{
+			name: "tenant, statuses and cursor",
+			filter: database.JobFilter{
+				Tenant:   "acme",
+				Statuses: []enums.Status{enums.Failed, enums.Cancelled},
+				AfterID:  120,
+				Limit:    2,
+			},
+			expectedQuery: "FROM jobs WHERE tenant = ? AND status IN (?, ?) AND id > ? ORDER BY id LIMIT ?",
+			expectedArgs:  []any{"acme", "failed", "cancelled", 120, 2},
+		},
+		{
+			name: "job type, queue, worker and time range",
+			filter: database.JobFilter{
+				JobType:       "email",
+				Queue:         "bulk",
+				WorkerID:      &workerID,
+				CreatedAfter:  &created,
+				CreatedBefore: &created,
+				Limit:         10,
+			},
+			expectedQuery: "FROM jobs WHERE job_type = ? AND queue = ? AND worker_id = ? AND created_at >= ? AND created_at < ? ORDER BY id LIMIT ?",
+			expectedArgs:  []any{"email", "bulk", workerID, created, created, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+
+			mock.ExpectQuery(regexp.QuoteMeta(tt.expectedQuery)).
+				WithArgs(queryArgs(tt.expectedArgs)...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			if _, err := repo.ListJobs(context.Background(), tt.filter); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+/*
+Matches query arguments as the driver receives them, ints as int64
+*/
+func queryArgs(args []any) []driver.Value {
+	res := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if n, ok := arg.(int); ok {
+			arg = int64(n)
+		}
+		res[i] = arg
+	}
+	return res
+}
+
+func TestSaveJobMapsDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		expectedErr error
+	}{
+		{
+			name:        "idempotency key, MySQL 5.7",
+			message:     "Duplicate entry 'default-abc' for key 'uq_idempotency_key'",
+			expectedErr: database.ErrIdempotencyKeyUsed,
+		},
+		{
+			name:        "active unique key, MySQL 8",
+			message:     "Duplicate entry 'default-invoice-7' for key 'jobs.uq_active_unique_key'",
+			expectedErr: database.ErrUniqueKeyActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO jobs")).
+				WillReturnError(&mysql.MySQLError{Number: 1062, Message: tt.message})
+
+			if _, err := repo.SaveJob(context.Background(), jobs.Job{JobType: "email"}); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestSaveDependentJobLocksParentsOfTenant(t *testing.T) {
+	lockParents := regexp.QuoteMeta("SELECT id, status FROM jobs WHERE tenant = ? AND id IN (?, ?) FOR UPDATE")
+
+	t.Run("blocked on an executing parent", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockParents).
+			WithArgs("acme", 4, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, "completed").AddRow(5, "executing"))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO jobs")).WillReturnResult(sqlmock.NewResult(9, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO job_dependencies")).WithArgs(9, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO job_dependencies")).WithArgs(9, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		id, err := repo.SaveJob(context.Background(), jobs.Job{Tenant: "acme", JobType: "email", Status: enums.Pending, DependsOn: []int64{4, 5}})
+		if err != nil || id != 9 {
+			t.Errorf("expected job 9 to be saved, got %d %v", id, err)
+		}
+	})
+
+	t.Run("parent of another tenant", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockParents).
+			WithArgs("acme", 4, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, "completed"))
+		mock.ExpectRollback()
+
+		if _, err := repo.SaveJob(context.Background(), jobs.Job{Tenant: "acme", JobType: "email", DependsOn: []int64{4, 5}}); !errors.Is(err, database.ErrDependencyNotFound) {
+			t.Errorf("expected %v, got %v", database.ErrDependencyNotFound, err)
+		}
+	})
+}
+
+func TestExtendLeaseChecksToken(t *testing.T) {
+	leasedUntil := time.Now().Add(jobs.LeaseDuration)
+	extend := regexp.QuoteMeta("UPDATE jobs SET leased_until = ? WHERE id = ? AND status = ? AND lease_token = ?")
+	held := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM jobs WHERE id = ? AND status = ? AND lease_token = ?)")
+
+	tests := []struct {
+		name        string
+		affected    int64
+		held        bool
+		expectedErr error
+	}{
+		{name: "renewed", affected: 1},
+		{name: "renewed twice within a second", affected: 0, held: true},
+		{name: "claimed by another token", affected: 0, held: false, expectedErr: database.ErrLeaseLost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectExec(extend).
+				WithArgs(sqlmock.AnyArg(), 7, "executing", "token").
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.affected == 0 {
+				mock.ExpectQuery(held).
+					WithArgs(7, "executing", "token").
+					WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(tt.held))
+			}
+
+			if err := repo.ExtendLease(context.Background(), 7, "token", leasedUntil); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
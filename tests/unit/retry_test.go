@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/worker"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   jobs.RetryPolicy
+		attempt  int
+		expected time.Duration
+	}{
+		{
+			name:     "default policy is linear",
+			policy:   jobs.DefaultRetryPolicy,
+			attempt:  2,
+			expected: 20 * time.Second,
+		},
+		{
+			name:     "fixed",
+			policy:   jobs.RetryPolicy{Strategy: enums.Fixed, Base: 5},
+			attempt:  4,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "exponential",
+			policy:   jobs.RetryPolicy{Strategy: enums.Exponential, Base: 2},
+			attempt:  4,
+			expected: 16 * time.Second,
+		},
+		{
+			name:     "exponential limited by cap",
+			policy:   jobs.RetryPolicy{Strategy: enums.Exponential, Base: 2, Cap: 60},
+			attempt:  10,
+			expected: 60 * time.Second,
+		},
+		{
+			name:     "large attempts don't overflow",
+			policy:   jobs.RetryPolicy{Strategy: enums.Exponential, Base: 1, Cap: 3600},
+			attempt:  500,
+			expected: time.Hour,
+		},
+		{
+			name:     "overflowing exponential is limited to the max delay",
+			policy:   jobs.RetryPolicy{Strategy: enums.Exponential, Base: 60},
+			attempt:  31,
+			expected: jobs.MaxRetryDelay,
+		},
+		{
+			name:     "overflowing base is limited to the max delay",
+			policy:   jobs.RetryPolicy{Strategy: enums.Linear, Base: 1 << 40},
+			attempt:  1,
+			expected: jobs.MaxRetryDelay,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := worker.RetryDelay(tt.policy, tt.attempt); delay != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, delay)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	policy := jobs.RetryPolicy{Strategy: enums.Fixed, Base: 100, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		delay := worker.RetryDelay(policy, 1)
+		if delay < 50*time.Second || delay > 100*time.Second {
+			t.Fatalf("expected delay between 50s and 100s, got %v", delay)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	httpOnly := jobs.RetryPolicy{
+		Strategy: enums.Linear,
+		Base:     10,
+		RetryOn:  []enums.ErrorClass{enums.ServerError, enums.NetworkError},
+	}
+
+	tests := []struct {
+		name     string
+		policy   jobs.RetryPolicy
+		err      error
+		expected bool
+	}{
+		{
+			name:     "empty retryOn fails right away on 4xx",
+			policy:   jobs.DefaultRetryPolicy,
+			err:      &worker.HTTPStatusError{StatusCode: 404},
+			expected: false,
+		},
+		{
+			name:     "empty retryOn retries other errors",
+			policy:   jobs.DefaultRetryPolicy,
+			err:      &worker.HTTPStatusError{StatusCode: 503},
+			expected: true,
+		},
+		{
+			name:     "5xx is retried",
+			policy:   httpOnly,
+			err:      &worker.HTTPStatusError{StatusCode: 503},
+			expected: true,
+		},
+		{
+			name:     "4xx fails right away",
+			policy:   httpOnly,
+			err:      &worker.HTTPStatusError{StatusCode: 404},
+			expected: false,
+		},
+		{
+			name:     "network error is retried",
+			policy:   httpOnly,
+			err:      &url.Error{Op: "Get", URL: "http://localhost", Err: errors.New("connection refused")},
+			expected: true,
+		},
+		{
+			name:     "timeout is not in retryOn",
+			policy:   httpOnly,
+			err:      fmt.Errorf("%w: job exceeded its 1s limit", worker.ErrJobTimeout),
+			expected: false,
+		},
+		{
+			name:     "permanent errors are never retried",
+			policy:   jobs.DefaultRetryPolicy,
+			err:      worker.Permanent(errors.New("invalid payload")),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if retry := worker.ShouldRetry(tt.policy, tt.err); retry != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, retry)
+			}
+		})
+	}
+}
@@ -2,26 +2,71 @@ package tests
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/blueberry-adii/tickr/internal/database"
+	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 	"github.com/blueberry-adii/tickr/internal/scheduler"
+	"github.com/blueberry-adii/tickr/internal/worker"
 )
 
 type MockRepository struct {
-	pending []jobs.RedisJob
+	pending   []jobs.RedisJob
+	schedules []jobs.Schedule
+	fired     []jobs.Job
+	expired   []jobs.Job
+	released  []jobs.Job
+
+	unblocked map[int64][]jobs.Job
+	children  map[int64][]int64
+	cancelled []int64
+	finished  []jobs.Job
+
+	completable     map[int64]*jobs.Job
+	completed       []int64
+	finishedBatches []int64
+
+	saved    []jobs.Job
+	attempts []jobs.JobAttempt
+	tenants  []jobs.Tenant
+
+	claimable   bool
+	leaseTokens []string
+
+	fireErr   map[int64]error
+	fireCalls map[int64]int
 }
 
 var _ database.Repository = &MockRepository{}
 
 func (r *MockRepository) SaveJob(ctx context.Context, job jobs.Job) (int64, error) {
-	return 0, nil
+	for _, saved := range r.saved {
+		if job.IdempotencyKey != nil && saved.IdempotencyKey != nil && *job.IdempotencyKey == *saved.IdempotencyKey {
+			return 0, database.ErrIdempotencyKeyUsed
+		}
+	}
+	r.saved = append(r.saved, job)
+	return int64(1000 + len(r.saved)), nil
 }
 
 func (r *MockRepository) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
+	return &jobs.Job{ID: jobID, Priority: enums.Normal}, nil
+}
+
+func (r *MockRepository) ListJobs(ctx context.Context, filter database.JobFilter) ([]jobs.Job, error) {
 	return nil, nil
 }
 
@@ -29,18 +74,225 @@ func (r *MockRepository) UpdateJob(ctx context.Context, job *jobs.Job) error {
 	return nil
 }
 
-func (r *MockRepository) GetPendingJobs(ctx context.Context) ([]jobs.RedisJob, error) {
-	return r.pending, nil
+func (r *MockRepository) CancelJob(ctx context.Context, jobID int64) error {
+	return nil
+}
+
+func (r *MockRepository) SaveJobError(ctx context.Context, jobError jobs.JobError) error {
+	return nil
+}
+
+func (r *MockRepository) SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error {
+	r.attempts = append(r.attempts, attempt)
+	return nil
+}
+
+func (r *MockRepository) GetJobAttempts(ctx context.Context, jobID int64) ([]jobs.JobAttempt, error) {
+	return r.attempts, nil
+}
+
+func (r *MockRepository) GetJobErrors(ctx context.Context, jobIDs []int64) (map[int64][]jobs.JobError, error) {
+	return nil, nil
+}
+
+func (r *MockRepository) ReplayJob(ctx context.Context, jobID int64, now time.Time) error {
+	return nil
+}
+
+func (r *MockRepository) GetJobByIdempotencyKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	return nil, database.ErrJobNotFound
+}
+
+func (r *MockRepository) ReleaseIdempotencyKey(ctx context.Context, jobID int64) error {
+	return nil
+}
+
+func (r *MockRepository) GetActiveJobByUniqueKey(ctx context.Context, tenant string, key string) (*jobs.Job, error) {
+	return nil, database.ErrJobNotFound
+}
+
+func (r *MockRepository) SaveBatch(ctx context.Context, batch jobs.Batch, batchJobs []jobs.Job) (int64, []int64, error) {
+	return 0, nil, nil
+}
+
+func (r *MockRepository) CompleteBatch(ctx context.Context, batchID int64, now time.Time) (*jobs.Job, error) {
+	job, ok := r.completable[batchID]
+	if !ok {
+		return nil, database.ErrBatchNotComplete
+	}
+	delete(r.completable, batchID)
+	r.completed = append(r.completed, batchID)
+	return job, nil
+}
+
+func (r *MockRepository) GetFinishedBatches(ctx context.Context) ([]int64, error) {
+	finished := r.finishedBatches
+	r.finishedBatches = nil
+	return finished, nil
+}
+
+func (r *MockRepository) GetBatch(ctx context.Context, batchID int64) (*jobs.Batch, error) {
+	return nil, database.ErrBatchNotFound
+}
+
+func (r *MockRepository) ReleaseDependents(ctx context.Context, jobID int64) ([]jobs.Job, error) {
+	released := r.unblocked[jobID]
+	delete(r.unblocked, jobID)
+	return released, nil
+}
+
+func (r *MockRepository) CancelDependents(ctx context.Context, jobID int64, reason string, now time.Time) ([]int64, error) {
+	cancelled := r.children[jobID]
+	delete(r.children, jobID)
+	r.cancelled = append(r.cancelled, cancelled...)
+	return cancelled, nil
+}
+
+func (r *MockRepository) GetFinishedParents(ctx context.Context) ([]jobs.Job, error) {
+	finished := r.finished
+	r.finished = nil
+	return finished, nil
+}
+
+func (r *MockRepository) ClaimJob(ctx context.Context, jobID int64, workerID int, leaseToken string, now time.Time, leasedUntil time.Time) (*jobs.Job, error) {
+	r.leaseTokens = append(r.leaseTokens, leaseToken)
+	if !r.claimable {
+		return nil, database.ErrJobNotClaimable
+	}
+	return &jobs.Job{ID: jobID, Status: enums.Executing, WorkerID: &workerID, LeaseToken: leaseToken}, nil
+}
+
+func (r *MockRepository) ExtendLease(ctx context.Context, jobID int64, leaseToken string, leasedUntil time.Time) error {
+	if len(r.leaseTokens) == 0 || leaseToken != r.leaseTokens[len(r.leaseTokens)-1] {
+		return database.ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *MockRepository) GetExpiredLeases(ctx context.Context, now time.Time) ([]jobs.Job, error) {
+	return r.expired, nil
+}
+
+func (r *MockRepository) ReleaseExpiredLease(ctx context.Context, job *jobs.Job, leasedUntil time.Time) (bool, error) {
+	r.released = append(r.released, *job)
+	r.expired = nil
+	return true, nil
+}
+
+func (r *MockRepository) GetPendingJobs(ctx context.Context, queue string) ([]jobs.RedisJob, error) {
+	var pending []jobs.RedisJob
+	for _, job := range r.pending {
+		if job.Queue == queue || (job.Queue == "" && queue == jobs.DefaultQueue) {
+			pending = append(pending, job)
+		}
+	}
+	return pending, nil
+}
+
+func (r *MockRepository) SaveSchedule(ctx context.Context, schedule jobs.Schedule) (int64, error) {
+	schedule.ID = int64(len(r.schedules) + 1)
+	r.schedules = append(r.schedules, schedule)
+	return schedule.ID, nil
+}
+
+func (r *MockRepository) GetSchedule(ctx context.Context, scheduleID int64) (*jobs.Schedule, error) {
+	for i := range r.schedules {
+		if r.schedules[i].ID == scheduleID {
+			return &r.schedules[i], nil
+		}
+	}
+	return nil, database.ErrScheduleNotFound
+}
+
+func (r *MockRepository) ListSchedules(ctx context.Context, tenant string) ([]jobs.Schedule, error) {
+	return r.schedules, nil
+}
+
+func (r *MockRepository) DeleteSchedule(ctx context.Context, scheduleID int64) error {
+	return nil
+}
+
+func (r *MockRepository) GetDueSchedules(ctx context.Context, now time.Time) ([]jobs.Schedule, error) {
+	var due []jobs.Schedule
+	for _, schedule := range r.schedules {
+		if !schedule.NextRunAt.After(now) {
+			due = append(due, schedule)
+		}
+	}
+	return due, nil
+}
+
+func (r *MockRepository) NextScheduleTime(ctx context.Context) (*time.Time, error) {
+	var next *time.Time
+	for i := range r.schedules {
+		if next == nil || r.schedules[i].NextRunAt.Before(*next) {
+			next = &r.schedules[i].NextRunAt
+		}
+	}
+	return next, nil
+}
+
+func (r *MockRepository) FireSchedule(ctx context.Context, schedule jobs.Schedule, job jobs.Job, next time.Time) (int64, error) {
+	if r.fireCalls == nil {
+		r.fireCalls = map[int64]int{}
+	}
+	r.fireCalls[schedule.ID]++
+	if err := r.fireErr[schedule.ID]; err != nil {
+		return 0, err
+	}
+	for i := range r.schedules {
+		if r.schedules[i].ID == schedule.ID && r.schedules[i].NextRunAt.Equal(schedule.NextRunAt) {
+			r.schedules[i].NextRunAt = next
+			job.ScheduleID = &schedule.ID
+			r.fired = append(r.fired, job)
+			return int64(len(r.fired)), nil
+		}
+	}
+	return 0, database.ErrScheduleAlreadyFired
+}
+
+func (r *MockRepository) DeferSchedule(ctx context.Context, schedule jobs.Schedule, retryAt time.Time) error {
+	for i := range r.schedules {
+		if r.schedules[i].ID == schedule.ID && r.schedules[i].NextRunAt.Equal(schedule.NextRunAt) {
+			r.schedules[i].NextRunAt = retryAt
+		}
+	}
+	return nil
+}
+
+func (r *MockRepository) SaveAPIKey(ctx context.Context, key jobs.APIKey) (int64, error) {
+	return 0, nil
+}
+
+func (r *MockRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*jobs.APIKey, error) {
+	return nil, database.ErrAPIKeyNotFound
+}
+
+func (r *MockRepository) ListAPIKeys(ctx context.Context, tenant string) ([]jobs.APIKey, error) {
+	return nil, nil
+}
+
+func (r *MockRepository) RevokeAPIKey(ctx context.Context, keyID int64, tenant string, now time.Time) error {
+	return database.ErrAPIKeyNotFound
+}
+
+func (r *MockRepository) SaveTenant(ctx context.Context, tenant jobs.Tenant) error {
+	r.tenants = append(r.tenants, tenant)
+	return nil
+}
+
+func (r *MockRepository) ListTenants(ctx context.Context) ([]jobs.Tenant, error) {
+	return r.tenants, nil
 }
 
-func newTestScheduler(t *testing.T, repo database.Repository) (*scheduler.Scheduler, *miniredis.Miniredis) {
+func newTestScheduler(t *testing.T, repo database.Repository, queueNames ...string) (*scheduler.Scheduler, *miniredis.Miniredis) {
 	mr, err := miniredis.Run()
 	if err != nil {
 		t.Fatalf("failed to start miniredis %v", err)
 	}
 
 	r := scheduler.NewRedis(mr.Addr())
-	return scheduler.NewScheduler(r, repo), mr
+	return scheduler.NewScheduler(r, repo, queueNames...), mr
 }
 
 func TestPopWaitingQueue(t *testing.T) {
@@ -100,6 +352,59 @@ func TestPopWaitingQueue(t *testing.T) {
 	}
 }
 
+func TestPopWaitingQueuePromotesOnceAcrossSchedulers(t *testing.T) {
+	ctx := context.Background()
+	first, mr := newTestScheduler(t, &MockRepository{})
+	second := scheduler.NewScheduler(scheduler.NewRedis(mr.Addr()), &MockRepository{})
+
+	const total = 200
+	for i := 1; i <= total; i++ {
+		first.PushWaitingQueue(ctx, &jobs.RedisJob{JobID: int64(i), ScheduledAt: time.Now().Add(-time.Second)})
+	}
+
+	promoted := make(chan []*jobs.RedisJob)
+	for _, sc := range []*scheduler.Scheduler{first, second} {
+		go func() {
+			var all []*jobs.RedisJob
+			for i := 0; i < 10; i++ {
+				jobs, err := sc.PopWaitingQueue(ctx)
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				all = append(all, jobs...)
+			}
+			promoted <- all
+		}()
+	}
+
+	seen := make(map[int64]int)
+	for range 2 {
+		for _, job := range <-promoted {
+			seen[job.JobID]++
+		}
+	}
+
+	if len(seen) != total {
+		t.Errorf("expected %d jobs promoted, got %d", total, len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("expected job %d to be promoted once, got %d", id, n)
+		}
+	}
+
+	ready, err := mr.List("tickr:queue:default:ready:normal")
+	if err != nil {
+		t.Fatalf("unexpected error reading ready queue: %v", err)
+	}
+	if len(ready) != total {
+		t.Errorf("expected %d jobs in ready queue, got %d", total, len(ready))
+	}
+	if mr.Exists("tickr:queue:default:waiting") {
+		t.Errorf("expected waiting queue to be empty")
+	}
+}
+
 func TestSchedulerRecovery(t *testing.T) {
 	pendingJobs := []jobs.RedisJob{
 		{JobID: 10, ScheduledAt: time.Now().Add(time.Hour)},
@@ -114,11 +419,11 @@ func TestSchedulerRecovery(t *testing.T) {
 	defer cancel()
 	sc.Run(ctx)
 
-	if !mr.Exists("tickr:redis:epoch") {
+	if !mr.Exists("tickr:queue:default:epoch") {
 		t.Errorf("expected epoch key to be set after recovery, but it was missing")
 	}
 
-	members, err := mr.ZMembers("tickr:queue:waiting")
+	members, err := mr.ZMembers("tickr:queue:default:waiting")
 	if err != nil {
 		t.Fatalf("unexpected error reading waiting queue: %v", err)
 	}
@@ -127,3 +432,754 @@ func TestSchedulerRecovery(t *testing.T) {
 		t.Errorf("expected %d jobs in waiting queue, got %d", len(pendingJobs), len(members))
 	}
 }
+
+func TestCancelJobRemovesQueuedEntries(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{})
+
+	sc.PushWaitingQueue(ctx, &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now().Add(time.Hour)})
+	sc.PushWaitingQueue(ctx, &jobs.RedisJob{JobID: 2, ScheduledAt: time.Now().Add(time.Hour)})
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()})
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 3, ScheduledAt: time.Now()})
+
+	if err := sc.CancelJob(ctx, 1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	waiting, err := mr.ZMembers("tickr:queue:default:waiting")
+	if err != nil {
+		t.Fatalf("unexpected error reading waiting queue: %v", err)
+	}
+	if len(waiting) != 1 {
+		t.Errorf("expected 1 job left in waiting queue, got %d", len(waiting))
+	}
+
+	ready, err := mr.List("tickr:queue:default:ready:normal")
+	if err != nil {
+		t.Fatalf("unexpected error reading ready queue: %v", err)
+	}
+	if len(ready) != 1 {
+		t.Errorf("expected 1 job left in ready queue, got %d", len(ready))
+	}
+	if mr.Exists("tickr:job:1:queued") {
+		t.Errorf("expected the queue entries of the cancelled job to be dropped")
+	}
+}
+
+func TestCancelJobRemovesEntriesOfTenantQueue(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{}, "default", "bulk")
+
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now(), Queue: "bulk", Priority: enums.High, Tenant: "acme"})
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 2, ScheduledAt: time.Now(), Queue: "bulk", Priority: enums.High, Tenant: "acme"})
+
+	if err := sc.CancelJob(ctx, 1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ready, err := mr.List("tickr:tenant:acme:queue:bulk:ready:high")
+	if err != nil {
+		t.Fatalf("unexpected error reading ready queue: %v", err)
+	}
+	if len(ready) != 1 || !strings.Contains(ready[0], `"job_id":2`) {
+		t.Errorf("expected only job 2 left in the ready queue, got %v", ready)
+	}
+}
+
+func TestSchedulerFiresDueSchedulesOnce(t *testing.T) {
+	tick := time.Now().Add(-30 * time.Second).Truncate(time.Minute)
+	repo := &MockRepository{
+		schedules: []jobs.Schedule{
+			{ID: 1, CronExpr: "* * * * *", Timezone: "UTC", JobType: "email", NextRunAt: tick},
+			{ID: 2, CronExpr: "0 0 1 1 *", Timezone: "UTC", JobType: "report", NextRunAt: time.Now().Add(time.Hour)},
+		},
+	}
+
+	sc, _ := newTestScheduler(t, repo)
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range sc.Jobs("default") {
+			n++
+		}
+		received <- n
+	}()
+
+	/*a stale copy of the schedule, as seen by a second scheduler instance*/
+	stale := repo.schedules[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	if len(repo.fired) != 1 {
+		t.Fatalf("expected 1 job to be created, got %d", len(repo.fired))
+	}
+	if repo.fired[0].JobType != "email" || !repo.fired[0].ScheduledAt.Equal(tick) {
+		t.Errorf("unexpected job created: %+v", repo.fired[0])
+	}
+	if !repo.schedules[0].NextRunAt.After(time.Now()) {
+		t.Errorf("expected schedule to advance past now, got %v", repo.schedules[0].NextRunAt)
+	}
+
+	if _, err := repo.FireSchedule(context.Background(), stale, jobs.Job{}, time.Now()); err != database.ErrScheduleAlreadyFired {
+		t.Errorf("expected occurrence to be fired only once, got %v", err)
+	}
+
+	if n := <-received; n != 1 {
+		t.Errorf("expected 1 job handed to workers, got %d", n)
+	}
+}
+
+func TestSchedulerBacksOffWhenFiringFails(t *testing.T) {
+	repo := &MockRepository{
+		schedules: []jobs.Schedule{
+			{ID: 1, CronExpr: "* * * * *", Timezone: "UTC", JobType: "email", NextRunAt: time.Now().Add(-time.Minute)},
+			{ID: 2, CronExpr: "* * * * *", Timezone: "UTC", JobType: "report", NextRunAt: time.Now().Add(50 * time.Millisecond)},
+		},
+		fireErr: map[int64]error{1: errors.New("deadlock found")},
+	}
+
+	sc, _ := newTestScheduler(t, repo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	if repo.fireCalls[1] != 1 {
+		t.Errorf("expected 1 attempt to fire the failing schedule before backing off, got %d", repo.fireCalls[1])
+	}
+	if !repo.schedules[0].NextRunAt.After(time.Now()) {
+		t.Errorf("expected failing schedule to be deferred, got %v", repo.schedules[0].NextRunAt)
+	}
+	if len(repo.fired) != 1 || repo.fired[0].JobType != "report" {
+		t.Errorf("expected the healthy schedule to fire on time, got %+v", repo.fired)
+	}
+}
+
+func TestCancelJobStopsRunningJob(t *testing.T) {
+	ctx := context.Background()
+	sc, _ := newTestScheduler(t, &MockRepository{})
+
+	jobCtx, done := sc.WatchCancel(ctx, 7)
+	defer done()
+
+	if err := sc.CancelJob(ctx, 7); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	select {
+	case <-jobCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected running job context to be cancelled")
+	}
+
+	if cause := context.Cause(jobCtx); cause != jobs.ErrCancelled {
+		t.Errorf("expected cause %v, got %v", jobs.ErrCancelled, cause)
+	}
+}
+
+func TestReplayJobPushesReadyQueue(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{})
+
+	if err := sc.ReplayJob(ctx, 4); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ready, err := mr.List("tickr:queue:default:ready:normal")
+	if err != nil {
+		t.Fatalf("unexpected error reading ready queue: %v", err)
+	}
+	if len(ready) != 1 {
+		t.Errorf("expected replayed job in ready queue, got %d jobs", len(ready))
+	}
+}
+
+func TestSchedulerReapsExpiredLeases(t *testing.T) {
+	leasedUntil := time.Now().Add(-time.Minute)
+	workerID := 2
+	repo := &MockRepository{
+		expired: []jobs.Job{
+			{ID: 1, Status: enums.Executing, Attempt: 0, MaxAttempts: 3, WorkerID: &workerID, LeasedUntil: &leasedUntil},
+			{ID: 2, Status: enums.Executing, Attempt: 2, MaxAttempts: 3, WorkerID: &workerID, LeasedUntil: &leasedUntil},
+		},
+	}
+	sc, mr := newTestScheduler(t, repo)
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	if len(repo.released) != 2 {
+		t.Fatalf("expected 2 expired leases to be released, got %d", len(repo.released))
+	}
+
+	expected := []struct {
+		status  enums.Status
+		attempt int
+	}{
+		{enums.Retrying, 1},
+		{enums.Failed, 3},
+	}
+	for i, tt := range expected {
+		job := repo.released[i]
+		if job.Status != tt.status || job.Attempt != tt.attempt {
+			t.Errorf("job %d: expected %v with attempt %d, got %v with attempt %d", job.ID, tt.status, tt.attempt, job.Status, job.Attempt)
+		}
+		if job.LastError == nil {
+			t.Errorf("job %d: expected lease expiry to be recorded as last error", job.ID)
+		}
+	}
+
+	if len(repo.attempts) != 2 {
+		t.Fatalf("expected the lost executions to be recorded as attempts, got %d", len(repo.attempts))
+	}
+	if repo.attempts[0].NextRetryAt == nil || repo.attempts[1].NextRetryAt != nil || *repo.attempts[0].WorkerID != workerID {
+		t.Errorf("unexpected attempts %+v", repo.attempts)
+	}
+
+	/*the retrying job is due right away, so it may already be promoted to the ready queue*/
+	waiting, _ := mr.ZMembers("tickr:queue:default:waiting")
+	ready, _ := mr.List("tickr:queue:default:ready:normal")
+	if len(waiting)+len(ready) != 1 {
+		t.Errorf("expected only the retrying job to be queued, got %d waiting and %d ready", len(waiting), len(ready))
+	}
+}
+
+func TestClaimJobLeasesByToken(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockRepository{claimable: true}
+	sc, _ := newTestScheduler(t, repo)
+
+	/*worker 1 of two instances claims the job, the second after the first one's lease was reaped*/
+	stale, err := sc.ClaimJob(ctx, &jobs.RedisJob{JobID: 1}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error claiming job: %v", err)
+	}
+	current, err := sc.ClaimJob(ctx, &jobs.RedisJob{JobID: 1}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error claiming job: %v", err)
+	}
+
+	if stale.LeaseToken == "" || stale.LeaseToken == current.LeaseToken {
+		t.Fatalf("expected a distinct lease token per claim, got %q and %q", stale.LeaseToken, current.LeaseToken)
+	}
+	if err := sc.ExtendLease(ctx, 1, stale.LeaseToken); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected the stale claim to have lost the lease, got %v", err)
+	}
+	if err := sc.ExtendLease(ctx, 1, current.LeaseToken); err != nil {
+		t.Errorf("expected the current claim to renew the lease, got %v", err)
+	}
+}
+
+func TestSchedulerRequeuesDeadInstanceJobs(t *testing.T) {
+	sc, mr := newTestScheduler(t, &MockRepository{})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	/*an instance which crashed after popping a job, its alive key already expired*/
+	mr.SetAdd("tickr:queue:default:instances", "crashed")
+	mr.Lpush("tickr:queue:default:processing:crashed", `{"job_id":5}`)
+
+	/*an instance which is still running keeps its jobs*/
+	mr.SetAdd("tickr:queue:default:instances", "running")
+	mr.Set("tickr:instance:running", "1")
+	mr.Lpush("tickr:queue:default:processing:running", `{"job_id":6}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	if mr.Exists("tickr:queue:default:processing:crashed") {
+		t.Errorf("expected processing list of crashed instance to be emptied")
+	}
+	if !mr.Exists("tickr:queue:default:processing:running") {
+		t.Errorf("expected processing list of running instance to be kept")
+	}
+
+	/*nobody reads the job channel, so the job is handed back on shutdown*/
+	ready, err := mr.List("tickr:queue:default:ready:normal")
+	if err != nil {
+		t.Fatalf("unexpected error reading ready queue: %v", err)
+	}
+	if len(ready) != 1 || ready[0] != `{"job_id":5}` {
+		t.Errorf("expected job of crashed instance in ready queue, got %v", ready)
+	}
+
+	instances, _ := mr.Members("tickr:queue:default:instances")
+	if len(instances) != 1 || instances[0] != "running" {
+		t.Errorf("expected only the running instance to stay registered, got %v", instances)
+	}
+}
+
+func TestPopReadyQueueWeightsPriorities(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	/*low priority jobs were queued first, then a flood of high priority ones*/
+	for i := 1; i <= 10; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), ScheduledAt: time.Now(), Priority: enums.Low})
+	}
+	for i := 11; i <= 40; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), ScheduledAt: time.Now(), Priority: enums.High})
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		sc.Run(runCtx)
+		close(done)
+	}()
+
+	var popped []enums.Priority
+	for range 20 {
+		job := <-sc.Jobs("default")
+		popped = append(popped, job.Priority)
+	}
+	cancel()
+	<-done
+
+	if popped[0] != enums.High {
+		t.Errorf("expected high priority job to be popped first, got %v", popped[0])
+	}
+
+	low := 0
+	for _, priority := range popped {
+		if priority == enums.Low {
+			low++
+		}
+	}
+	if low != 2 {
+		t.Errorf("expected low priority jobs to get 2 of 20 pops, got %d", low)
+	}
+}
+
+func TestSchedulerRecoveryKeepsPriority(t *testing.T) {
+	sc, mr := newTestScheduler(t, &MockRepository{
+		pending: []jobs.RedisJob{
+			{JobID: 1, ScheduledAt: time.Now().Add(-time.Minute), Priority: enums.High},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	/*nobody reads the job channel, so the promoted job is handed back to its ready queue on shutdown*/
+	ready, err := mr.List("tickr:queue:default:ready:high")
+	if err != nil {
+		t.Fatalf("unexpected error reading high priority queue: %v", err)
+	}
+	if len(ready) != 1 {
+		t.Errorf("expected recovered job in high priority queue, got %d jobs", len(ready))
+	}
+}
+
+func TestNamedQueuesAreSeparate(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis %v", err)
+	}
+	repo := &MockRepository{
+		pending: []jobs.RedisJob{
+			{JobID: 1, ScheduledAt: time.Now().Add(time.Hour)},
+			{JobID: 2, ScheduledAt: time.Now().Add(time.Hour), Queue: "bulk"},
+			{JobID: 3, ScheduledAt: time.Now().Add(time.Hour), Queue: "bulk"},
+		},
+	}
+	sc := scheduler.NewScheduler(scheduler.NewRedis(mr.Addr()), repo, "default", "bulk")
+
+	/*only the bulk queue lost its state*/
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sc.Run(ctx)
+		close(done)
+	}()
+
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 4, ScheduledAt: time.Now(), Queue: "bulk"})
+
+	select {
+	case job := <-sc.Jobs("bulk"):
+		if job.JobID != 4 {
+			t.Errorf("expected job 4 from bulk queue, got %d", job.JobID)
+		}
+	case job := <-sc.Jobs("default"):
+		t.Errorf("expected no job on default queue, got %d", job.JobID)
+	case <-time.After(time.Second):
+		t.Errorf("expected job on bulk queue")
+	}
+
+	cancel()
+	<-done
+
+	if members, _ := mr.ZMembers("tickr:queue:default:waiting"); len(members) != 0 {
+		t.Errorf("expected default queue not to be rebuilt, got %d jobs", len(members))
+	}
+	if members, _ := mr.ZMembers("tickr:queue:bulk:waiting"); len(members) != 2 {
+		t.Errorf("expected bulk queue to be rebuilt with 2 jobs, got %d", len(members))
+	}
+	if !mr.Exists("tickr:queue:bulk:epoch") {
+		t.Errorf("expected bulk queue epoch key to be set after recovery")
+	}
+	if sc.HasQueue("critical") {
+		t.Errorf("expected scheduler not to serve unconfigured queue")
+	}
+}
+
+func TestJobFinishedReleasesDependents(t *testing.T) {
+	now := time.Now()
+	repo := &MockRepository{
+		unblocked: map[int64][]jobs.Job{
+			1: {
+				{ID: 2, Status: enums.Pending, Priority: enums.High, ScheduledAt: now.Add(-time.Second)},
+				{ID: 3, Status: enums.Pending, Priority: enums.Normal, ScheduledAt: now.Add(time.Hour)},
+			},
+		},
+	}
+	sc, mr := newTestScheduler(t, repo)
+
+	sc.JobFinished(context.Background(), &jobs.Job{ID: 1, Status: enums.Completed})
+
+	ready, _ := mr.List("tickr:queue:default:ready:high")
+	if len(ready) != 1 {
+		t.Errorf("expected the due dependent in the ready queue, got %d jobs", len(ready))
+	}
+	waiting, _ := mr.ZMembers("tickr:queue:default:waiting")
+	if len(waiting) != 1 {
+		t.Errorf("expected the delayed dependent in the waiting queue, got %d jobs", len(waiting))
+	}
+}
+
+func TestJobFinishedCancelsDependentsDownTheChain(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            enums.Status
+		expectedCancelled []int64
+	}{
+		{
+			name:              "failed parent",
+			status:            enums.Failed,
+			expectedCancelled: []int64{2, 3, 4},
+		},
+		{
+			name:              "cancelled parent",
+			status:            enums.Cancelled,
+			expectedCancelled: []int64{2, 3, 4},
+		},
+		{
+			name:   "retrying parent",
+			status: enums.Retrying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockRepository{
+				children: map[int64][]int64{1: {2, 3}, 2: {4}},
+			}
+			sc, _ := newTestScheduler(t, repo)
+
+			sc.JobFinished(context.Background(), &jobs.Job{ID: 1, Status: tt.status})
+
+			if len(repo.cancelled) != len(tt.expectedCancelled) {
+				t.Fatalf("expected %v to be cancelled, got %v", tt.expectedCancelled, repo.cancelled)
+			}
+			for i, id := range tt.expectedCancelled {
+				if repo.cancelled[i] != id {
+					t.Errorf("expected %v to be cancelled, got %v", tt.expectedCancelled, repo.cancelled)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerResolvesDependenciesOnStartup(t *testing.T) {
+	repo := &MockRepository{
+		finished:  []jobs.Job{{ID: 1, Status: enums.Completed}},
+		unblocked: map[int64][]jobs.Job{1: {{ID: 2, Status: enums.Pending, ScheduledAt: time.Now()}}},
+	}
+	sc, mr := newTestScheduler(t, repo)
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sc.Run(ctx)
+
+	/*the fetcher may already have moved the job onto the processing list, which is handed back on shutdown*/
+	ready, _ := mr.List("tickr:queue:default:ready:normal")
+	if len(ready) != 1 {
+		t.Errorf("expected the unblocked job in the ready queue, got %d jobs", len(ready))
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	now := time.Now()
+	sc, mr := newTestScheduler(t, &MockRepository{})
+
+	batch := []*jobs.RedisJob{
+		{JobID: 1, ScheduledAt: now, Priority: enums.Normal},
+		{JobID: 2, ScheduledAt: now, Priority: enums.High},
+		{JobID: 3, ScheduledAt: now, Queue: "bulk", Priority: enums.Normal},
+		{JobID: 4, ScheduledAt: now.Add(time.Minute), Priority: enums.Normal},
+	}
+	if err := sc.PushBatch(context.Background(), batch, now); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := map[string]int{
+		"tickr:queue:default:ready:normal": 1,
+		"tickr:queue:default:ready:high":   1,
+		"tickr:queue:bulk:ready:normal":    1,
+		"tickr:queue:default:ready:notify": 1,
+		"tickr:queue:bulk:ready:notify":    1,
+	}
+	for key, n := range expected {
+		list, _ := mr.List(key)
+		if len(list) != n {
+			t.Errorf("expected %d entries in %v, got %d", n, key, len(list))
+		}
+	}
+
+	waiting, _ := mr.ZMembers("tickr:queue:default:waiting")
+	if len(waiting) != 1 {
+		t.Errorf("expected the delayed job in the waiting queue, got %d jobs", len(waiting))
+	}
+}
+
+func TestJobFinishedCompletesBatch(t *testing.T) {
+	batchID := int64(7)
+	tests := []struct {
+		name              string
+		status            enums.Status
+		expectedCompleted int
+		expectedReadyLen  int
+	}{
+		{
+			name:              "last job completed",
+			status:            enums.Completed,
+			expectedCompleted: 1,
+			expectedReadyLen:  1,
+		},
+		{
+			name:              "last job failed",
+			status:            enums.Failed,
+			expectedCompleted: 1,
+			expectedReadyLen:  1,
+		},
+		{
+			name:   "job retrying",
+			status: enums.Retrying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockRepository{
+				completable: map[int64]*jobs.Job{batchID: {ID: 100, Priority: enums.Normal, ScheduledAt: time.Now()}},
+			}
+			sc, mr := newTestScheduler(t, repo)
+
+			job := &jobs.Job{ID: 1, Status: tt.status, BatchID: &batchID}
+			sc.JobFinished(context.Background(), job)
+			/*a second finished job of a completed batch doesn't complete it again*/
+			sc.JobFinished(context.Background(), job)
+
+			if len(repo.completed) != tt.expectedCompleted {
+				t.Errorf("expected batch to be completed %d times, got %d", tt.expectedCompleted, len(repo.completed))
+			}
+			ready, _ := mr.List("tickr:queue:default:ready:normal")
+			if len(ready) != tt.expectedReadyLen {
+				t.Errorf("expected %d on complete jobs in ready queue, got %d", tt.expectedReadyLen, len(ready))
+			}
+		})
+	}
+}
+
+func TestJobFinishedQueuesSignedCallback(t *testing.T) {
+	secret := "s3cret"
+	lastError := "request failed with status 503"
+	tests := []struct {
+		name             string
+		status           enums.Status
+		secret           *string
+		lastError        *string
+		expectedCallback bool
+	}{
+		{
+			name:             "completed job with secret",
+			status:           enums.Completed,
+			secret:           &secret,
+			expectedCallback: true,
+		},
+		{
+			name:             "failed job without secret",
+			status:           enums.Failed,
+			lastError:        &lastError,
+			expectedCallback: true,
+		},
+		{
+			name:   "retrying job",
+			status: enums.Retrying,
+			secret: &secret,
+		},
+		{
+			name:   "cancelled job",
+			status: enums.Cancelled,
+			secret: &secret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var signature string
+			var event map[string]any
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				signature = r.Header.Get(worker.SignatureHeader)
+				timestamp := r.Header.Get(worker.TimestampHeader)
+				if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if tt.secret != nil {
+					mac := hmac.New(sha256.New, []byte(*tt.secret))
+					mac.Write([]byte(timestamp + "."))
+					mac.Write(body)
+					if signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+				}
+				json.Unmarshal(body, &event)
+			}))
+			defer receiver.Close()
+
+			repo := &MockRepository{}
+			sc, mr := newTestScheduler(t, repo)
+
+			url := receiver.URL
+			job := &jobs.Job{
+				ID:             5,
+				JobType:        "report",
+				Status:         tt.status,
+				Queue:          jobs.DefaultQueue,
+				Attempt:        2,
+				Result:         json.RawMessage(`{"data":"ok"}`),
+				LastError:      tt.lastError,
+				CallbackURL:    &url,
+				CallbackSecret: tt.secret,
+			}
+			sc.JobFinished(context.Background(), job)
+			/*the hook running again for the same job doesn't deliver twice*/
+			sc.JobFinished(context.Background(), job)
+
+			ready, _ := mr.List("tickr:queue:default:ready:normal")
+			if !tt.expectedCallback {
+				if len(repo.saved) != 0 || len(ready) != 0 {
+					t.Fatalf("expected no callback, got %d saved and %d ready jobs", len(repo.saved), len(ready))
+				}
+				return
+			}
+			if len(repo.saved) != 1 || len(ready) != 1 {
+				t.Fatalf("expected a single callback job, got %d saved and %d ready jobs", len(repo.saved), len(ready))
+			}
+
+			delivery := repo.saved[0]
+			if delivery.JobType != "webhook" || delivery.RetryPolicy == nil || delivery.MaxAttempts <= 1 {
+				t.Fatalf("unexpected callback job %+v", delivery)
+			}
+			if err := worker.NewExecutor(worker.DefaultRegistry()).ExecuteJob(context.Background(), &delivery); err != nil {
+				t.Fatalf("unexpected error delivering callback %v", err)
+			}
+
+			if tt.secret == nil && signature != "" {
+				t.Errorf("expected unsigned callback, got signature %q", signature)
+			}
+			if event["jobID"] != float64(5) || event["status"] != string(tt.status) || event["attempt"] != float64(2) {
+				t.Errorf("unexpected callback body %v", event)
+			}
+			if result, _ := event["result"].(map[string]any); result["data"] != "ok" {
+				t.Errorf("expected job result in callback, got %v", event["result"])
+			}
+			if tt.lastError != nil && event["lastError"] != *tt.lastError {
+				t.Errorf("expected last error in callback, got %v", event["lastError"])
+			}
+		})
+	}
+}
+
+func TestSchedulerPublishesJobEvents(t *testing.T) {
+	sc, mr := newTestScheduler(t, &MockRepository{})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sc.Run(ctx)
+	}()
+
+	events := sc.Events(context.Background())
+	/*never read, so it falls behind and gets dropped*/
+	slow := sc.Events(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for mr.PubSubNumSub("tickr:jobs:events")["tickr:jobs:events"] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduler didn't subscribe to job events")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	total := 300
+	received := make(chan int)
+	go func() {
+		count := 0
+		for event := range events {
+			if event.JobID != 9 || event.Status != enums.Completed {
+				t.Errorf("unexpected event %+v", event)
+			}
+			count++
+			if count == total {
+				break
+			}
+		}
+		received <- count
+	}()
+
+	for range total {
+		if err := sc.UpdateJob(context.Background(), &jobs.Job{ID: 9, Status: enums.Completed}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	select {
+	case count := <-received:
+		if count != total {
+			t.Errorf("expected %d events, got %d", total, count)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected every event to reach the subscriber")
+	}
+
+	dropped := 0
+	for range slow {
+		dropped++
+	}
+	if dropped >= total {
+		t.Errorf("expected the slow subscriber to be dropped, got all %d events", dropped)
+	}
+
+	cancel()
+	<-stopped
+	if _, ok := <-events; ok {
+		t.Errorf("expected subscriber channels to be closed on shutdown")
+	}
+}
@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/worker"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		method             string
+		path               string
+		expectedStatusCode int
+	}{
+		{name: "own job", key: "acme", method: http.MethodGet, path: "/api/v2/jobs/2", expectedStatusCode: http.StatusOK},
+		{name: "job of another tenant", key: "acme", method: http.MethodGet, path: "/api/v2/jobs/1", expectedStatusCode: http.StatusNotFound},
+		{name: "default tenant can't see tenant job", key: "admin", method: http.MethodGet, path: "/api/v2/jobs/2", expectedStatusCode: http.StatusNotFound},
+		{name: "cancel job of another tenant", key: "acme", method: http.MethodDelete, path: "/api/v2/jobs/1", expectedStatusCode: http.StatusNotFound},
+		{name: "cancel own job", key: "acme", method: http.MethodDelete, path: "/api/v2/jobs/2", expectedStatusCode: http.StatusOK},
+		{name: "attempts of another tenant's job", key: "acme", method: http.MethodGet, path: "/api/v2/jobs/1/attempts", expectedStatusCode: http.StatusNotFound},
+		{name: "replay job of another tenant", key: "acme", method: http.MethodPost, path: "/api/v2/jobs/3/replay", expectedStatusCode: http.StatusNotFound},
+		{name: "batch of another tenant", key: "acme", method: http.MethodGet, path: "/api/v2/batches/1", expectedStatusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{
+				jobs: map[int64]*jobs.Job{
+					1: {ID: 1, JobType: "email", Status: enums.Pending},
+					2: {ID: 2, Tenant: "acme", JobType: "email", Status: enums.Pending},
+					3: {ID: 3, JobType: "email", Status: enums.Failed},
+				},
+				batches: map[int64]*jobs.Batch{
+					1: {ID: 1, Tenant: jobs.DefaultTenant, Total: 1},
+				},
+			}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			handler.AdminKey = testAdminKey
+			keys := map[string]string{
+				"admin": testAdminKey,
+				"acme":  createAPIKey(t, handler, `{"tenant":"acme", "name":"acme", "scopes":["submit","read"]}`),
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v2/jobs/{id}", handler.Auth(enums.ReadScope, handler.GetJob))
+			mux.HandleFunc("DELETE /api/v2/jobs/{id}", handler.Auth(enums.SubmitScope, handler.CancelJob))
+			mux.HandleFunc("GET /api/v2/jobs/{id}/attempts", handler.Auth(enums.ReadScope, handler.GetJobAttempts))
+			mux.HandleFunc("POST /api/v2/jobs/{id}/replay", handler.Auth(enums.SubmitScope, handler.ReplayJob))
+			mux.HandleFunc("GET /api/v2/batches/{id}", handler.Auth(enums.ReadScope, handler.GetBatch))
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+keys[tt.key])
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusNotFound && s.jobs[1].Status != enums.Pending {
+				t.Errorf("expected job of another tenant to be left alone, got %v", s.jobs[1].Status)
+			}
+		})
+	}
+}
+
+func TestTenantSubmitAndList(t *testing.T) {
+	s := &MockScheduler{}
+	handler := api.NewHandler(s, worker.DefaultRegistry())
+	handler.AdminKey = testAdminKey
+	acme := createAPIKey(t, handler, `{"tenant":"acme", "name":"acme", "scopes":["submit","read"]}`)
+	globex := createAPIKey(t, handler, `{"tenant":"globex", "name":"globex", "scopes":["submit","read"]}`)
+
+	/*the same idempotency key doesn't collide across tenants*/
+	for _, key := range []string{acme, globex} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(`{"jobtype":"email", "payload":{}}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Idempotency-Key", "order-1")
+		rr := httptest.NewRecorder()
+		handler.Auth(enums.SubmitScope, handler.SubmitJob).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected job to be created, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	if len(s.jobs) != 2 {
+		t.Fatalf("expected a job per tenant, got %d", len(s.jobs))
+	}
+	if s.jobs[1].Tenant != "acme" || s.jobs[2].Tenant != "globex" {
+		t.Errorf("expected jobs of acme and globex, got %q and %q", s.jobs[1].Tenant, s.jobs[2].Tenant)
+	}
+	for _, job := range s.readyQueue {
+		if job.Tenant == "" {
+			t.Errorf("expected job %d queued with its tenant", job.JobID)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/jobs", nil)
+	req.Header.Set("Authorization", "Bearer "+globex)
+	rr := httptest.NewRecorder()
+	handler.Auth(enums.ReadScope, handler.ListJobs).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected jobs to be listed, got %d", rr.Code)
+	}
+	if s.filter.Tenant != "globex" {
+		t.Errorf("expected jobs filtered by tenant globex, got %q", s.filter.Tenant)
+	}
+}
+
+func TestTenantAdministration(t *testing.T) {
+	tests := []struct {
+		name               string
+		key                string
+		method             string
+		path               string
+		body               string
+		expectedStatusCode int
+	}{
+		{name: "operator creates key of a tenant", key: "admin", method: http.MethodPost, path: "/api/v2/api-keys", body: `{"tenant":"globex", "name":"globex", "scopes":["read"]}`, expectedStatusCode: http.StatusOK},
+		{name: "tenant admin creates own key", key: "acme", method: http.MethodPost, path: "/api/v2/api-keys", body: `{"name":"worker", "scopes":["read"]}`, expectedStatusCode: http.StatusOK},
+		{name: "tenant admin creates key of another tenant", key: "acme", method: http.MethodPost, path: "/api/v2/api-keys", body: `{"tenant":"globex", "name":"globex", "scopes":["read"]}`, expectedStatusCode: http.StatusForbidden},
+		{name: "invalid tenant name", key: "admin", method: http.MethodPost, path: "/api/v2/api-keys", body: `{"tenant":"Acme Inc", "name":"acme", "scopes":["read"]}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "tenant admin revokes key of another tenant", key: "acme", method: http.MethodDelete, path: "/api/v2/api-keys/1", expectedStatusCode: http.StatusNotFound},
+		{name: "operator sets quotas", key: "admin", method: http.MethodPut, path: "/api/v2/tenants/acme", body: `{"maxRunning":5, "rateLimit":10}`, expectedStatusCode: http.StatusOK},
+		{name: "negative quota", key: "admin", method: http.MethodPut, path: "/api/v2/tenants/acme", body: `{"maxRunning":-1}`, expectedStatusCode: http.StatusBadRequest},
+		{name: "tenant admin sets quotas", key: "acme", method: http.MethodPut, path: "/api/v2/tenants/acme", body: `{"maxRunning":500}`, expectedStatusCode: http.StatusForbidden},
+		{name: "tenant admin lists tenants", key: "acme", method: http.MethodGet, path: "/api/v2/tenants", expectedStatusCode: http.StatusForbidden},
+		{name: "operator lists tenants", key: "admin", method: http.MethodGet, path: "/api/v2/tenants", expectedStatusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &MockScheduler{}
+			handler := api.NewHandler(s, worker.DefaultRegistry())
+			handler.AdminKey = testAdminKey
+			createAPIKey(t, handler, `{"name":"dashboard", "scopes":["read"]}`)
+			keys := map[string]string{
+				"admin": testAdminKey,
+				"acme":  createAPIKey(t, handler, `{"tenant":"acme", "name":"acme admin", "scopes":["admin"]}`),
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/v2/api-keys", handler.Auth(enums.AdminScope, handler.CreateAPIKey))
+			mux.HandleFunc("DELETE /api/v2/api-keys/{id}", handler.Auth(enums.AdminScope, handler.RevokeAPIKey))
+			mux.HandleFunc("GET /api/v2/tenants", handler.Auth(enums.AdminScope, handler.ListTenants))
+			mux.HandleFunc("PUT /api/v2/tenants/{name}", handler.Auth(enums.AdminScope, handler.SaveTenant))
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+keys[tt.key])
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatusCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatusCode, rr.Code, rr.Body.String())
+			}
+			if tt.method == http.MethodPut && rr.Code == http.StatusOK {
+				var res struct {
+					Data jobs.Tenant `json:"data"`
+				}
+				json.NewDecoder(rr.Body).Decode(&res)
+				if len(s.tenants) != 1 || s.tenants[0].MaxRunning != 5 || res.Data.RateLimit != 10 {
+					t.Errorf("expected quotas of acme to be saved, got %+v", s.tenants)
+				}
+			}
+		})
+	}
+}
+
+func TestPopReadyQueueRoundRobinsTenants(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	/*the default tenant flooded the queue before acme queued anything*/
+	for i := 1; i <= 20; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), ScheduledAt: time.Now()})
+	}
+	for i := 21; i <= 25; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), Tenant: "acme", ScheduledAt: time.Now()})
+	}
+
+	if ready, _ := mr.List("tickr:tenant:acme:queue:default:ready:normal"); len(ready) != 5 {
+		t.Errorf("expected 5 jobs in acme's ready queue, got %d", len(ready))
+	}
+	if ready, _ := mr.List("tickr:queue:default:ready:normal"); len(ready) != 20 {
+		t.Errorf("expected 20 jobs in the default tenant's ready queue, got %d", len(ready))
+	}
+	if ok, _ := mr.SIsMember("tickr:queue:default:tenants", "acme"); !ok {
+		t.Errorf("expected acme to be registered on the default queue")
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		sc.Run(runCtx)
+		close(done)
+	}()
+
+	acme := 0
+	for range 10 {
+		job := <-sc.Jobs("default")
+		if job.Tenant == "acme" {
+			acme++
+		}
+	}
+	cancel()
+	<-done
+
+	if acme != 5 {
+		t.Errorf("expected acme to get 5 of 10 pops, got %d", acme)
+	}
+}
+
+func TestPopReadyQueueEnforcesMaxRunning(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{
+		tenants: []jobs.Tenant{{Name: "acme", MaxRunning: 1}},
+	})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	for i := 1; i <= 2; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), Tenant: "acme", ScheduledAt: time.Now()})
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		sc.Run(runCtx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	first := <-sc.Jobs("default")
+	select {
+	case job := <-sc.Jobs("default"):
+		t.Fatalf("expected acme to be held at 1 running job, got job %d", job.JobID)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	/*the mock repository refuses the claim, which frees the slot*/
+	sc.ClaimJob(ctx, first, 1)
+
+	select {
+	case job := <-sc.Jobs("default"):
+		if job.JobID != 2 {
+			t.Errorf("expected job 2 once the slot was freed, got %d", job.JobID)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected job 2 once the slot was freed")
+	}
+}
+
+func TestPopReadyQueueEnforcesRateLimit(t *testing.T) {
+	ctx := context.Background()
+	sc, mr := newTestScheduler(t, &MockRepository{
+		tenants: []jobs.Tenant{{Name: "acme", RateLimit: 2}},
+	})
+	mr.Set("tickr:queue:default:epoch", "1")
+
+	for i := 1; i <= 3; i++ {
+		sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: int64(i), Tenant: "acme", ScheduledAt: time.Now()})
+	}
+	sc.PushReadyQueue(ctx, &jobs.RedisJob{JobID: 4, ScheduledAt: time.Now()})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		sc.Run(runCtx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	popped := map[int64]bool{}
+	for range 3 {
+		job := <-sc.Jobs("default")
+		popped[job.JobID] = true
+	}
+	if !popped[4] {
+		t.Errorf("expected the default tenant not to be held back by acme's rate limit, got %v", popped)
+	}
+
+	select {
+	case job := <-sc.Jobs("default"):
+		t.Fatalf("expected acme to be held at 2 jobs per second, got job %d", job.JobID)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	/*the next second starts a new window*/
+	mr.FastForward(time.Second)
+
+	select {
+	case job := <-sc.Jobs("default"):
+		if job.Tenant != "acme" {
+			t.Errorf("expected the last acme job, got %+v", job)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the last acme job in the next second")
+	}
+}
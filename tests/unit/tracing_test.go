@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blueberry-adii/tickr/internal/api"
+	"github.com/blueberry-adii/tickr/internal/enums"
+	"github.com/blueberry-adii/tickr/internal/jobs"
+	"github.com/blueberry-adii/tickr/internal/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestJobTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	ctx := context.Background()
+	repo := &MockRepository{}
+	sc, mr := newTestScheduler(t, repo)
+	defer mr.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/jobs", api.NewHandler(sc, worker.DefaultRegistry()).SubmitJob)
+	body := `{"jobtype":"http","delay":60,"payload":{"url":"` + receiver.URL + `","method":"POST"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/jobs", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	api.Tracing(mux).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected job to be submitted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(repo.saved) != 1 || repo.saved[0].TraceParent == nil {
+		t.Fatalf("expected job to be saved with its trace parent, got %+v", repo.saved)
+	}
+
+	/*makes the delayed job due right away*/
+	waiting, _ := mr.ZMembers("tickr:queue:default:waiting")
+	if len(waiting) != 1 {
+		t.Fatalf("expected 1 waiting job, got %d", len(waiting))
+	}
+	mr.ZAdd("tickr:queue:default:waiting", 0, waiting[0])
+	promoted, err := sc.PopWaitingQueue(ctx)
+	if err != nil || len(promoted) != 1 {
+		t.Fatalf("expected 1 promoted job, got %d: %v", len(promoted), err)
+	}
+	if promoted[0].TraceParent == "" {
+		t.Fatal("expected the queued job to carry its trace parent")
+	}
+
+	job := repo.saved[0]
+	job.ID = promoted[0].JobID
+	job.Status = enums.Pending
+	job.ScheduledAt = time.Now()
+	d := &MockDispatcher{ch: make(chan *jobs.RedisJob, 1), job: &job}
+	d.ch <- promoted[0]
+	close(d.ch)
+	worker.NewWorker(1, "default", d, worker.DefaultRegistry()).Run(ctx)
+
+	if job.Status != enums.Completed {
+		t.Fatalf("expected job to complete, got %v", job.Status)
+	}
+
+	spans := exporter.GetSpans()
+	traceID := spans[0].SpanContext.TraceID()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+		if span.SpanContext.TraceID() != traceID {
+			t.Errorf("expected span %v in trace %v, got %v", span.Name, traceID, span.SpanContext.TraceID())
+		}
+	}
+	for _, name := range []string{"POST /api/v2/jobs", "queue.push", "queue.promote", "job.execute", "job.handle"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("expected span %v, got %v", name, names)
+		}
+	}
+
+	traceParent := <-received
+	if !strings.Contains(traceParent, traceID.String()) {
+		t.Errorf("expected traceparent of trace %v sent downstream, got %q", traceID, traceParent)
+	}
+}
@@ -2,28 +2,54 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/blueberry-adii/tickr/internal/database"
 	"github.com/blueberry-adii/tickr/internal/enums"
 	"github.com/blueberry-adii/tickr/internal/jobs"
 	"github.com/blueberry-adii/tickr/internal/worker"
 )
 
 type MockDispatcher struct {
-	ch      chan *jobs.RedisJob
-	job     *jobs.Job
-	retried []*jobs.RedisJob
-	updated []*jobs.Job
+	ch        chan *jobs.RedisJob
+	job       *jobs.Job
+	retried   []*jobs.RedisJob
+	updated   []*jobs.Job
+	errors    []jobs.JobError
+	attempts  []jobs.JobAttempt
+	finished  []enums.Status
+	stopped   []enums.Status
+	cancelJob bool
+	leaseLost bool
 }
 
-func (d *MockDispatcher) Jobs() <-chan *jobs.RedisJob {
+func (d *MockDispatcher) Jobs(queue string) <-chan *jobs.RedisJob {
 	return d.ch
 }
-func (d *MockDispatcher) GetJob(ctx context.Context, jobID int64) (*jobs.Job, error) {
+func (d *MockDispatcher) ClaimJob(ctx context.Context, redisJob *jobs.RedisJob, workerID int) (*jobs.Job, error) {
+	if d.job.Status != enums.Pending && d.job.Status != enums.Retrying {
+		return nil, database.ErrJobNotClaimable
+	}
+	now := time.Now()
+	d.job.Status = enums.Executing
+	d.job.WorkerID = &workerID
+	d.job.StartedAt = &now
+	d.updated = append(d.updated, d.job)
 	return d.job, nil
 }
+func (d *MockDispatcher) ExtendLease(ctx context.Context, jobID int64, leaseToken string) error {
+	return nil
+}
 func (d *MockDispatcher) UpdateJob(ctx context.Context, job *jobs.Job) error {
+	if d.leaseLost {
+		return database.ErrLeaseLost
+	}
 	if job.Status != enums.Executing {
 		job.WorkerID = nil
 	}
@@ -35,12 +61,36 @@ func (d *MockDispatcher) PushWaitingQueue(ctx context.Context, job *jobs.RedisJo
 	return nil
 }
 
+func (d *MockDispatcher) SaveJobError(ctx context.Context, jobError jobs.JobError) error {
+	d.errors = append(d.errors, jobError)
+	return nil
+}
+func (d *MockDispatcher) SaveJobAttempt(ctx context.Context, attempt jobs.JobAttempt) error {
+	d.attempts = append(d.attempts, attempt)
+	return nil
+}
+func (d *MockDispatcher) JobFinished(ctx context.Context, job *jobs.Job) {
+	d.finished = append(d.finished, job.Status)
+}
+func (d *MockDispatcher) JobStopped(ctx context.Context, job *jobs.Job) {
+	d.stopped = append(d.stopped, job.Status)
+}
+func (d *MockDispatcher) WatchCancel(ctx context.Context, jobID int64) (context.Context, context.CancelFunc) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	if d.cancelJob {
+		cancel(jobs.ErrCancelled)
+	}
+	return jobCtx, func() { cancel(nil) }
+}
+
 func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 	tests := []struct {
 		name            string
 		job             *jobs.Job
 		expectedStatus  enums.Status
 		expectedRetries int
+		expectedErrors  int
+		expectFinished  bool
 	}{
 		{
 			name: "status retrying after 1 retry",
@@ -54,6 +104,7 @@ func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 			},
 			expectedStatus:  enums.Retrying,
 			expectedRetries: 1,
+			expectedErrors:  1,
 		},
 		{
 			name: "fails after max attempts",
@@ -67,6 +118,8 @@ func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 			},
 			expectedStatus:  enums.Failed,
 			expectedRetries: 0,
+			expectedErrors:  1,
+			expectFinished:  true,
 		},
 		{
 			name: "succeeds using http or email job type",
@@ -85,6 +138,7 @@ func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 			},
 			expectedStatus:  enums.Completed,
 			expectedRetries: 0,
+			expectFinished:  true,
 		},
 	}
 
@@ -97,7 +151,7 @@ func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 				retried: make([]*jobs.RedisJob, 0),
 				updated: make([]*jobs.Job, 0),
 			}
-			w := worker.NewWorker(i+1, d)
+			w := worker.NewWorker(i+1, "default", d, worker.DefaultRegistry())
 
 			job := jobs.RedisJob{
 				JobID:       d.job.ID,
@@ -117,6 +171,322 @@ func TestWorkerMaxAttemptsAndRetryLogic(t *testing.T) {
 			if len(d.retried) != tt.expectedRetries {
 				t.Errorf("expected %d retries, got %d", tt.expectedRetries, len(d.retried))
 			}
+
+			if len(d.errors) != tt.expectedErrors {
+				t.Errorf("expected %d recorded errors, got %d", tt.expectedErrors, len(d.errors))
+			}
+
+			if finished := len(d.finished) == 1 && d.finished[0] == tt.expectedStatus; finished != tt.expectFinished {
+				t.Errorf("expected finished hook %v, got %v", tt.expectFinished, d.finished)
+			}
+
+			if len(d.attempts) != 1 {
+				t.Fatalf("expected 1 recorded attempt, got %d", len(d.attempts))
+			}
+			attempt := d.attempts[0]
+			if attempt.Status != tt.expectedStatus || attempt.WorkerID == nil || *attempt.WorkerID != i+1 || attempt.StartedAt == nil {
+				t.Errorf("unexpected attempt %+v", attempt)
+			}
+			if (attempt.NextRetryAt != nil) != (tt.expectedRetries > 0) {
+				t.Errorf("expected next retry time only for retried jobs, got %v", attempt.NextRetryAt)
+			}
+			if (attempt.Error != nil) != (tt.expectedErrors > 0) {
+				t.Errorf("expected error only for failed attempts, got %v", attempt.Error)
+			}
+		})
+	}
+}
+
+func TestFinishedAttempt(t *testing.T) {
+	started := time.Now()
+	finished := started.Add(1500 * time.Millisecond)
+	retryAt := finished.Add(time.Minute)
+	workerID := 4
+
+	tests := []struct {
+		name               string
+		result             string
+		expectedSnippetLen int
+	}{
+		{
+			name:               "short result is kept whole",
+			result:             `{"data":"ok"}`,
+			expectedSnippetLen: 13,
+		},
+		{
+			name:               "long result is cut",
+			result:             `{"data":"` + strings.Repeat("é", 600) + `"}`,
+			expectedSnippetLen: 511,
+		},
+		{
+			name: "no result",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &jobs.Job{
+				ID:         3,
+				Attempt:    2,
+				Status:     enums.Retrying,
+				StartedAt:  &started,
+				FinishedAt: &finished,
+				Result:     json.RawMessage(tt.result),
+			}
+			attempt := job.FinishedAttempt(&workerID, &retryAt)
+
+			if attempt.JobID != 3 || attempt.Attempt != 2 || attempt.DurationMs != 1500 || !attempt.NextRetryAt.Equal(retryAt) {
+				t.Errorf("unexpected attempt %+v", attempt)
+			}
+			if tt.expectedSnippetLen == 0 {
+				if attempt.ResultSnippet != nil {
+					t.Errorf("expected no result snippet, got %q", *attempt.ResultSnippet)
+				}
+				return
+			}
+			if attempt.ResultSnippet == nil || len(*attempt.ResultSnippet) != tt.expectedSnippetLen || !utf8.ValidString(*attempt.ResultSnippet) {
+				t.Errorf("expected valid snippet of %d bytes, got %v", tt.expectedSnippetLen, attempt.ResultSnippet)
+			}
+		})
+	}
+}
+
+func TestWorkerSkipsCancelledJob(t *testing.T) {
+	d := &MockDispatcher{
+		ch: make(chan *jobs.RedisJob, 1),
+		job: &jobs.Job{
+			ID:          1,
+			JobType:     "email",
+			Status:      enums.Cancelled,
+			MaxAttempts: 3,
+		},
+	}
+	w := worker.NewWorker(1, "default", d, worker.DefaultRegistry())
+
+	d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+	close(d.ch)
+
+	w.Run(context.Background())
+
+	if len(d.updated) != 0 {
+		t.Errorf("expected cancelled job to be skipped, got %d updates", len(d.updated))
+	}
+	if len(d.retried) != 0 {
+		t.Errorf("expected no retries, got %d", len(d.retried))
+	}
+}
+
+/*
+registry with a "block" job type which runs until its context is done
+*/
+func blockingRegistry(onStart func()) *worker.Registry {
+	registry := worker.DefaultRegistry()
+	registry.Register("block", worker.HandlerFunc(func(ctx context.Context, job *jobs.Job) error {
+		if onStart != nil {
+			onStart()
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	return registry
+}
+
+func TestWorkerCancelledWhileExecuting(t *testing.T) {
+	d := &MockDispatcher{
+		ch: make(chan *jobs.RedisJob, 1),
+		job: &jobs.Job{
+			ID:          1,
+			JobType:     "block",
+			Status:      enums.Pending,
+			MaxAttempts: 3,
+		},
+		cancelJob: true,
+		/*cancelling the job dropped its lease*/
+		leaseLost: true,
+	}
+	w := worker.NewWorker(1, "default", d, blockingRegistry(nil))
+
+	d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+	close(d.ch)
+
+	w.Run(context.Background())
+
+	if len(d.attempts) != 1 {
+		t.Fatalf("expected 1 attempt, got %d", len(d.attempts))
+	}
+	attempt := d.attempts[0]
+	if attempt.Status != enums.Cancelled || attempt.Attempt != 1 || attempt.WorkerID == nil || *attempt.WorkerID != 1 {
+		t.Errorf("expected cancelled attempt 1 on worker 1, got %+v", attempt)
+	}
+	if attempt.Error == nil || *attempt.Error != jobs.ErrCancelled.Error() {
+		t.Errorf("expected attempt error %q, got %v", jobs.ErrCancelled, attempt.Error)
+	}
+	if len(d.errors) != 1 || d.errors[0].Attempt != 1 {
+		t.Errorf("expected the error of attempt 1 to be saved, got %+v", d.errors)
+	}
+	if len(d.stopped) != 1 || d.stopped[0] != enums.Cancelled {
+		t.Errorf("expected the cancelled job to free its slot, got %v", d.stopped)
+	}
+	/*the scheduler ran the hook when it cancelled the job*/
+	if len(d.retried) != 0 || len(d.finished) != 0 {
+		t.Errorf("expected no retries or finished hook, got %d retries, %d finished", len(d.retried), len(d.finished))
+	}
+}
+
+func TestWorkerDropsOutcomeOfLostJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobType string
+	}{
+		{name: "completed", jobType: "email"},
+		{name: "failed with retries left", jobType: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &MockDispatcher{
+				ch: make(chan *jobs.RedisJob, 1),
+				job: &jobs.Job{
+					ID:          1,
+					JobType:     tt.jobType,
+					Payload:     json.RawMessage(`{"to":"luffy", "from":"aditya", "body":"hello world"}`),
+					Status:      enums.Pending,
+					MaxAttempts: 3,
+				},
+				/*the job was cancelled or reaped and claimed by another worker while executing*/
+				leaseLost: true,
+			}
+			w := worker.NewWorker(1, "default", d, worker.DefaultRegistry())
+
+			d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+			close(d.ch)
+
+			w.Run(context.Background())
+
+			if len(d.retried) != 0 || len(d.attempts) != 0 || len(d.errors) != 0 || len(d.finished) != 0 {
+				t.Errorf("expected outcome to be dropped, got %d retries, %d attempts, %d errors, %d finished",
+					len(d.retried), len(d.attempts), len(d.errors), len(d.finished))
+			}
+		})
+	}
+}
+
+func TestWorkerInterruptedByShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &MockDispatcher{
+		ch: make(chan *jobs.RedisJob, 1),
+		job: &jobs.Job{
+			ID:          1,
+			JobType:     "block",
+			Status:      enums.Retrying,
+			Attempt:     1,
+			MaxAttempts: 3,
+		},
+	}
+	w := worker.NewWorker(1, "default", d, blockingRegistry(cancel))
+
+	d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+
+	w.Run(ctx)
+
+	last := d.updated[len(d.updated)-1]
+	if last.Status != enums.Retrying {
+		t.Errorf("expected %v, got %v", enums.Retrying, last.Status)
+	}
+	if last.Attempt != 1 {
+		t.Errorf("expected interrupted attempt not to count, got attempt %d", last.Attempt)
+	}
+	if len(d.retried) != 1 {
+		t.Errorf("expected job to be sent back to waiting queue, got %d", len(d.retried))
+	}
+}
+
+func TestWorkerAppliesRetryPolicy(t *testing.T) {
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer notFound.Close()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	policy := &jobs.RetryPolicy{
+		Strategy: enums.Fixed,
+		Base:     30,
+		RetryOn:  []enums.ErrorClass{enums.ServerError, enums.NetworkError},
+	}
+
+	tests := []struct {
+		name            string
+		url             string
+		policy          *jobs.RetryPolicy
+		expectedStatus  enums.Status
+		expectedRetries int
+	}{
+		{
+			name:            "4xx fails right away",
+			url:             notFound.URL,
+			policy:          policy,
+			expectedStatus:  enums.Failed,
+			expectedRetries: 0,
+		},
+		{
+			name:            "4xx fails right away under the default policy",
+			url:             notFound.URL,
+			expectedStatus:  enums.Failed,
+			expectedRetries: 0,
+		},
+		{
+			name:            "5xx is retried after the fixed delay",
+			url:             unavailable.URL,
+			policy:          policy,
+			expectedStatus:  enums.Retrying,
+			expectedRetries: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &MockDispatcher{
+				ch: make(chan *jobs.RedisJob, 1),
+				job: &jobs.Job{
+					ID:          1,
+					JobType:     "http",
+					Payload:     []byte(`{"url":"` + tt.url + `","method":"GET"}`),
+					Status:      enums.Pending,
+					MaxAttempts: 5,
+					RetryPolicy: tt.policy,
+				},
+			}
+			w := worker.NewWorker(1, "default", d, worker.DefaultRegistry())
+
+			d.ch <- &jobs.RedisJob{JobID: 1, ScheduledAt: time.Now()}
+			close(d.ch)
+
+			start := time.Now()
+			w.Run(context.Background())
+
+			final := d.updated[len(d.updated)-1]
+			if final.Status != tt.expectedStatus {
+				t.Errorf("expected %v, got %v", tt.expectedStatus, final.Status)
+			}
+			if final.Attempt != 1 {
+				t.Errorf("expected 1 attempt, got %d", final.Attempt)
+			}
+			if len(d.retried) != tt.expectedRetries {
+				t.Fatalf("expected %d retries, got %d", tt.expectedRetries, len(d.retried))
+			}
+
+			if tt.expectedRetries > 0 {
+				delay := d.retried[0].ScheduledAt.Sub(start)
+				if delay < 30*time.Second || delay > 31*time.Second {
+					t.Errorf("expected retry in 30s, got %v", delay)
+				}
+			}
 		})
 	}
 }